@@ -2,10 +2,12 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/rpj5582/gochat/example/shared"
 	"github.com/rpj5582/gochat/modules/client"
@@ -37,38 +39,40 @@ func main() {
 		port = "20000"
 	}
 
-	client, err := client.NewTCPClient(65535)
+	client, err := client.NewTCPClient(65535, client.WithOnDisconnected(func(err error) {
+		if err != nil {
+			fmt.Printf("disconnected from server: %v\n", err)
+			return
+		}
+
+		fmt.Println("disconnected from server")
+	}), client.WithAppVersion(shared.AppVersion))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	client.RegisterPacketType(&shared.ConnectResponse{}, func(conn net.Conn, p common.Packet) {
-		connectedResponse := p.(*shared.ConnectResponse)
-		if !connectedResponse.Connected {
-			fmt.Printf("server rejected connection request: %s\n", connectedResponse.ErrMessage)
-			conn.Close()
-			return
-		}
-
-		fmt.Printf("You have joined the chat\n")
-	})
+	// Connect responses answer the connect request's call, so they do not need a callback
+	client.RegisterNamedPacketType(shared.ConnectResponsePacketName, &shared.ConnectResponse{}, nil)
 
-	client.RegisterPacketType(&shared.ConnectedPacket{}, func(conn net.Conn, p common.Packet) {
+	client.RegisterNamedPacketType(shared.ConnectedPacketName, &shared.ConnectedPacket{}, func(conn net.Conn, p common.Packet) {
 		connectedPacket := p.(*shared.ConnectedPacket)
 		fmt.Printf("%s has join the chat\n", connectedPacket.ClientName)
 	})
 
-	client.RegisterPacketType(&shared.MessagePacket{}, func(conn net.Conn, p common.Packet) {
+	client.RegisterNamedPacketType(shared.MessagePacketName, &shared.MessagePacket{}, func(conn net.Conn, p common.Packet) {
 		messagePacket := p.(*shared.MessagePacket)
 		fmt.Println(messagePacket.Message)
 	})
 
-	client.RegisterPacketType(&shared.DisconnectedPacket{}, func(conn net.Conn, p common.Packet) {
+	client.RegisterNamedPacketType(shared.DisconnectedPacketName, &shared.DisconnectedPacket{}, func(conn net.Conn, p common.Packet) {
 		disconnectPacket := p.(*shared.DisconnectedPacket)
 		fmt.Printf("%s has left the chat\n", disconnectPacket.ClientName)
 	})
 
+	// The client only sends connect requests, but must register them for the server to know them by name
+	client.RegisterNamedPacketType(shared.ConnectRequestPacketName, &shared.ConnectRequest{}, nil)
+
 	if err := client.Connect(addr + ":" + port); err != nil {
 		fmt.Println(err)
 		return
@@ -76,21 +80,25 @@ func main() {
 
 	fmt.Printf("connected to %s\n", addr+":"+port)
 
-	if err := client.SendPacket(&shared.ConnectRequest{ClientName: "Devin"}); err != nil {
+	go client.Run(context.Background())
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	resp, err := client.Call(ctx, &shared.ConnectRequest{ClientName: "Devin"})
+	cancelFunc()
+	if err != nil {
 		fmt.Println(err)
+		client.Disconnect()
 		return
 	}
 
-	go func() {
-		for {
-			err := client.ReceivePacket()
-			if err != nil {
-				fmt.Println(err)
-				client.Disconnect()
-				break
-			}
-		}
-	}()
+	connectResponse := resp.(*shared.ConnectResponse)
+	if !connectResponse.Connected {
+		fmt.Printf("server rejected connection request: %s\n", connectResponse.ErrMessage)
+		client.Disconnect()
+		return
+	}
+
+	fmt.Printf("You have joined the chat\n")
 
 	for {
 		message, err := reader.ReadString('\n')
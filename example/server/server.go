@@ -2,11 +2,13 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/rpj5582/gochat/example/shared"
 	"github.com/rpj5582/gochat/modules/common"
@@ -15,7 +17,6 @@ import (
 
 // GLOBALS, DON'T DO THIS
 var serv *server.TCPServer
-var clientNames map[string]server.ClientID
 
 func main() {
 	fmt.Print("Enter a port (blank for 20000): ")
@@ -32,40 +33,33 @@ func main() {
 		port = "20000"
 	}
 
-	serv, err = server.NewTCPServer(65535, onClientConnected, onClientDisconnected)
+	serv, err = server.NewTCPServer(65535, onClientConnected, onClientDisconnected, server.WithHeartbeat(time.Second*10, time.Second*30), server.WithAppVersion(shared.AppVersion), server.WithWriteQueue(64, server.OverflowDisconnect))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	clientNames = make(map[string]server.ClientID)
-
-	serv.RegisterPacketType(&shared.ConnectRequest{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
-		connectionRequest := p.(*shared.ConnectRequest)
-		if _, ok := clientNames[connectionRequest.ClientName]; ok {
-			fmt.Printf("client attempting to connect with name \"%s\": name already taken\n", connectionRequest.ClientName)
-			if err := serv.SendPacket(clientID, &shared.ConnectResponse{Connected: false, ErrMessage: fmt.Sprintf("name \"%s\" is already taken", connectionRequest.ClientName)}); err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			conn.Close()
-			return
-		}
-
-		clientNames[connectionRequest.ClientName] = clientID
-		if err := serv.SendPacket(clientID, &shared.ConnectResponse{Connected: true}); err != nil {
-			fmt.Println(err)
-			return
+	serv.RegisterNamedPacketType(shared.ConnectRequestPacketName, &shared.ConnectRequest{}, nil)
+	serv.HandleRequest(&shared.ConnectRequest{}, func(ctx context.Context, clientID server.ClientID, req common.Packet) (common.Packet, error) {
+		connectionRequest := req.(*shared.ConnectRequest)
+		if err := serv.Sessions().SetName(clientID, connectionRequest.ClientName); err != nil {
+			fmt.Printf("client attempting to connect with name \"%s\": %v\n", connectionRequest.ClientName, err)
+			return &shared.ConnectResponse{Connected: false, ErrMessage: err.Error()}, nil
 		}
 
 		serv.BroadcastPacket(&shared.ConnectedPacket{ClientName: connectionRequest.ClientName}, clientID)
+		return &shared.ConnectResponse{Connected: true}, nil
 	})
 
-	serv.RegisterPacketType(&shared.MessagePacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+	serv.RegisterNamedPacketType(shared.MessagePacketName, &shared.MessagePacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
 		serv.BroadcastPacket(p, clientID)
 	})
 
+	// The server only sends these packets, so it does not need to handle receiving them
+	serv.RegisterNamedPacketType(shared.ConnectResponsePacketName, &shared.ConnectResponse{}, nil)
+	serv.RegisterNamedPacketType(shared.ConnectedPacketName, &shared.ConnectedPacket{}, nil)
+	serv.RegisterNamedPacketType(shared.DisconnectedPacketName, &shared.DisconnectedPacket{}, nil)
+
 	go func() {
 		if err := serv.Start(port); err != nil {
 			fmt.Println(err)
@@ -79,8 +73,15 @@ func main() {
 	signal.Notify(sigChannel, os.Interrupt)
 	<-sigChannel
 
-	serv.Stop()
 	fmt.Println("\nStopping server")
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+
+	if err := serv.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		serv.Stop()
+	}
 }
 
 func onClientConnected(clientID server.ClientID) {
@@ -88,26 +89,12 @@ func onClientConnected(clientID server.ClientID) {
 }
 
 func onClientDisconnected(clientID server.ClientID, err error) {
-	if err != nil {
-		fmt.Printf("client with ID %d has disconnected: %v\n", clientID, err)
-	}
+	fmt.Printf("client with ID %d has disconnected: %v\n", clientID, err)
 
-	fmt.Printf("client with ID %d has disconnected\n", clientID)
-
-	clientName, err := getClientNameFromID(clientNames, clientID)
-	if err != nil {
+	clientName, ok := serv.Sessions().Name(clientID)
+	if !ok {
 		return
 	}
 
 	serv.BroadcastPacket(&shared.DisconnectedPacket{ClientName: clientName}, clientID)
 }
-
-func getClientNameFromID(clientNames map[string]server.ClientID, clientID server.ClientID) (string, error) {
-	for name, ID := range clientNames {
-		if ID == clientID {
-			return name, nil
-		}
-	}
-
-	return "", fmt.Errorf("could not find client name with ID %d", clientID)
-}
@@ -4,15 +4,18 @@ const (
 	// MaxPacketSize is the maximum size of a packet in bytes
 	MaxPacketSize = 1<<16 - 1
 
-	// MaxNameLength is the maximum length of a client's name
+	// MaxNameLength is the maximum length of a client's name, which the maxlen tags of the packets carrying names enforce
 	MaxNameLength = 32
+
+	// AppVersion is the version of the example chat the client and server tell each other when connecting
+	AppVersion = "1.0.0"
 )
 
+// The names the example chat's packets are registered by
 const (
-	UnknownPacketID = iota
-	MessagePacketID
-	ConnectRequestPacketID
-	ConnectResponsePacketID
-	ConnectedPacketID
-	DisconnectedPacketID
+	MessagePacketName         = "chat.message"
+	ConnectRequestPacketName  = "chat.connect_request"
+	ConnectResponsePacketName = "chat.connect_response"
+	ConnectedPacketName       = "chat.connected"
+	DisconnectedPacketName    = "chat.disconnected"
 )
@@ -1,8 +1,15 @@
 module github.com/rpj5582/gochat
 
-go 1.14
+go 1.18
 
 require (
 	github.com/stretchr/testify v1.6.1
-	golang.org/x/net v0.0.0-20201031054903-ff519b6c9102
+	golang.org/x/crypto v0.14.0
+	golang.org/x/net v0.17.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
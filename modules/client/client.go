@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"net"
 
@@ -8,11 +9,15 @@ import (
 )
 
 // Client represents a network client that can communicate with a server,
-// and by proxy, other clients the server is connected to
+// and by proxy, other clients the server is connected to. Like server.Server, it covers connecting,
+// sending and receiving packets and registering packet types. Making calls is covered by Caller.
 type Client interface {
 	// Connect connects a client to a server at the given address
 	Connect(addr string) error
 
+	// ConnectContext is like Connect, but gives up on connecting once the context ends
+	ConnectContext(ctx context.Context, addr string) error
+
 	// Disconnect disconnects the client from the server
 	Disconnect() error
 
@@ -29,10 +34,29 @@ type Client interface {
 	// registered callback function associated with the packet type
 	ReceivePacket() error
 
+	// Run receives packets from the server and calls their registered callbacks
+	// until the connection ends, the client disconnects or the context is cancelled
+	Run(ctx context.Context) error
+
 	// RegisterPacketType registers the given packet type as a packet that can be sent and received,
 	// and associates a callback function to be called when the given packet type is received.
-	// When registering a packet type, pass the zero value for that packet type.
+	// When registering a packet type, pass the zero value for that packet type. It is used as a
+	// prototype, and each received packet is decoded into a new value of the same type.
+	// IDs from common.FirstNamedPacketID up are reserved for packet types registered by name.
 	RegisterPacketType(p common.Packet, receiveCallback func(conn net.Conn, p common.Packet)) error
+
+	// RegisterNamedPacketType is like RegisterPacketType, but identifies the packet type by name
+	// instead of by its ID. The ID it is sent with is agreed on with the server when connecting.
+	RegisterNamedPacketType(name string, p common.Packet, receiveCallback func(conn net.Conn, p common.Packet)) error
+}
+
+// Caller is implemented by clients that can make calls to the server and answer calls from it
+type Caller interface {
+	// Call sends a request to the server and waits for the server's response
+	Call(ctx context.Context, req common.Packet) (common.Packet, error)
+
+	// HandleRequest sets the handler that answers requests of the given registered packet type sent by the server
+	HandleRequest(req common.Packet, handler func(ctx context.Context, req common.Packet) (common.Packet, error)) error
 }
 
 // ConnectErr represents an error establishing a connection
@@ -51,3 +75,39 @@ type NotConnectedErr struct{}
 func (e NotConnectedErr) Error() string {
 	return "not connected"
 }
+
+// ReconnectErr is returned when a client gives up reconnecting to the server
+type ReconnectErr struct {
+	Attempts int
+	Err      error
+}
+
+func (e ReconnectErr) Error() string {
+	return fmt.Sprintf("could not reconnect after %d attempts: %v", e.Attempts, e.Err)
+}
+
+// CredentialsRequiredErr is returned when connecting to a server that requires authentication
+// without having been given credentials with WithCredentials
+type CredentialsRequiredErr struct{}
+
+func (e CredentialsRequiredErr) Error() string {
+	return "the server requires authentication, but the client has no credentials"
+}
+
+// QueueFullErr is returned when a packet is sent while reconnecting and the send queue is full
+type QueueFullErr struct {
+	PacketID uint16
+}
+
+func (e QueueFullErr) Error() string {
+	return fmt.Sprintf("could not queue packet with ID %d: send queue is full", e.PacketID)
+}
+
+// InvalidJitterErr is returned when creating a client with a ReconnectPolicy whose Jitter is not between 0 and 1
+type InvalidJitterErr struct {
+	Jitter float64
+}
+
+func (e InvalidJitterErr) Error() string {
+	return fmt.Sprintf("invalid reconnect jitter of %g, must be between 0 and 1", e.Jitter)
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// RegisterNamedPacketType is like RegisterPacketType, but identifies the packet type by name instead of by its ID,
+// so packet types defined by different packages never have to agree on their IDs. The packet type's ID method must
+// return common.NamedPacketID. The server tells the client which ID each name is sent with when it connects, and
+// sending a packet the server did not register by the same name fails with a *common.UnknownPacketNameErr.
+func (c *TCPClient) RegisterNamedPacketType(name string, p common.Packet, receiveCallback func(conn net.Conn, p common.Packet)) error {
+	if p.ID() != common.NamedPacketID {
+		return &common.InvalidPacketIDErr{PacketID: p.ID()}
+	}
+
+	if _, ok := c.namedPackets[name]; ok {
+		return &common.PacketNameRegisteredErr{Name: name}
+	}
+
+	packetType := common.PacketType(p)
+	if _, ok := c.namedTypes[packetType]; ok {
+		return &common.PacketRegisteredErr{PacketID: p.ID()}
+	}
+
+	c.namedTypes[packetType] = name
+	c.namedPackets[name] = struct {
+		packet   common.Packet
+		callback func(conn net.Conn, p common.Packet)
+	}{packet: p, callback: receiveCallback}
+
+	return nil
+}
+
+// receivePacketNames reads the IDs the server assigned to the packet types it registered by name
+func receivePacketNames(ctx context.Context, conn net.Conn, reader *common.FrameReader) (map[string]uint16, error) {
+	setHandshakeDeadline(ctx, conn)
+	defer conn.SetDeadline(time.Time{})
+
+	frame, err := readHandshakeFrame(reader, common.ControlPacketNames)
+	if err != nil {
+		return nil, err
+	}
+
+	packetIDs, err := common.ParsePacketNames(frame.Payload)
+	if err != nil {
+		return nil, &common.ProtocolErr{Err: err}
+	}
+
+	return packetIDs, nil
+}
+
+// packetID returns the ID the packet is sent with on the current connection,
+// which is the ID the server assigned to its name if it is a named packet
+func (c *TCPClient) packetID(p common.Packet) (uint16, error) {
+	packetID := p.ID()
+	if packetID != common.NamedPacketID {
+		return packetID, nil
+	}
+
+	name, ok := c.namedTypes[common.PacketType(p)]
+	if !ok {
+		return 0, &common.PacketTypeNotRegisteredErr{Type: common.PacketType(p).String()}
+	}
+
+	c.connMutex.RLock()
+	packetID, ok = c.packetIDs[name]
+	c.connMutex.RUnlock()
+	if !ok {
+		return 0, &common.UnknownPacketNameErr{Name: name}
+	}
+
+	return packetID, nil
+}
+
+// registeredPacket returns the prototype and callback registered for packets received with the given ID on the current connection
+func (c *TCPClient) registeredPacket(packetID uint16) (common.Packet, func(conn net.Conn, p common.Packet), bool) {
+	if packetID < common.FirstNamedPacketID {
+		p, ok := c.registeredPackets[packetID]
+		return p.packet, p.callback, ok
+	}
+
+	c.connMutex.RLock()
+	name, ok := c.packetNames[packetID]
+	c.connMutex.RUnlock()
+	if !ok {
+		return nil, nil, false
+	}
+
+	p, ok := c.namedPackets[name]
+	return p.packet, p.callback, ok
+}
@@ -0,0 +1,50 @@
+package client
+
+import "github.com/rpj5582/gochat/modules/common"
+
+// Option configures optional behavior of a client
+type Option func(c *TCPClient)
+
+// WithOnDisconnected sets a callback that is called when Run stops because the connection ended.
+// err is nil if the client disconnected itself, and otherwise says why the connection ended.
+func WithOnDisconnected(onDisconnected func(err error)) Option {
+	return func(c *TCPClient) {
+		c.onDisconnected = onDisconnected
+	}
+}
+
+// WithCredentials makes the client present the given credentials whenever it connects to a server
+// that requires authentication. Connecting fails with a *common.DisconnectErr if the server rejects them.
+// Servers that do not require authentication are connected to without presenting them. Without this
+// option, connecting to a server that requires authentication fails with a *CredentialsRequiredErr.
+func WithCredentials(credentials common.Credentials) Option {
+	return func(c *TCPClient) {
+		c.credentials = &credentials
+	}
+}
+
+// WithSerializers makes the client offer the given serializers, in order of preference, whenever it connects.
+// The server chooses the first one it also supports, and connecting fails with a *common.DisconnectErr if there
+// is none. If the server is not configured with serializers, the common.DefaultCodec is used if it was offered.
+// Without this option, packets are encoded by the common.DefaultCodec.
+func WithSerializers(serializers ...common.Serializer) Option {
+	return func(c *TCPClient) {
+		c.serializers = serializers
+	}
+}
+
+// WithAppVersion sets the application version the client sends the server in its hello,
+// which the server's version check can use to turn away clients that are too old
+func WithAppVersion(version string) Option {
+	return func(c *TCPClient) {
+		c.appVersion = version
+	}
+}
+
+// WithVersionCheck replaces the common.DefaultVersionCheck the client runs against the server's hello.
+// Connecting fails with a *common.VersionMismatchErr if the check rejects the server.
+func WithVersionCheck(check common.VersionCheck) Option {
+	return func(c *TCPClient) {
+		c.versionCheck = check
+	}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+var _ Client = (*PipeClient)(nil)
+
+// PipeClient is a client that communicates with a server.PipeServer over
+// in-memory pipes instead of sockets. It behaves exactly like a TCPClient.
+type PipeClient struct {
+	*TCPClient
+}
+
+// NewPipeClient returns an initialized pipe client. The address passed to
+// Connect is the name the pipe server was started with.
+func NewPipeClient(maxPacketSize int, opts ...Option) (*PipeClient, error) {
+	c, err := NewTCPClient(maxPacketSize, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.dial = dialPipe
+	return &PipeClient{TCPClient: c}, nil
+}
+
+func dialPipe(ctx context.Context, addr string) (net.Conn, error) {
+	return common.DialPipe(ctx, addr)
+}
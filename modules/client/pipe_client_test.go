@@ -0,0 +1,17 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeClientConnectFailure(t *testing.T) {
+	c, err := client.NewPipeClient(10)
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	err = c.Connect("no-such-pipe")
+	assert.IsType(t, &client.ConnectErr{}, err)
+}
@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// ReconnectPolicy configures how a client reconnects after losing its connection to the server.
+// Reconnecting only happens while the client is running with Run.
+type ReconnectPolicy struct {
+	// InitialBackoff is how long to wait before the first attempt. It defaults to 100 milliseconds.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps how long to wait between attempts. It defaults to 30 seconds.
+	MaxBackoff time.Duration
+
+	// Multiplier is how much the backoff grows after each failed attempt. It defaults to 2.
+	Multiplier float64
+
+	// Jitter randomizes each backoff by up to this fraction of it in either direction,
+	// so many clients don't all reconnect at once. It must be between 0 and 1.
+	Jitter float64
+
+	// MaxAttempts is how many times to try reconnecting before giving up. Zero means no limit.
+	MaxAttempts int
+
+	// QueueSize is how many packets sent while disconnected are queued and then sent once reconnected.
+	// Zero disables queueing, in which case sending while disconnected returns NotConnectedErr.
+	QueueSize int
+}
+
+// backoff returns how long to wait before the given attempt, starting at 1
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	initialBackoff := p.InitialBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = 100 * time.Millisecond
+	}
+
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	backoff := float64(initialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if backoff > float64(maxBackoff) {
+		backoff = float64(maxBackoff)
+	}
+
+	backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(backoff)
+}
+
+// WithReconnect makes the client reconnect according to the given policy when its connection is lost.
+// Creating the client fails with an *InvalidJitterErr if the policy's Jitter is not between 0 and 1.
+func WithReconnect(policy ReconnectPolicy) Option {
+	return func(c *TCPClient) {
+		if policy.Jitter < 0 || policy.Jitter > 1 {
+			c.optionErr = &InvalidJitterErr{Jitter: policy.Jitter}
+			return
+		}
+
+		c.reconnectPolicy = &policy
+	}
+}
+
+// WithOnReconnecting sets a callback that is called before each reconnect attempt,
+// along with the error that caused the connection or the previous attempt to fail
+func WithOnReconnecting(onReconnecting func(attempt int, err error)) Option {
+	return func(c *TCPClient) {
+		c.onReconnecting = onReconnecting
+	}
+}
+
+// WithOnReconnected sets a callback that is called once the client has reconnected, before the packets queued
+// while disconnected are sent. Packets sent before the queue has been sent, including from the callback,
+// are queued behind them, so the server receives every packet in the order it was sent.
+func WithOnReconnected(onReconnected func()) Option {
+	return func(c *TCPClient) {
+		c.onReconnected = onReconnected
+	}
+}
+
+// WithOnQueueError sets a callback that is called with each packet queued while reconnecting that cannot be
+// sent once reconnected, such as because it is too large for the serializer agreed on with the server, along
+// with why. Packets that are not sent because the new connection is lost as well stay queued for the next one.
+func WithOnQueueError(onQueueError func(p common.Packet, err error)) Option {
+	return func(c *TCPClient) {
+		c.onQueueError = onQueueError
+	}
+}
+
+// connectionLost closes the given connection after it failed with err, and marks the client as
+// reconnecting if requested. It does nothing if the client is no longer using the connection.
+func (c *TCPClient) connectionLost(conn net.Conn, err error, reconnect bool) {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	if c.conn != conn {
+		return
+	}
+
+	// The server already said goodbye or the connection is broken, so there is only something
+	// to tell the server if it sent something the client could not handle
+	switch err.(type) {
+	case *common.DisconnectErr, *common.ReceiveErr, *common.TimeoutErr:
+	default:
+		conn.SetWriteDeadline(time.Now().Add(common.GoodbyeTimeout))
+		common.WriteControlFrame(conn, common.ControlGoodbye, common.EncodeGoodbye(common.CloseProtocolError, err.Error()))
+	}
+
+	conn.Close()
+	c.calls.Close(err)
+	c.setLink(nil)
+	c.reconnecting = reconnect
+}
+
+// isKicked reports whether the server closed the connection because it kicked or banned the client
+func isKicked(err error) bool {
+	disconnectErr, ok := err.(*common.DisconnectErr)
+	return ok && (disconnectErr.Reason == common.CloseKicked || disconnectErr.Reason == common.CloseBanned)
+}
+
+// reconnect tries to reconnect to the server until it succeeds, the policy gives up or the context ends
+func (c *TCPClient) reconnect(ctx context.Context, cause error) (net.Conn, error) {
+	attempt := 1
+	for ; c.reconnectPolicy.MaxAttempts == 0 || attempt <= c.reconnectPolicy.MaxAttempts; attempt++ {
+		if c.onReconnecting != nil {
+			c.onReconnecting(attempt, cause)
+		}
+
+		timer := time.NewTimer(c.reconnectPolicy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		c.connMutex.RLock()
+		addr := c.addr
+		c.connMutex.RUnlock()
+
+		l, err := c.connect(ctx, addr)
+		if err != nil {
+			cause = err
+
+			// Trying again will not help if the server turned the client away on purpose
+			if isKicked(err) {
+				return nil, c.giveUpReconnecting(attempt, cause)
+			}
+			continue
+		}
+
+		c.connMutex.Lock()
+		if !c.reconnecting {
+			// Disconnect was called while dialing
+			c.connMutex.Unlock()
+			l.conn.Close()
+			return nil, context.Canceled
+		}
+
+		// The client stays reconnecting until its queue has been sent, so packets sent meanwhile are queued behind it
+		c.setLink(l)
+		c.connMutex.Unlock()
+
+		if c.onReconnected != nil {
+			c.onReconnected()
+		}
+
+		// Closing the connection makes Run reconnect again, and send what is left of the queue then
+		if err := c.flushSendQueue(l.conn); err != nil {
+			l.conn.Close()
+		}
+
+		return l.conn, nil
+	}
+
+	return nil, c.giveUpReconnecting(attempt-1, cause)
+}
+
+// flushSendQueue sends the packets queued while reconnecting in the order they were queued, including any queued
+// while it runs, and stops reconnecting once the queue is empty. If sending fails because the connection is lost,
+// the packets that were not sent are queued again and the error is returned.
+func (c *TCPClient) flushSendQueue(conn net.Conn) error {
+	for {
+		c.connMutex.Lock()
+		sendQueue := c.sendQueue
+		c.sendQueue = nil
+		if len(sendQueue) == 0 {
+			c.reconnecting = false
+			c.connMutex.Unlock()
+			return nil
+		}
+		c.connMutex.Unlock()
+
+		for i, p := range sendQueue {
+			err := c.writePacket(conn, p, common.FrameHeader{})
+			if _, ok := err.(*common.SendErr); ok {
+				c.connMutex.Lock()
+				// Disconnect drops the queue, so there is nothing to put back once it has been called
+				if c.reconnecting {
+					c.sendQueue = append(sendQueue[i:], c.sendQueue...)
+				}
+				c.connMutex.Unlock()
+
+				return err
+			}
+
+			if err != nil && c.onQueueError != nil {
+				c.onQueueError(p, err)
+			}
+		}
+	}
+}
+
+// giveUpReconnecting drops the packets queued while reconnecting and returns the error reconnecting failed with
+func (c *TCPClient) giveUpReconnecting(attempts int, cause error) error {
+	c.connMutex.Lock()
+	c.reconnecting = false
+	c.sendQueue = nil
+	c.connMutex.Unlock()
+
+	return &ReconnectErr{Attempts: attempts, Err: cause}
+}
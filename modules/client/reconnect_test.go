@@ -0,0 +1,359 @@
+package client_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/nettest"
+)
+
+type TestDataPacket struct {
+	Data []byte
+}
+
+func (p TestDataPacket) ID() uint16 {
+	return 1
+}
+
+func (p *TestDataPacket) Write(buffer []byte) (int, error) {
+	p.Data = append([]byte{}, buffer...)
+	return len(buffer), nil
+}
+
+func (p TestDataPacket) Read(buffer []byte) (int, error) {
+	return copy(buffer, p.Data), nil
+}
+
+// startTestServer starts a server on the given port that reports every data packet it receives
+func startTestServer(t *testing.T, port string, received chan<- []byte) *server.TCPServer {
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&TestDataPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received <- p.(*TestDataPacket).Data
+	})
+	assert.NoError(t, err)
+
+	go s.Start(port)
+	time.Sleep(time.Millisecond * 10)
+
+	return s
+}
+
+func TestTCPClientReconnectAfterServerRestart(t *testing.T) {
+	received := make(chan []byte, 10)
+	s := startTestServer(t, "0", received)
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+
+	reconnecting := make(chan int, 100)
+	reconnected := make(chan struct{}, 1)
+
+	c, err := client.NewTCPClient(10,
+		client.WithReconnect(client.ReconnectPolicy{
+			InitialBackoff: time.Millisecond * 10,
+			MaxBackoff:     time.Millisecond * 50,
+			Jitter:         0.5,
+			QueueSize:      2,
+		}),
+		client.WithOnReconnecting(func(attempt int, err error) {
+			reconnecting <- attempt
+		}),
+		client.WithOnReconnected(func() {
+			reconnected <- struct{}{}
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = c.Connect("127.0.0.1:" + port)
+	assert.NoError(t, err)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(context.Background())
+	}()
+
+	err = c.SendPacket(&TestDataPacket{Data: []byte("before")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("before"), <-received)
+
+	s.Stop()
+	assert.Equal(t, 1, <-reconnecting)
+
+	// Packets sent while the server is down are queued until the queue is full
+	err = c.SendPacket(&TestDataPacket{Data: []byte("queued 1")})
+	assert.NoError(t, err)
+	err = c.SendPacket(&TestDataPacket{Data: []byte("queued 2")})
+	assert.NoError(t, err)
+	err = c.SendPacket(&TestDataPacket{Data: []byte("dropped")})
+	assert.IsType(t, &client.QueueFullErr{}, err)
+
+	s = startTestServer(t, port, received)
+	defer s.Stop()
+
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("client did not reconnect")
+	}
+
+	assert.Equal(t, []byte("queued 1"), <-received)
+	assert.Equal(t, []byte("queued 2"), <-received)
+
+	err = c.SendPacket(&TestDataPacket{Data: []byte("after")})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("after"), <-received)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+	assert.NoError(t, <-runErr)
+}
+
+func TestTCPClientReconnectKeepsSendOrder(t *testing.T) {
+	received := make(chan []byte, 10)
+	s := startTestServer(t, "0", received)
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+
+	reconnecting := make(chan int, 100)
+
+	var c *client.TCPClient
+	c, err := client.NewTCPClient(10,
+		client.WithReconnect(client.ReconnectPolicy{
+			InitialBackoff: time.Millisecond * 10,
+			MaxBackoff:     time.Millisecond * 50,
+			QueueSize:      2,
+		}),
+		client.WithOnReconnecting(func(attempt int, err error) {
+			reconnecting <- attempt
+		}),
+		client.WithOnReconnected(func() {
+			err := c.SendPacket(&TestDataPacket{Data: []byte("resumed")})
+			assert.NoError(t, err)
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = c.Connect("127.0.0.1:" + port)
+	assert.NoError(t, err)
+	go c.Run(context.Background())
+	defer c.Disconnect()
+
+	s.Stop()
+	<-reconnecting
+
+	for _, data := range []string{"queued 1", "queued 2"} {
+		err = c.SendPacket(&TestDataPacket{Data: []byte(data)})
+		assert.NoError(t, err)
+	}
+
+	s = startTestServer(t, port, received)
+	defer s.Stop()
+
+	// Packets sent once reconnected come after the ones queued before
+	for _, data := range []string{"queued 1", "queued 2", "resumed"} {
+		select {
+		case d := <-received:
+			assert.Equal(t, []byte(data), d)
+		case <-time.After(time.Second * 5):
+			t.Fatal("packet was not received")
+		}
+	}
+}
+
+func TestTCPClientReconnectGivesUp(t *testing.T) {
+	received := make(chan []byte, 10)
+	s := startTestServer(t, "0", received)
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+
+	disconnected := make(chan error, 1)
+	c, err := client.NewTCPClient(10,
+		client.WithReconnect(client.ReconnectPolicy{
+			InitialBackoff: time.Millisecond,
+			MaxAttempts:    3,
+		}),
+		client.WithOnDisconnected(func(err error) {
+			disconnected <- err
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = c.Connect("127.0.0.1:" + port)
+	assert.NoError(t, err)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(context.Background())
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	s.Stop()
+
+	err = <-runErr
+	if assert.IsType(t, &client.ReconnectErr{}, err) {
+		assert.Equal(t, 3, err.(*client.ReconnectErr).Attempts)
+	}
+	assert.Equal(t, err, <-disconnected)
+
+	err = c.SendPacket(&TestDataPacket{Data: []byte("test")})
+	assert.IsType(t, &client.NotConnectedErr{}, err)
+}
+
+func TestTCPClientDisconnectWhileReconnecting(t *testing.T) {
+	received := make(chan []byte, 10)
+	s := startTestServer(t, "0", received)
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+
+	reconnecting := make(chan int, 100)
+	c, err := client.NewTCPClient(10,
+		client.WithReconnect(client.ReconnectPolicy{
+			InitialBackoff: time.Millisecond * 10,
+		}),
+		client.WithOnReconnecting(func(attempt int, err error) {
+			reconnecting <- attempt
+		}),
+	)
+	assert.NoError(t, err)
+
+	err = c.Connect("127.0.0.1:" + port)
+	assert.NoError(t, err)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(context.Background())
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	s.Stop()
+	<-reconnecting
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+	assert.NoError(t, <-runErr)
+}
+
+func TestTCPClientDisconnectNotifiesServer(t *testing.T) {
+	disconnected := make(chan error, 1)
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(10)
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(context.Background())
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+
+	start := time.Now()
+	err = c.Disconnect()
+	assert.NoError(t, err)
+	assert.NoError(t, <-runErr)
+
+	// The server closes its side as soon as it reads the goodbye, so the client does not wait out the timeout
+	assert.True(t, time.Since(start) < common.GoodbyeTimeout)
+	assert.Equal(t, &common.DisconnectErr{Reason: common.CloseNormal}, <-disconnected)
+}
+
+func TestTCPClientKickedDoesNotReconnect(t *testing.T) {
+	reconnecting := make(chan int, 100)
+	c, err := client.NewTCPClient(10,
+		client.WithReconnect(client.ReconnectPolicy{
+			InitialBackoff: time.Millisecond,
+		}),
+		client.WithOnReconnecting(func(attempt int, err error) {
+			reconnecting <- attempt
+		}),
+	)
+	assert.NoError(t, err)
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+
+	common.WriteControlFrame(conn, common.ControlGoodbye, common.EncodeGoodbye(common.CloseKicked, "spamming"))
+	conn.Close()
+
+	err = c.Run(context.Background())
+	assert.Equal(t, &common.DisconnectErr{Reason: common.CloseKicked, Message: "spamming"}, err)
+	assert.Empty(t, reconnecting)
+}
+
+func TestTCPClientBannedStopsReconnecting(t *testing.T) {
+	reconnecting := make(chan int, 100)
+	c, err := client.NewTCPClient(10,
+		client.WithReconnect(client.ReconnectPolicy{
+			InitialBackoff: time.Millisecond,
+			MaxAttempts:    5,
+		}),
+		client.WithOnReconnecting(func(attempt int, err error) {
+			reconnecting <- attempt
+		}),
+	)
+	assert.NoError(t, err)
+
+	listener, err := nettest.NewLocalListener("tcp")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		answerHello(conn)
+
+		// Drop the connection, then turn away the client as it reconnects
+		time.Sleep(time.Millisecond * 10)
+		conn.Close()
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		common.WriteControlFrame(conn, common.ControlGoodbye, common.EncodeGoodbye(common.CloseBanned, "cheating"))
+		conn.Close()
+	}()
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	err = c.Run(context.Background())
+	assert.Equal(t, &client.ReconnectErr{Attempts: 1, Err: &common.DisconnectErr{Reason: common.CloseBanned, Message: "cheating"}}, err)
+	assert.Equal(t, 1, <-reconnecting)
+	assert.Empty(t, reconnecting)
+}
+
+func TestNewTCPClientInvalidJitter(t *testing.T) {
+	for _, jitter := range []float64{-0.1, 1.5} {
+		c, err := client.NewTCPClient(10, client.WithReconnect(client.ReconnectPolicy{Jitter: jitter}))
+		assert.Nil(t, c)
+		assert.Equal(t, &client.InvalidJitterErr{Jitter: jitter}, err)
+	}
+
+	for _, jitter := range []float64{0, 1} {
+		_, err := client.NewTCPClient(10, client.WithReconnect(client.ReconnectPolicy{Jitter: jitter}))
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// WithMaxRequestHandlers limits how many handlers for the server's requests may run at once, which defaults
+// to common.DefaultMaxHandlers. Requests that arrive while the client is at the limit are not handled, and
+// the server's Call fails with a *common.CallErr with common.CallErrBusy.
+func WithMaxRequestHandlers(max int) Option {
+	return func(c *TCPClient) {
+		c.maxHandlers = max
+	}
+}
+
+// HandleRequest sets the handler that answers requests of the given type sent by the server's Call.
+// The packet type must already be registered, and its receive callback is not called for requests.
+// The handler runs on its own goroutine, so several requests can be handled at once. Its context is cancelled
+// if the server gives up on the call or the connection ends. The response it returns is sent back to the server,
+// which must have registered the response's type. If it fails instead, the server's Call returns a *common.CallErr:
+// the handler's own if it returned one, or one with common.CallErrInternal.
+func (c *TCPClient) HandleRequest(req common.Packet, handler func(ctx context.Context, req common.Packet) (common.Packet, error)) error {
+	packetType := common.PacketType(req)
+
+	packetID := req.ID()
+	if packetID == common.NamedPacketID {
+		if _, ok := c.namedTypes[packetType]; !ok {
+			return &common.PacketTypeNotRegisteredErr{Type: packetType.String()}
+		}
+	} else if _, ok := c.registeredPackets[packetID]; !ok {
+		return &common.PacketNotRegisteredErr{PacketID: packetID}
+	}
+
+	if _, ok := c.requestHandlers[packetType]; ok {
+		return &common.HandlerRegisteredErr{PacketID: packetID}
+	}
+
+	c.requestHandlers[packetType] = handler
+	return nil
+}
+
+// Call sends a request to the server and waits for the response of the handler the server set with HandleRequest.
+// The type of the response must be registered, and the response is only received while Run or ReceivePacket is
+// receiving packets. Call gives up once the context ends, and fails with a *common.CallErr if the server's handler
+// failed, or with the error the connection ended with if it ends first.
+func (c *TCPClient) Call(ctx context.Context, req common.Packet) (common.Packet, error) {
+	c.connMutex.RLock()
+	conn := c.conn
+	calls := c.calls
+	isConnected := c.isConnected
+	c.connMutex.RUnlock()
+
+	if !isConnected {
+		return nil, &NotConnectedErr{}
+	}
+
+	return calls.Call(ctx, conn, func(callID uint32) error {
+		return c.writePacket(conn, req, common.FrameHeader{Flags: common.FlagRequest, CallID: callID})
+	})
+}
+
+// handleRequest runs the handler for a request received from the server and answers the server with its result.
+// A *common.ProtocolErr is returned if the server reused the call ID of a request that is still being handled.
+func (c *TCPClient) handleRequest(conn net.Conn, calls *common.Calls, frame common.Frame, req common.Packet) error {
+	ctx, done, err := calls.Handle(frame.CallID)
+	if err != nil {
+		if callErr, ok := err.(*common.CallErr); ok {
+			common.WriteCallErr(conn, frame.CallID, callErr)
+			return nil
+		}
+
+		return err
+	}
+
+	handler, ok := c.requestHandlers[common.PacketType(req)]
+	if !ok {
+		done()
+		common.WriteCallErr(conn, frame.CallID, &common.CallErr{
+			Code:    common.CallErrUnhandled,
+			Message: fmt.Sprintf("no handler for packet with ID %d", frame.PacketID),
+		})
+		return nil
+	}
+
+	go func() {
+		defer done()
+
+		resp, err := handler(ctx, req)
+		if err == nil && resp == nil {
+			err = errors.New("handler returned no response")
+		}
+
+		if err == nil {
+			err = c.writePacket(conn, resp, common.FrameHeader{Flags: common.FlagResponse, CallID: frame.CallID})
+		}
+
+		if err != nil {
+			common.WriteCallErr(conn, frame.CallID, err)
+		}
+	}()
+
+	return nil
+}
+
+// handleCallErr hands the error the server answered a call with to the call waiting on it
+func handleCallErr(calls *common.Calls, frame common.Frame) error {
+	callErr, err := common.ParseCallErr(frame.Payload)
+	if err != nil {
+		return &common.ProtocolErr{Err: err}
+	}
+
+	calls.Resolve(frame.CallID, common.CallResult{Err: callErr})
+	return nil
+}
@@ -1,97 +1,545 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/rpj5582/gochat/modules/common"
 )
 
+var (
+	_ Client = (*TCPClient)(nil)
+	_ Caller = (*TCPClient)(nil)
+)
+
+// handshakeTimeout is how long the client waits for the server to admit it
+// when connecting without a deadline
+const handshakeTimeout = 10 * time.Second
+
 // TCPClient is a client that can communicate with a server via TCP
 type TCPClient struct {
-	registeredPackets map[uint8]struct {
+	registeredPackets map[uint16]struct {
 		packet   common.Packet
 		callback func(conn net.Conn, p common.Packet)
 	}
 	maxPacketSize int
 
+	// namedPackets holds the packet types registered by name, and namedTypes their names by the type of the packet
+	namedPackets map[string]struct {
+		packet   common.Packet
+		callback func(conn net.Conn, p common.Packet)
+	}
+	namedTypes map[reflect.Type]string
+
+	requestHandlers map[reflect.Type]func(ctx context.Context, req common.Packet) (common.Packet, error)
+	maxHandlers     int
+
+	dial        func(ctx context.Context, addr string) (net.Conn, error)
 	conn        net.Conn
+	reader      *common.FrameReader
+	serializer  common.Serializer
 	isConnected bool
+
+	// packetIDs holds the IDs the server assigned to the packet types it registered by name,
+	// and packetNames the same names by ID. They are guarded by connMutex.
+	packetIDs   map[string]uint16
+	packetNames map[uint16]string
+
+	// calls tracks the calls made to the server and the requests from the server being handled.
+	// It is guarded by connMutex.
+	calls *common.Calls
+
+	connMutex sync.RWMutex
+
+	// addr is the address last connected to, which is used when reconnecting
+	addr string
+
+	// stopRun stops a running Run loop and runDone is closed once it has returned.
+	// They are set while Run is running and guarded by connMutex.
+	stopRun context.CancelFunc
+	runDone chan struct{}
+
+	reconnectPolicy *ReconnectPolicy
+	reconnecting    bool
+	sendQueue       []common.Packet
+
+	credentials  *common.Credentials
+	serializers  []common.Serializer
+	appVersion   string
+	versionCheck common.VersionCheck
+
+	onDisconnected func(err error)
+	onReconnecting func(attempt int, err error)
+	onReconnected  func()
+	onQueueError   func(p common.Packet, err error)
+
+	// optionErr is the error an option was given invalid settings with, which creating the client fails with
+	optionErr error
 }
 
 // NewTCPClient returns an initialized TCP client ready to connect to a server
-func NewTCPClient(maxPacketSize int) (*TCPClient, error) {
+func NewTCPClient(maxPacketSize int, opts ...Option) (*TCPClient, error) {
 	if maxPacketSize < 1 {
 		return nil, &common.InvalidMaxPacketSizeErr{Size: maxPacketSize}
 	}
 
-	return &TCPClient{
-		registeredPackets: make(map[uint8]struct {
+	c := &TCPClient{
+		registeredPackets: make(map[uint16]struct {
 			packet   common.Packet
 			callback func(conn net.Conn, p common.Packet)
 		}),
-		maxPacketSize: maxPacketSize,
-	}, nil
+		namedPackets: make(map[string]struct {
+			packet   common.Packet
+			callback func(conn net.Conn, p common.Packet)
+		}),
+		namedTypes:      make(map[reflect.Type]string),
+		requestHandlers: make(map[reflect.Type]func(ctx context.Context, req common.Packet) (common.Packet, error)),
+		maxPacketSize:   maxPacketSize,
+		maxHandlers:     common.DefaultMaxHandlers,
+		dial:            dialTCP,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.optionErr != nil {
+		return nil, c.optionErr
+	}
+
+	return c, nil
+}
+
+func dialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
 }
 
 func (c *TCPClient) Connect(addr string) error {
-	var err error
+	return c.ConnectContext(context.Background(), addr)
+}
 
-	if c.conn, err = net.Dial("tcp", addr); err != nil {
-		c.conn = nil
-		c.isConnected = false
+// ConnectContext is like Connect, but gives up on connecting once the context ends.
+// Once connected, cancelling the context does not affect the connection.
+func (c *TCPClient) ConnectContext(ctx context.Context, addr string) error {
+	l, err := c.connect(ctx, addr)
+	if err != nil {
 		return &ConnectErr{
 			Host: addr,
 			Err:  err,
 		}
 	}
 
-	c.isConnected = true
+	c.connMutex.Lock()
+	c.setLink(l)
+	c.addr = addr
+	c.connMutex.Unlock()
+
 	return nil
 }
 
+// link is a connection to the server along with everything agreed on with the server when connecting
+type link struct {
+	conn       net.Conn
+	reader     *common.FrameReader
+	serializer common.Serializer
+	packetIDs  map[string]uint16
+	calls      *common.Calls
+}
+
+// setLink makes the client use the given connection, or clears the connection if it is nil.
+// Calls still in progress on the previous connection fail with NotConnectedErr. connMutex must be held.
+func (c *TCPClient) setLink(l *link) {
+	if c.calls != nil {
+		c.calls.Close(&NotConnectedErr{})
+	}
+
+	if l == nil {
+		l = &link{}
+	}
+
+	c.conn = l.conn
+	c.reader = l.reader
+	c.serializer = l.serializer
+	c.packetIDs = l.packetIDs
+	c.calls = l.calls
+	c.packetNames = make(map[uint16]string, len(l.packetIDs))
+	for name, packetID := range l.packetIDs {
+		c.packetNames[packetID] = name
+	}
+	c.isConnected = l.conn != nil
+}
+
+// connect dials the server, exchanges hellos with it, learns the IDs of its named packet types,
+// agrees on a serializer with it if the client offers any and, if the server requires authentication,
+// presents the client's credentials and waits for the server to admit it
+func (c *TCPClient) connect(ctx context.Context, addr string) (*link, error) {
+	conn, err := c.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := common.NewFrameReader(conn, c.maxPacketSize)
+
+	remote, err := c.hello(ctx, conn, reader)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var packetIDs map[string]uint16
+	if remote.Has(common.FeatureNamedPackets) {
+		if packetIDs, err = receivePacketNames(ctx, conn, reader); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	var serializer common.Serializer = common.DefaultCodec
+	if len(c.serializers) > 0 {
+		if remote.Has(common.FeatureSerializers) {
+			serializer, err = c.negotiateSerializer(ctx, conn, reader)
+		} else if serializer = common.ChooseSerializer([]string{common.DefaultCodec.Name()}, c.serializers); serializer == nil {
+			err = &common.ProtocolErr{Err: errors.New("the server does not negotiate serializers and the default codec was not offered")}
+		}
+
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if remote.Has(common.FeatureAuth) {
+		if c.credentials == nil {
+			conn.Close()
+			return nil, &CredentialsRequiredErr{}
+		}
+
+		if err := c.authenticate(ctx, conn, reader); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return &link{
+		conn:       conn,
+		reader:     reader,
+		serializer: serializer,
+		packetIDs:  packetIDs,
+		calls:      common.NewCalls(c.maxHandlers),
+	}, nil
+}
+
+// setHandshakeDeadline bounds a step of connecting by the context's deadline, or handshakeTimeout if it has none
+func setHandshakeDeadline(ctx context.Context, conn net.Conn) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(handshakeTimeout)
+	}
+
+	conn.SetDeadline(deadline)
+}
+
+// hello introduces the client to the server and checks the server's hello in return.
+// A *common.VersionMismatchErr is returned if either side does not accept the other's version.
+func (c *TCPClient) hello(ctx context.Context, conn net.Conn, reader *common.FrameReader) (common.Hello, error) {
+	setHandshakeDeadline(ctx, conn)
+	defer conn.SetDeadline(time.Time{})
+
+	local := common.Hello{Version: common.ProtocolVersion, AppVersion: c.appVersion}
+	if len(c.serializers) > 0 {
+		local.Features |= common.FeatureSerializers
+	}
+	if c.credentials != nil {
+		local.Features |= common.FeatureAuth
+	}
+
+	if err := common.WriteHello(conn, local); err != nil {
+		return common.Hello{}, err
+	}
+
+	isHello, err := reader.PeekHello()
+	if err != nil {
+		return common.Hello{}, err
+	}
+
+	if !isHello {
+		// A server that turns the client away sends a goodbye instead of its hello
+		err := readGoodbye(reader)
+		if disconnectErr, ok := err.(*common.DisconnectErr); ok && disconnectErr.Reason == common.CloseVersionMismatch {
+			return common.Hello{}, &common.VersionMismatchErr{Message: disconnectErr.Message}
+		}
+
+		return common.Hello{}, err
+	}
+
+	remote, err := reader.ReadHello()
+	if err != nil {
+		return common.Hello{}, &common.ProtocolErr{Err: err}
+	}
+
+	if err := common.CheckVersion(c.versionCheck, remote); err != nil {
+		common.WriteControlFrame(conn, common.ControlGoodbye, common.EncodeGoodbye(common.CloseVersionMismatch, err.(*common.VersionMismatchErr).Message))
+		return common.Hello{}, err
+	}
+
+	return remote, nil
+}
+
+// negotiateSerializer offers the client's serializers and returns the one the server chose
+func (c *TCPClient) negotiateSerializer(ctx context.Context, conn net.Conn, reader *common.FrameReader) (common.Serializer, error) {
+	setHandshakeDeadline(ctx, conn)
+	defer conn.SetDeadline(time.Time{})
+
+	if err := common.WriteControlFrame(conn, common.ControlSerializer, common.EncodeSerializers(c.serializers)); err != nil {
+		return nil, err
+	}
+
+	frame, err := readHandshakeFrame(reader, common.ControlSerializer)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := common.ParseSerializers(frame.Payload)
+	if err != nil {
+		return nil, &common.ProtocolErr{Err: err}
+	}
+
+	if len(names) != 1 {
+		return nil, &common.ProtocolErr{Err: fmt.Errorf("expected the server to choose one serializer, got %d", len(names))}
+	}
+
+	serializer := common.ChooseSerializer(names, c.serializers)
+	if serializer == nil {
+		return nil, &common.ProtocolErr{Err: fmt.Errorf("the server chose serializer %q, which was not offered", names[0])}
+	}
+
+	return serializer, nil
+}
+
+// readHandshakeFrame reads the server's answer to a step of connecting, which is either
+// the expected control frame or a goodbye saying why the server turned the client away
+func readHandshakeFrame(reader *common.FrameReader, expected common.ControlType) (common.Frame, error) {
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		return common.Frame{}, err
+	}
+
+	if !frame.IsControl() {
+		return common.Frame{}, &common.ProtocolErr{Err: fmt.Errorf("expected the server to answer the handshake, got packet with ID %d", frame.PacketID)}
+	}
+
+	switch common.ControlType(frame.PacketID) {
+	case expected:
+		return frame, nil
+	case common.ControlGoodbye:
+		reason, message, err := common.ParseGoodbye(frame.Payload)
+		if err != nil {
+			return common.Frame{}, &common.ProtocolErr{Err: err}
+		}
+
+		return common.Frame{}, &common.DisconnectErr{Reason: reason, Message: message}
+	default:
+		return common.Frame{}, &common.ProtocolErr{Err: fmt.Errorf("expected the server to answer the handshake, got control type %d", frame.PacketID)}
+	}
+}
+
+// readGoodbye reads the goodbye a server that turned the client away sent instead of its hello,
+// and returns the *common.DisconnectErr it carries
+func readGoodbye(reader *common.FrameReader) error {
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		return err
+	}
+
+	if !frame.IsControl() || common.ControlType(frame.PacketID) != common.ControlGoodbye {
+		return &common.ProtocolErr{Err: errors.New("the server did not say hello, so it is not speaking this protocol")}
+	}
+
+	reason, message, err := common.ParseGoodbye(frame.Payload)
+	if err != nil {
+		return &common.ProtocolErr{Err: err}
+	}
+
+	return &common.DisconnectErr{Reason: reason, Message: message}
+}
+
+// authenticate presents the client's credentials and waits for the server to accept or reject them
+func (c *TCPClient) authenticate(ctx context.Context, conn net.Conn, reader *common.FrameReader) error {
+	setHandshakeDeadline(ctx, conn)
+	defer conn.SetDeadline(time.Time{})
+
+	buffer := make([]byte, c.credentials.Size())
+	n, err := c.credentials.Read(buffer)
+	if err != nil {
+		return err
+	}
+
+	if err := common.WriteControlFrame(conn, common.ControlAuth, buffer[:n]); err != nil {
+		return err
+	}
+
+	_, err = readHandshakeFrame(reader, common.ControlAccept)
+	return err
+}
+
+// Disconnect says goodbye to the server, closes the write side of the connection and waits
+// up to common.GoodbyeTimeout for the server to close its side before closing the connection
 func (c *TCPClient) Disconnect() error {
+	c.connMutex.Lock()
+	stopRun := c.stopRun
+	runDone := c.runDone
+
+	if c.reconnecting {
+		c.reconnecting = false
+		c.sendQueue = nil
+		c.connMutex.Unlock()
+
+		if stopRun != nil {
+			stopRun()
+		}
+		return nil
+	}
+
 	if !c.isConnected {
+		c.connMutex.Unlock()
+
+		if stopRun != nil {
+			stopRun()
+		}
 		return &NotConnectedErr{}
 	}
 
-	c.conn = nil
-	c.isConnected = false
-	return nil
+	conn := c.conn
+	c.setLink(nil)
+	c.connMutex.Unlock()
+
+	if stopRun != nil {
+		stopRun()
+	}
+
+	return closeGracefully(conn, runDone)
 }
 
-func (c *TCPClient) Addr() (net.Addr, error) {
+// closeGracefully sends a goodbye on the connection and closes it once the server has closed its side.
+// If Run is reading from the connection, runDone is closed once it sees the connection end.
+// Otherwise, the connection is drained here until the server closes it.
+func closeGracefully(conn net.Conn, runDone <-chan struct{}) error {
+	conn.SetWriteDeadline(time.Now().Add(common.GoodbyeTimeout))
+	common.WriteControlFrame(conn, common.ControlGoodbye, common.EncodeGoodbye(common.CloseNormal, ""))
+	common.CloseWrite(conn)
+
+	conn.SetReadDeadline(time.Now().Add(common.GoodbyeTimeout))
+	if runDone != nil {
+		timer := time.NewTimer(common.GoodbyeTimeout)
+		select {
+		case <-runDone:
+		case <-timer.C:
+		}
+		timer.Stop()
+	} else {
+		io.Copy(ioutil.Discard, conn)
+	}
+
+	return conn.Close()
+}
+
+// connection returns the current connection and its frame reader,
+// or NotConnectedErr if the client is not connected
+func (c *TCPClient) connection() (net.Conn, *common.FrameReader, error) {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+
 	if !c.isConnected {
-		return nil, &NotConnectedErr{}
+		return nil, nil, &NotConnectedErr{}
 	}
 
-	return c.conn.LocalAddr(), nil
+	return c.conn, c.reader, nil
 }
 
-func (c *TCPClient) ServerAddr() (net.Addr, error) {
+// Serializer returns the serializer agreed on with the server for the current connection,
+// or NotConnectedErr if the client is not connected
+func (c *TCPClient) Serializer() (common.Serializer, error) {
+	c.connMutex.RLock()
+	defer c.connMutex.RUnlock()
+
 	if !c.isConnected {
 		return nil, &NotConnectedErr{}
 	}
 
-	return c.conn.RemoteAddr(), nil
+	return c.serializer, nil
 }
 
+func (c *TCPClient) Addr() (net.Addr, error) {
+	conn, _, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.LocalAddr(), nil
+}
+
+func (c *TCPClient) ServerAddr() (net.Addr, error) {
+	conn, _, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.RemoteAddr(), nil
+}
+
+// SendPacket sends the given packet to the server. While the client is reconnecting,
+// the packet is queued instead if the reconnect policy has a send queue.
 func (c *TCPClient) SendPacket(p common.Packet) error {
-	if !c.isConnected {
-		return &NotConnectedErr{}
+	c.connMutex.Lock()
+	if c.reconnecting && c.reconnectPolicy.QueueSize > 0 {
+		defer c.connMutex.Unlock()
+
+		// The queue may grow past its size once reconnected, while it is being sent
+		if !c.isConnected && len(c.sendQueue) >= c.reconnectPolicy.QueueSize {
+			return &QueueFullErr{PacketID: p.ID()}
+		}
+
+		c.sendQueue = append(c.sendQueue, p)
+		return nil
 	}
+	c.connMutex.Unlock()
 
-	packetBuffer := make([]byte, c.maxPacketSize)
-	packetID := p.ID()
-	packetBuffer[0] = packetID
+	conn, _, err := c.connection()
+	if err != nil {
+		return err
+	}
+
+	return c.writePacket(conn, p, common.FrameHeader{})
+}
+
+// writePacket sends the given packet on the given connection in a frame with the given flags and call ID
+func (c *TCPClient) writePacket(conn net.Conn, p common.Packet, header common.FrameHeader) error {
+	packetID, err := c.packetID(p)
+	if err != nil {
+		return err
+	}
+
+	c.connMutex.RLock()
+	serializer := c.serializer
+	c.connMutex.RUnlock()
 
-	n, err := p.Read(packetBuffer[1:])
+	header.PacketID = packetID
+	frame, err := common.EncodeFrame(header, p, serializer, c.maxPacketSize)
 	if err != nil {
 		return err
 	}
 
-	if _, err := c.conn.Write(packetBuffer[:n+1]); err != nil {
+	_, err = conn.Write(*frame)
+	common.PutBuffer(frame)
+	if err != nil {
 		return &common.SendErr{
 			PacketID: packetID,
 			Err:      err,
@@ -102,49 +550,192 @@ func (c *TCPClient) SendPacket(p common.Packet) error {
 }
 
 func (c *TCPClient) ReceivePacket() error {
-	if !c.isConnected {
-		return &NotConnectedErr{}
+	conn, reader, err := c.connection()
+	if err != nil {
+		return err
+	}
+
+	serializer, err := c.Serializer()
+	if err != nil {
+		return err
 	}
 
-	packetBuffer := make([]byte, c.maxPacketSize)
-	n, err := c.conn.Read(packetBuffer)
+	c.connMutex.RLock()
+	calls := c.calls
+	c.connMutex.RUnlock()
+
+	frame, err := reader.ReadFrame()
 	if err != nil {
 		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 			return &common.TimeoutErr{}
 		}
 
 		if err == io.EOF {
-			return &common.DisconnectErr{}
+			return &common.DisconnectErr{Reason: common.CloseAbnormal}
+		}
+
+		if _, ok := err.(*common.FrameTooLargeErr); ok {
+			return err
 		}
 
 		return &common.ReceiveErr{Err: err}
 	}
 
-	if n < 1 {
-		return &common.ReceiveErr{Err: errors.New("received empty packet")}
+	if frame.IsControl() {
+		return c.handleControlFrame(conn, calls, frame)
 	}
 
-	packetID := packetBuffer[0]
-	packetBuffer = packetBuffer[1:n]
+	if frame.IsResponse() && frame.Flags&common.FlagError != 0 {
+		return handleCallErr(calls, frame)
+	}
 
-	p, ok := c.registeredPackets[packetID]
+	prototype, callback, ok := c.registeredPacket(frame.PacketID)
 	if !ok {
-		return &common.PacketNotRegisteredErr{PacketID: packetID}
+		return &common.PacketNotRegisteredErr{PacketID: frame.PacketID}
 	}
 
-	if _, err := p.packet.Write(packetBuffer); err != nil {
+	packet := common.NewPacket(prototype)
+	if err := common.DecodePacket(packet, frame.Payload, serializer); err != nil {
 		return err
 	}
 
-	if p.callback != nil {
-		p.callback(c.conn, p.packet)
+	switch {
+	case frame.IsResponse():
+		calls.Resolve(frame.CallID, common.CallResult{Packet: packet})
+	case frame.IsRequest():
+		return c.handleRequest(conn, calls, frame, packet)
+	case callback != nil:
+		callback(conn, packet)
 	}
 
 	return nil
 }
 
+// handleControlFrame handles a control message sent by the server
+func (c *TCPClient) handleControlFrame(conn net.Conn, calls *common.Calls, frame common.Frame) error {
+	switch common.ControlType(frame.PacketID) {
+	case common.ControlGoodbye:
+		reason, message, err := common.ParseGoodbye(frame.Payload)
+		if err != nil {
+			return &common.ProtocolErr{Err: err}
+		}
+
+		return &common.DisconnectErr{Reason: reason, Message: message}
+	case common.ControlPing:
+		common.WriteControlFrame(conn, common.ControlPong, frame.Payload)
+		return nil
+	case common.ControlPong, common.ControlAccept:
+		return nil
+	case common.ControlCancel:
+		calls.Cancel(frame.CallID)
+		return nil
+	default:
+		return &common.ProtocolErr{Err: fmt.Errorf("unknown control type %d", frame.PacketID)}
+	}
+}
+
+// Run receives packets from the server and calls their registered callbacks until the connection ends,
+// Disconnect is called or the context is cancelled. If the client has a reconnect policy, Run reconnects
+// whenever the connection is lost, unless the client was kicked, and only stops once reconnecting fails.
+// When it stops, the OnDisconnected callback is called with the same error Run returns. It is nil if the
+// client disconnected itself, and a *common.DisconnectErr with the server's reason if the server closed the connection.
+func (c *TCPClient) Run(ctx context.Context) error {
+	conn, _, err := c.connection()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
+
+	runDone := make(chan struct{})
+	defer close(runDone)
+
+	c.connMutex.Lock()
+	c.stopRun = cancelFunc
+	c.runDone = runDone
+	c.connMutex.Unlock()
+
+	defer func() {
+		c.connMutex.Lock()
+		c.stopRun = nil
+		c.runDone = nil
+		c.connMutex.Unlock()
+	}()
+
+	for {
+		err = c.receiveUntilDisconnected(ctx, conn)
+
+		// The connection was closed locally by Disconnect or by cancelling the context
+		if ctx.Err() != nil {
+			err = nil
+			break
+		}
+
+		reconnect := c.reconnectPolicy != nil && !isKicked(err)
+		c.connectionLost(conn, err, reconnect)
+		if !reconnect {
+			break
+		}
+
+		if conn, err = c.reconnect(ctx, err); err != nil {
+			if ctx.Err() != nil {
+				err = nil
+			}
+
+			break
+		}
+	}
+
+	if _, ok := err.(*NotConnectedErr); ok {
+		err = nil
+	}
+
+	if c.onDisconnected != nil {
+		c.onDisconnected(err)
+	}
+
+	return err
+}
+
+// receiveUntilDisconnected receives packets from the given connection until it
+// fails or the context is cancelled, in which case the client is disconnected
+func (c *TCPClient) receiveUntilDisconnected(ctx context.Context, conn net.Conn) error {
+	receiveDone := make(chan struct{})
+	defer close(receiveDone)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.disconnectConn(conn)
+		case <-receiveDone:
+		}
+	}()
+
+	for {
+		if err := c.ReceivePacket(); err != nil {
+			return err
+		}
+	}
+}
+
+// disconnectConn disconnects the client only if it is still using the given connection
+func (c *TCPClient) disconnectConn(conn net.Conn) {
+	c.connMutex.RLock()
+	current := c.conn
+	c.connMutex.RUnlock()
+
+	if current == conn {
+		c.Disconnect()
+	}
+}
+
 func (c *TCPClient) RegisterPacketType(p common.Packet, receiveCallback func(conn net.Conn, p common.Packet)) error {
 	packetID := p.ID()
+	if packetID >= common.FirstNamedPacketID {
+		return &common.InvalidPacketIDErr{PacketID: packetID}
+	}
+
 	if _, ok := c.registeredPackets[packetID]; ok {
 		return &common.PacketRegisteredErr{PacketID: packetID}
 	}
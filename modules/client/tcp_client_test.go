@@ -1,10 +1,15 @@
 package client_test
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/rpj5582/gochat/modules/client"
 	"github.com/rpj5582/gochat/modules/common"
@@ -14,7 +19,7 @@ import (
 
 type TestPacket struct{}
 
-func (p TestPacket) ID() uint8 {
+func (p TestPacket) ID() uint16 {
 	return 0
 }
 
@@ -29,6 +34,73 @@ func (p TestPacket) Read(buffer []byte) (int, error) {
 	return copy(buffer, []byte("test data")), nil
 }
 
+// helloListener answers the hello of every client it accepts the way a server does,
+// so tests can connect clients to it and then speak to them frame by frame
+type helloListener struct {
+	net.Listener
+	conns chan net.Conn
+}
+
+func newLocalListener() (net.Listener, error) {
+	listener, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		return nil, err
+	}
+
+	l := &helloListener{Listener: listener, conns: make(chan net.Conn, 10)}
+	go l.serve()
+
+	return l, nil
+}
+
+func (l *helloListener) serve() {
+	defer close(l.conns)
+
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if err := answerHello(conn); err != nil {
+			conn.Close()
+			continue
+		}
+
+		l.conns <- conn
+	}
+}
+
+// Close closes the listener and resets the connections that were never accepted,
+// just as closing a listener resets the connections still waiting in its backlog
+func (l *helloListener) Close() error {
+	err := l.Listener.Close()
+	for conn := range l.conns {
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}
+
+	return err
+}
+
+func (l *helloListener) Accept() (net.Conn, error) {
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, errors.New("listener closed")
+	}
+
+	return conn, nil
+}
+
+// answerHello reads the client's hello without buffering past it and answers with the server's own
+func answerHello(conn net.Conn) error {
+	if _, err := common.ReadHello(conn); err != nil {
+		return err
+	}
+
+	return common.WriteHello(conn, common.Hello{Version: common.ProtocolVersion})
+}
+
 func TestNewTCPClientInvalidMaxPacketSize(t *testing.T) {
 	c, err := client.NewTCPClient(0)
 	assert.Nil(t, c)
@@ -55,7 +127,7 @@ func TestTCPClientConnectSuccess(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.NoError(t, err)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -76,7 +148,7 @@ func TestTCPClientDisconnectSuccess(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.NoError(t, err)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -101,7 +173,7 @@ func TestTCPClientAddrSuccess(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.NoError(t, err)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -127,7 +199,7 @@ func TestTCPClientServerAddrSuccess(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.NoError(t, err)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -152,7 +224,7 @@ func TestTCPClientSendPacketFailure(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.NoError(t, err)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -169,7 +241,7 @@ func TestTCPClientSendPacketSuccess(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.NoError(t, err)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -193,7 +265,7 @@ func TestTCPClientReceivePacketDisconnected(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.NoError(t, err)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -202,7 +274,7 @@ func TestTCPClientReceivePacketDisconnected(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err = c.ReceivePacket()
+		err := c.ReceivePacket()
 		assert.IsType(t, &common.DisconnectErr{}, err)
 		wg.Done()
 	}()
@@ -221,7 +293,7 @@ func TestTCPClientReceivePacketNotRegistered(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.NoError(t, err)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -230,7 +302,7 @@ func TestTCPClientReceivePacketNotRegistered(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err = c.ReceivePacket()
+		err := c.ReceivePacket()
 		assert.IsType(t, &common.PacketNotRegisteredErr{}, err)
 		wg.Done()
 	}()
@@ -238,7 +310,7 @@ func TestTCPClientReceivePacketNotRegistered(t *testing.T) {
 	conn, err := listener.Accept()
 	assert.NoError(t, err)
 
-	conn.Write([]byte("unregistered packet"))
+	common.WriteFrame(conn, 1, []byte("test data"))
 
 	conn.Close()
 	listener.Close()
@@ -253,7 +325,7 @@ func TestTCPClientReceivePacketUnmarshalFailure(t *testing.T) {
 
 	c.RegisterPacketType(&TestPacket{}, nil)
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -262,7 +334,7 @@ func TestTCPClientReceivePacketUnmarshalFailure(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err = c.ReceivePacket()
+		err := c.ReceivePacket()
 		assert.Error(t, err)
 		wg.Done()
 	}()
@@ -270,9 +342,7 @@ func TestTCPClientReceivePacketUnmarshalFailure(t *testing.T) {
 	conn, err := listener.Accept()
 	assert.NoError(t, err)
 
-	data := []byte{0}
-	data = append(data, []byte("unregistered packet")...)
-	conn.Write(data)
+	common.WriteFrame(conn, 0, []byte("unknown"))
 
 	conn.Close()
 	listener.Close()
@@ -287,7 +357,7 @@ func TestTCPClientReceivePacketSuccess(t *testing.T) {
 
 	c.RegisterPacketType(&TestPacket{}, func(conn net.Conn, p common.Packet) {})
 
-	listener, err := nettest.NewLocalListener("tcp")
+	listener, err := newLocalListener()
 	assert.NoError(t, err)
 
 	err = c.Connect(listener.Addr().String())
@@ -296,7 +366,7 @@ func TestTCPClientReceivePacketSuccess(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err = c.ReceivePacket()
+		err := c.ReceivePacket()
 		assert.NoError(t, err)
 		wg.Done()
 	}()
@@ -304,9 +374,7 @@ func TestTCPClientReceivePacketSuccess(t *testing.T) {
 	conn, err := listener.Accept()
 	assert.NoError(t, err)
 
-	data := []byte{0}
-	data = append(data, []byte("test data")...)
-	conn.Write(data)
+	common.WriteFrame(conn, 0, []byte("test data"))
 
 	conn.Close()
 	listener.Close()
@@ -325,3 +393,387 @@ func TestTCPClientRegisterPacketType(t *testing.T) {
 	err = c.RegisterPacketType(&TestPacket{}, func(conn net.Conn, p common.Packet) {})
 	assert.IsType(t, &common.PacketRegisteredErr{}, err)
 }
+
+func TestTCPClientReceivePacketSplitAndMergedWrites(t *testing.T) {
+	c, err := client.NewTCPClient(10)
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	received := 0
+	c.RegisterPacketType(&TestPacket{}, func(conn net.Conn, p common.Packet) {
+		received++
+	})
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			err := c.ReceivePacket()
+			assert.NoError(t, err)
+		}
+		wg.Done()
+	}()
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+
+	var buffer bytes.Buffer
+	for i := 0; i < 3; i++ {
+		common.WriteFrame(&buffer, 0, []byte("test data"))
+	}
+	data := buffer.Bytes()
+
+	// The first frame is split in two and the second write merges the rest of it with the two frames after it
+	conn.Write(data[:7])
+	time.Sleep(time.Millisecond * 10)
+	conn.Write(data[7:])
+
+	wg.Wait()
+
+	conn.Close()
+	listener.Close()
+
+	assert.Equal(t, 3, received)
+}
+
+func TestTCPClientConnectContextCancelled(t *testing.T) {
+	c, err := client.NewTCPClient(10)
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+
+	err = c.ConnectContext(ctx, listener.Addr().String())
+	assert.IsType(t, &client.ConnectErr{}, err)
+
+	_, err = c.Addr()
+	assert.IsType(t, &client.NotConnectedErr{}, err)
+}
+
+func TestTCPClientConnectContextSuccess(t *testing.T) {
+	c, err := client.NewTCPClient(10)
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFunc()
+
+	err = c.ConnectContext(ctx, listener.Addr().String())
+	assert.NoError(t, err)
+}
+
+func TestTCPClientRunNotConnected(t *testing.T) {
+	c, err := client.NewTCPClient(10)
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	err = c.Run(context.Background())
+	assert.IsType(t, &client.NotConnectedErr{}, err)
+}
+
+func TestTCPClientRunDispatchesUntilServerCloses(t *testing.T) {
+	disconnected := make(chan error, 1)
+	c, err := client.NewTCPClient(10, client.WithOnDisconnected(func(err error) {
+		disconnected <- err
+	}))
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	received := 0
+	c.RegisterPacketType(&TestPacket{}, func(conn net.Conn, p common.Packet) {
+		received++
+	})
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		common.WriteFrame(conn, 0, []byte("test data"))
+	}
+	common.WriteControlFrame(conn, common.ControlGoodbye, common.EncodeGoodbye(common.CloseGoingAway, ""))
+	conn.Close()
+
+	err = c.Run(context.Background())
+	assert.Equal(t, &common.DisconnectErr{Reason: common.CloseGoingAway}, err)
+	assert.Equal(t, err, <-disconnected)
+	assert.Equal(t, 3, received)
+
+	_, err = c.Addr()
+	assert.IsType(t, &client.NotConnectedErr{}, err)
+}
+
+func TestTCPClientRunServerClosesWithoutGoodbye(t *testing.T) {
+	c, err := client.NewTCPClient(10)
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+	conn.Close()
+
+	err = c.Run(context.Background())
+	assert.Equal(t, &common.DisconnectErr{Reason: common.CloseAbnormal}, err)
+}
+
+func TestTCPClientRunReportsErrors(t *testing.T) {
+	disconnected := make(chan error, 1)
+	c, err := client.NewTCPClient(10, client.WithOnDisconnected(func(err error) {
+		disconnected <- err
+	}))
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	common.WriteFrame(conn, 1, []byte("test data"))
+
+	err = c.Run(context.Background())
+	assert.IsType(t, &common.PacketNotRegisteredErr{}, err)
+	assert.Equal(t, err, <-disconnected)
+}
+
+func TestTCPClientRequestHandlerLimits(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	c, err := client.NewTCPClient(100, client.WithMaxRequestHandlers(1))
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	err = c.RegisterPacketType(&TestPacket{}, nil)
+	assert.NoError(t, err)
+
+	err = c.HandleRequest(&TestPacket{}, func(ctx context.Context, req common.Packet) (common.Packet, error) {
+		<-release
+		return nil, ctx.Err()
+	})
+	assert.NoError(t, err)
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(context.Background())
+	}()
+
+	writeRequest := func(callID uint32) {
+		frame := make([]byte, common.FrameHeaderSize+len("test data"))
+		common.PutFrameHeader(frame, common.FrameHeader{Length: uint32(len("test data")), Flags: common.FlagRequest, CallID: callID})
+		copy(frame[common.FrameHeaderSize:], "test data")
+
+		_, err := conn.Write(frame)
+		assert.NoError(t, err)
+	}
+
+	// The client is already running its one handler, so it turns the second request away
+	writeRequest(1)
+	writeRequest(2)
+
+	frame, err := common.NewFrameReader(conn, 100).ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsResponse())
+	assert.Equal(t, uint32(2), frame.CallID)
+
+	callErr, err := common.ParseCallErr(frame.Payload)
+	if assert.NoError(t, err) {
+		assert.Equal(t, common.CallErrBusy, callErr.Code)
+	}
+
+	// A request under the call ID of one still being handled is a protocol error
+	writeRequest(1)
+
+	select {
+	case err := <-runErr:
+		assert.IsType(t, &common.ProtocolErr{}, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("client did not stop")
+	}
+}
+
+func TestTCPClientRunStopsOnDisconnect(t *testing.T) {
+	disconnected := make(chan error, 1)
+	c, err := client.NewTCPClient(10, client.WithOnDisconnected(func(err error) {
+		disconnected <- err
+	}))
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(context.Background())
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	assert.NoError(t, <-runErr)
+	assert.NoError(t, <-disconnected)
+}
+
+func TestTCPClientRunStopsOnContextCancel(t *testing.T) {
+	c, err := client.NewTCPClient(10)
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	listener, err := newLocalListener()
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	err = c.Connect(listener.Addr().String())
+	assert.NoError(t, err)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(ctx)
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	cancelFunc()
+
+	assert.NoError(t, <-runErr)
+
+	_, err = c.Addr()
+	assert.IsType(t, &client.NotConnectedErr{}, err)
+}
+
+type benchmarkPacket struct {
+	Message string
+}
+
+func (p benchmarkPacket) ID() uint16 {
+	return 20
+}
+
+// connectBenchmarkClient connects a client with the max packet size of the example chat to a local listener
+func connectBenchmarkClient(b *testing.B) (*client.TCPClient, net.Conn) {
+	listener, err := newLocalListener()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { listener.Close() })
+
+	c, err := client.NewTCPClient(65535)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	err = c.RegisterPacketType(&benchmarkPacket{}, func(conn net.Conn, p common.Packet) {})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if err := c.Connect(listener.Addr().String()); err != nil {
+		b.Fatal(err)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+
+	return c, conn
+}
+
+func BenchmarkTCPClientSendPacket(b *testing.B) {
+	c, conn := connectBenchmarkClient(b)
+	go io.Copy(ioutil.Discard, conn)
+
+	p := &benchmarkPacket{Message: "hello, this is a chat message"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.SendPacket(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTCPClientReceivePacket(b *testing.B) {
+	c, conn := connectBenchmarkClient(b)
+
+	buffer := make([]byte, 100)
+	n, err := common.DefaultCodec.Encode(buffer, &benchmarkPacket{Message: "hello, this is a chat message"})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var frames bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		common.WriteFrame(&frames, benchmarkPacket{}.ID(), buffer[:n])
+	}
+
+	go func() {
+		for {
+			if _, err := conn.Write(frames.Bytes()); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.ReceivePacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+var _ Client = (*TLSClient)(nil)
+
+// TLSClient is a client that communicates with a server via TCP secured with TLS.
+// To authenticate with mutual TLS, set Certificates in its config.
+type TLSClient struct {
+	*TCPClient
+}
+
+// NewTLSClient returns an initialized TLS client ready to connect to a server
+func NewTLSClient(maxPacketSize int, config *tls.Config, opts ...Option) (*TLSClient, error) {
+	c, err := NewTCPClient(maxPacketSize, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		dialer := tls.Dialer{Config: config}
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	return &TLSClient{TCPClient: c}, nil
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// udpConnectRetryInterval is how often a connect request is resent while waiting for the server to answer
+const udpConnectRetryInterval = 250 * time.Millisecond
+
+// udpConnectTimeout is how long to wait for the server to answer when the connect context has no deadline
+const udpConnectTimeout = 5 * time.Second
+
+var _ Client = (*UDPClient)(nil)
+
+// UDPClient is a client that communicates with a server via UDP.
+// Packets are unreliable unless their type is made reliable with SetDeliveryMode.
+type UDPClient struct {
+	*TCPClient
+
+	deliveryModes *common.DeliveryModes
+}
+
+// NewUDPClient returns an initialized UDP client ready to connect to a server
+func NewUDPClient(maxPacketSize int, opts ...Option) (*UDPClient, error) {
+	c, err := NewTCPClient(maxPacketSize, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveryModes := common.NewDeliveryModes()
+	c.dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialUDP(ctx, addr, deliveryModes)
+	}
+
+	return &UDPClient{
+		TCPClient:     c,
+		deliveryModes: deliveryModes,
+	}, nil
+}
+
+// SetDeliveryMode sets how packets of the given type are sent to the server.
+// The server must use the same delivery mode for packets it sends. The ID of a packet type registered
+// by name is only known once connected, so its delivery mode can only be set while connected.
+func (c *UDPClient) SetDeliveryMode(p common.Packet, mode common.DeliveryMode) {
+	if packetID, err := c.packetID(p); err == nil {
+		c.deliveryModes.Set(packetID, mode)
+	}
+}
+
+// dialUDP opens a session with a UDP server, resending the connect request until it is answered
+func dialUDP(ctx context.Context, addr string, deliveryModes *common.DeliveryModes) (net.Conn, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancelFunc context.CancelFunc
+		ctx, cancelFunc = context.WithTimeout(ctx, udpConnectTimeout)
+		defer cancelFunc()
+	}
+
+	var dialer net.Dialer
+	udpConn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonceBuffer [8]byte
+	if _, err := rand.Read(nonceBuffer[:]); err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	nonce := binary.LittleEndian.Uint64(nonceBuffer[:])
+
+	connectRequest := common.AppendDatagram(nil, common.Datagram{Type: common.DatagramConnect, Token: nonce})
+	buffer := make([]byte, common.MaxDatagramSize)
+
+	var token uint64
+	for {
+		if _, err := udpConn.Write(connectRequest); err != nil {
+			udpConn.Close()
+			return nil, err
+		}
+
+		retryAt := time.Now().Add(udpConnectRetryInterval)
+		if deadline, _ := ctx.Deadline(); deadline.Before(retryAt) {
+			retryAt = deadline
+		}
+		udpConn.SetReadDeadline(retryAt)
+
+		n, err := udpConn.Read(buffer)
+		if err == nil {
+			d, err := common.ParseDatagram(buffer[:n])
+			if err == nil && d.Type == common.DatagramAccept && len(d.Payload) == 8 && binary.LittleEndian.Uint64(d.Payload) == nonce {
+				token = d.Token
+				break
+			}
+		}
+
+		if ctx.Err() != nil {
+			udpConn.Close()
+			return nil, errors.New("udp server did not answer connect request")
+		}
+	}
+
+	udpConn.SetReadDeadline(time.Time{})
+
+	conn := common.NewDatagramConn(token, udpConn.LocalAddr(), udpConn.RemoteAddr(), func(datagram []byte) error {
+		_, err := udpConn.Write(datagram)
+		return err
+	}, deliveryModes, func() {
+		udpConn.Close()
+	})
+
+	go func() {
+		buffer := make([]byte, common.MaxDatagramSize)
+		for {
+			n, err := udpConn.Read(buffer)
+			if err != nil {
+				conn.Close()
+				return
+			}
+
+			d, err := common.ParseDatagram(buffer[:n])
+			if err != nil || d.Token != token {
+				continue
+			}
+
+			conn.Deliver(d)
+		}
+	}()
+
+	return conn, nil
+}
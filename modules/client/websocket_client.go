@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+var _ Client = (*WebSocketClient)(nil)
+
+// WebSocketClient is a client that communicates with a server via WebSockets,
+// carrying each packet's frame in a single binary WebSocket message.
+// The address passed to Connect is a WebSocket URL such as "ws://localhost:20000/chat".
+type WebSocketClient struct {
+	*TCPClient
+}
+
+// NewWebSocketClient returns an initialized WebSocket client ready to connect to a server.
+// The origin is sent to the server during the WebSocket handshake.
+func NewWebSocketClient(maxPacketSize int, origin string, opts ...Option) (*WebSocketClient, error) {
+	c, err := NewTCPClient(maxPacketSize, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialWebSocket(ctx, addr, origin)
+	}
+
+	return &WebSocketClient{TCPClient: c}, nil
+}
+
+func dialWebSocket(ctx context.Context, addr string, origin string) (net.Conn, error) {
+	config, err := websocket.NewConfig(addr, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	host := config.Location.Host
+	if config.Location.Port() == "" {
+		port := "80"
+		if config.Location.Scheme == "wss" {
+			port = "443"
+		}
+
+		host = net.JoinHostPort(config.Location.Hostname(), port)
+	}
+
+	var conn net.Conn
+	if config.Location.Scheme == "wss" {
+		dialer := tls.Dialer{Config: config.TlsConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	ws.PayloadType = websocket.BinaryFrame
+
+	return ws, nil
+}
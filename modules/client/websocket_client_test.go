@@ -0,0 +1,40 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/nettest"
+)
+
+func TestWebSocketClientConnectInvalidURL(t *testing.T) {
+	c, err := client.NewWebSocketClient(10, "http://localhost/")
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	err = c.Connect("://not a url")
+	assert.IsType(t, &client.ConnectErr{}, err)
+}
+
+func TestWebSocketClientConnectHandshakeFailure(t *testing.T) {
+	c, err := client.NewWebSocketClient(10, "http://localhost/")
+	assert.NotNil(t, c)
+	assert.NoError(t, err)
+
+	listener, err := nettest.NewLocalListener("tcp")
+	assert.NoError(t, err)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Write([]byte("not a websocket handshake\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+
+	err = c.Connect("ws://" + listener.Addr().String() + "/chat")
+	assert.IsType(t, &client.ConnectErr{}, err)
+
+	listener.Close()
+}
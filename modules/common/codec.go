@@ -0,0 +1,488 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Codec encodes plain Go structs field by field, so a packet type only needs an ID method.
+//
+// Exported fields are encoded in the order they are declared. Strings, byte slices and slices are
+// prefixed with their length as a uvarint, int and uint as varints, and sized integers and floats
+// are fixed width little endian. Bools are a single byte, arrays and nested structs are encoded
+// element by element, and pointers are prefixed with a byte saying whether they are nil.
+//
+// Fields can be tagged to change how they are encoded:
+//
+//	Name    string   `gochat:"maxlen=32"` // reject names longer than 32 bytes
+//	Members []string `gochat:"maxlen=8"`  // reject more than 8 members
+//	Cache   []byte   `gochat:"-"`         // skip the field
+type Codec struct{}
+
+// DefaultCodec is the codec used for packets that do not encode themselves
+var DefaultCodec Codec
+
+// CodecErr is returned when a value cannot be encoded or decoded by the Codec.
+// Field is the path to the field that failed, such as "Members[2].Name".
+type CodecErr struct {
+	Field string
+	Err   error
+}
+
+func (e CodecErr) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("codec: %v", e.Err)
+	}
+
+	return fmt.Sprintf("codec: field %s: %v", e.Field, e.Err)
+}
+
+var (
+	errTruncated       = errors.New("data is truncated")
+	errTrailingData    = errors.New("data continues past the end of the value")
+	errInvalidBool     = errors.New("bool is neither 0 nor 1")
+	errInvalidPointer  = errors.New("pointer flag is neither 0 nor 1")
+	errInvalidVarint   = errors.New("varint is not minimally encoded")
+	errNotPointer      = errors.New("can only decode into a non-nil pointer")
+	errLengthOverflows = errors.New("length is larger than the remaining data")
+)
+
+// codecField describes how a single struct field is encoded
+type codecField struct {
+	name   string
+	index  int
+	maxLen int
+}
+
+var codecFields sync.Map
+
+// fieldsOf returns the encoded fields of the given struct type, parsing their tags the first time
+func fieldsOf(t reflect.Type) ([]codecField, error) {
+	if fields, ok := codecFields.Load(t); ok {
+		return fields.([]codecField), nil
+	}
+
+	var fields []codecField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		field := codecField{name: f.Name, index: i, maxLen: -1}
+
+		tag := f.Tag.Get("gochat")
+		if tag == "-" {
+			continue
+		}
+
+		for _, option := range strings.Split(tag, ",") {
+			switch {
+			case option == "":
+			case strings.HasPrefix(option, "maxlen="):
+				maxLen, err := strconv.Atoi(strings.TrimPrefix(option, "maxlen="))
+				if err != nil || maxLen < 0 {
+					return nil, &CodecErr{Field: f.Name, Err: fmt.Errorf("invalid maxlen in tag %q", tag)}
+				}
+
+				field.maxLen = maxLen
+			default:
+				return nil, &CodecErr{Field: f.Name, Err: fmt.Errorf("unknown tag option %q", option)}
+			}
+		}
+
+		fields = append(fields, field)
+	}
+
+	codecFields.Store(t, fields)
+	return fields, nil
+}
+
+// Encode encodes v into buffer and returns the number of bytes written.
+// io.ErrShortBuffer is returned if the buffer is too small to hold the encoded value.
+func (Codec) Encode(buffer []byte, v interface{}) (int, error) {
+	// Packets are usually passed by pointer, which is not part of their encoding
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return 0, &CodecErr{Err: errors.New("cannot encode a nil pointer")}
+		}
+		value = value.Elem()
+	}
+
+	e := encoder{buffer: buffer[:0]}
+	if err := e.encode(value, -1); err != nil {
+		return 0, err
+	}
+
+	if len(e.buffer) > len(buffer) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return len(e.buffer), nil
+}
+
+// Decode decodes data into v, which must be a non-nil pointer. Data that is
+// malformed, exceeds a maxlen tag or continues past the end of v is rejected.
+func (Codec) Decode(data []byte, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return &CodecErr{Err: errNotPointer}
+	}
+
+	d := decoder{data: data}
+	if err := d.decode(value.Elem(), -1); err != nil {
+		return err
+	}
+
+	if len(d.data) > 0 {
+		return &CodecErr{Err: errTrailingData}
+	}
+
+	return nil
+}
+
+// encoder appends encoded values to its buffer. The buffer starts out as the
+// caller's buffer, so values that fit are encoded without allocating.
+type encoder struct {
+	buffer []byte
+}
+
+func (e *encoder) uvarint(x uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], x)
+	e.buffer = append(e.buffer, scratch[:n]...)
+}
+
+func (e *encoder) length(n int, maxLen int) error {
+	if maxLen >= 0 && n > maxLen {
+		return &CodecErr{Err: fmt.Errorf("length %d exceeds maxlen of %d", n, maxLen)}
+	}
+
+	e.uvarint(uint64(n))
+	return nil
+}
+
+func (e *encoder) encode(v reflect.Value, maxLen int) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buffer = append(e.buffer, 1)
+		} else {
+			e.buffer = append(e.buffer, 0)
+		}
+	case reflect.Int:
+		var scratch [binary.MaxVarintLen64]byte
+		n := binary.PutVarint(scratch[:], v.Int())
+		e.buffer = append(e.buffer, scratch[:n]...)
+	case reflect.Uint:
+		e.uvarint(v.Uint())
+	case reflect.Int8, reflect.Uint8:
+		e.buffer = append(e.buffer, byte(integer(v)))
+	case reflect.Int16, reflect.Uint16:
+		var scratch [2]byte
+		binary.LittleEndian.PutUint16(scratch[:], uint16(integer(v)))
+		e.buffer = append(e.buffer, scratch[:]...)
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		var scratch [4]byte
+		if v.Kind() == reflect.Float32 {
+			binary.LittleEndian.PutUint32(scratch[:], math.Float32bits(float32(v.Float())))
+		} else {
+			binary.LittleEndian.PutUint32(scratch[:], uint32(integer(v)))
+		}
+		e.buffer = append(e.buffer, scratch[:]...)
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		var scratch [8]byte
+		if v.Kind() == reflect.Float64 {
+			binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(v.Float()))
+		} else {
+			binary.LittleEndian.PutUint64(scratch[:], integer(v))
+		}
+		e.buffer = append(e.buffer, scratch[:]...)
+	case reflect.String:
+		if err := e.length(v.Len(), maxLen); err != nil {
+			return err
+		}
+		e.buffer = append(e.buffer, v.String()...)
+	case reflect.Slice:
+		if err := e.length(v.Len(), maxLen); err != nil {
+			return err
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.buffer = append(e.buffer, v.Bytes()...)
+			return nil
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i), -1); err != nil {
+				return within(err, fmt.Sprintf("[%d]", i))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i), -1); err != nil {
+				return within(err, fmt.Sprintf("[%d]", i))
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			e.buffer = append(e.buffer, 0)
+			return nil
+		}
+
+		e.buffer = append(e.buffer, 1)
+		return e.encode(v.Elem(), maxLen)
+	case reflect.Struct:
+		fields, err := fieldsOf(v.Type())
+		if err != nil {
+			return err
+		}
+
+		for _, f := range fields {
+			if err := e.encode(v.Field(f.index), f.maxLen); err != nil {
+				return within(err, f.name)
+			}
+		}
+	default:
+		return &CodecErr{Err: fmt.Errorf("unsupported type %v", v.Type())}
+	}
+
+	return nil
+}
+
+// integer returns the bits of a signed or unsigned integer value
+func integer(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
+	default:
+		return v.Uint()
+	}
+}
+
+// within prefixes the field path of a CodecErr with the field or element it
+// was returned from. Paths are built as errors are returned rather than while
+// walking the value, so encoding and decoding do not allocate them.
+func within(err error, name string) error {
+	codecErr, ok := err.(*CodecErr)
+	if !ok {
+		return err
+	}
+
+	switch {
+	case codecErr.Field == "":
+		codecErr.Field = name
+	case codecErr.Field[0] == '[':
+		codecErr.Field = name + codecErr.Field
+	default:
+		codecErr.Field = name + "." + codecErr.Field
+	}
+
+	return codecErr
+}
+
+// decoder consumes encoded values from the front of its data
+type decoder struct {
+	data []byte
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n > len(d.data) {
+		return nil, &CodecErr{Err: errTruncated}
+	}
+
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *decoder) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, &CodecErr{Err: errTruncated}
+	}
+
+	// Padded varints would let two encodings decode to the same value
+	if n > 1 && d.data[n-1] == 0 {
+		return 0, &CodecErr{Err: errInvalidVarint}
+	}
+
+	d.data = d.data[n:]
+	return x, nil
+}
+
+// length decodes a length prefix, rejecting lengths over maxLen or
+// longer than the remaining data so malformed input cannot force huge allocations
+func (d *decoder) length(maxLen int) (int, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+
+	if maxLen >= 0 && n > uint64(maxLen) {
+		return 0, &CodecErr{Err: fmt.Errorf("length %d exceeds maxlen of %d", n, maxLen)}
+	}
+
+	if n > uint64(len(d.data)) {
+		return 0, &CodecErr{Err: errLengthOverflows}
+	}
+
+	return int(n), nil
+}
+
+func (d *decoder) decode(v reflect.Value, maxLen int) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+
+		if b[0] > 1 {
+			return &CodecErr{Err: errInvalidBool}
+		}
+		v.SetBool(b[0] == 1)
+	case reflect.Int:
+		ux, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		x := int64(ux >> 1)
+		if ux&1 != 0 {
+			x = ^x
+		}
+		v.SetInt(x)
+	case reflect.Uint:
+		x, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	case reflect.Int8, reflect.Uint8:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+		setInteger(v, uint64(b[0]))
+	case reflect.Int16, reflect.Uint16:
+		b, err := d.next(2)
+		if err != nil {
+			return err
+		}
+		setInteger(v, uint64(binary.LittleEndian.Uint16(b)))
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		b, err := d.next(4)
+		if err != nil {
+			return err
+		}
+
+		if v.Kind() == reflect.Float32 {
+			v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+		} else {
+			setInteger(v, uint64(binary.LittleEndian.Uint32(b)))
+		}
+	case reflect.Int64, reflect.Uint64, reflect.Float64:
+		b, err := d.next(8)
+		if err != nil {
+			return err
+		}
+
+		if v.Kind() == reflect.Float64 {
+			v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		} else {
+			setInteger(v, binary.LittleEndian.Uint64(b))
+		}
+	case reflect.String:
+		n, err := d.length(maxLen)
+		if err != nil {
+			return err
+		}
+
+		b, _ := d.next(n)
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, err := d.length(maxLen)
+		if err != nil {
+			return err
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, _ := d.next(n)
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+
+		slice := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decode(slice.Index(i), -1); err != nil {
+				return within(err, fmt.Sprintf("[%d]", i))
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.decode(v.Index(i), -1); err != nil {
+				return within(err, fmt.Sprintf("[%d]", i))
+			}
+		}
+	case reflect.Ptr:
+		b, err := d.next(1)
+		if err != nil {
+			return err
+		}
+
+		switch b[0] {
+		case 0:
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		case 1:
+			elem := reflect.New(v.Type().Elem())
+			if err := d.decode(elem.Elem(), maxLen); err != nil {
+				return err
+			}
+			v.Set(elem)
+		default:
+			return &CodecErr{Err: errInvalidPointer}
+		}
+	case reflect.Struct:
+		fields, err := fieldsOf(v.Type())
+		if err != nil {
+			return err
+		}
+
+		for _, f := range fields {
+			if err := d.decode(v.Field(f.index), f.maxLen); err != nil {
+				return within(err, f.name)
+			}
+		}
+	default:
+		return &CodecErr{Err: fmt.Errorf("unsupported type %v", v.Type())}
+	}
+
+	return nil
+}
+
+// setInteger sets a signed or unsigned integer value from its bits,
+// sign extending signed values that are narrower than 64 bits
+func setInteger(v reflect.Value, bits uint64) {
+	switch v.Kind() {
+	case reflect.Int8:
+		v.SetInt(int64(int8(bits)))
+	case reflect.Int16:
+		v.SetInt(int64(int16(bits)))
+	case reflect.Int32:
+		v.SetInt(int64(int32(bits)))
+	case reflect.Int64:
+		v.SetInt(int64(bits))
+	default:
+		v.SetUint(bits)
+	}
+}
@@ -0,0 +1,205 @@
+package common_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type codecMember struct {
+	Name  string `gochat:"maxlen=8"`
+	Admin bool
+}
+
+type codecTestPacket struct {
+	Text    string
+	Data    []byte
+	Count   int
+	Size    uint
+	Small   int8
+	Large   uint64
+	Ratio   float64
+	Flag    bool
+	Members []codecMember `gochat:"maxlen=4"`
+	Owner   *codecMember
+	Digest  [4]byte
+	Cache   []byte `gochat:"-"`
+	private int
+}
+
+func (p codecTestPacket) ID() uint16 {
+	return 1
+}
+
+func newCodecTestPacket() *codecTestPacket {
+	return &codecTestPacket{
+		Text:    "hello",
+		Data:    []byte{1, 2, 3},
+		Count:   -300,
+		Size:    300,
+		Small:   -1,
+		Large:   1 << 40,
+		Ratio:   0.5,
+		Flag:    true,
+		Members: []codecMember{{Name: "alice", Admin: true}, {Name: "bob"}},
+		Owner:   &codecMember{Name: "carol"},
+		Digest:  [4]byte{9, 8, 7, 6},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	p := newCodecTestPacket()
+	p.Cache = []byte("not sent")
+	p.private = 5
+
+	buffer := make([]byte, 100)
+	n, err := common.DefaultCodec.Encode(buffer, p)
+	assert.NoError(t, err)
+
+	var decoded codecTestPacket
+	err = common.DefaultCodec.Decode(buffer[:n], &decoded)
+	assert.NoError(t, err)
+
+	p.Cache = nil
+	p.private = 0
+	assert.Equal(t, *p, decoded)
+}
+
+func TestCodecNilPointerField(t *testing.T) {
+	p := newCodecTestPacket()
+	p.Owner = nil
+
+	buffer := make([]byte, 100)
+	n, err := common.DefaultCodec.Encode(buffer, p)
+	assert.NoError(t, err)
+
+	decoded := codecTestPacket{Owner: &codecMember{}}
+	err = common.DefaultCodec.Decode(buffer[:n], &decoded)
+	assert.NoError(t, err)
+	assert.Nil(t, decoded.Owner)
+}
+
+func TestCodecMaxLen(t *testing.T) {
+	p := newCodecTestPacket()
+	p.Members[1].Name = "bartholomew"
+
+	_, err := common.DefaultCodec.Encode(make([]byte, 100), p)
+	if assert.IsType(t, &common.CodecErr{}, err) {
+		assert.Equal(t, "Members[1].Name", err.(*common.CodecErr).Field)
+	}
+
+	// Encode the too long name with a type that does not limit it, then decode it with one that does
+	type unlimited struct {
+		Name string
+	}
+
+	buffer := make([]byte, 100)
+	n, err := common.DefaultCodec.Encode(buffer, unlimited{Name: "bartholomew"})
+	assert.NoError(t, err)
+
+	var member codecMember
+	err = common.DefaultCodec.Decode(append(buffer[:n], 0), &member)
+	if assert.IsType(t, &common.CodecErr{}, err) {
+		assert.Equal(t, "Name", err.(*common.CodecErr).Field)
+	}
+}
+
+func TestCodecEncodeDoesNotAllocate(t *testing.T) {
+	p := newCodecTestPacket()
+	buffer := make([]byte, 100)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = common.DefaultCodec.Encode(buffer, p)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestCodecShortBuffer(t *testing.T) {
+	_, err := common.DefaultCodec.Encode(make([]byte, 4), newCodecTestPacket())
+	assert.Equal(t, io.ErrShortBuffer, err)
+}
+
+func TestCodecMalformed(t *testing.T) {
+	buffer := make([]byte, 100)
+	n, err := common.DefaultCodec.Encode(buffer, newCodecTestPacket())
+	assert.NoError(t, err)
+
+	var decoded codecTestPacket
+	for i := 0; i < n; i++ {
+		err = common.DefaultCodec.Decode(buffer[:i], &decoded)
+		assert.IsType(t, &common.CodecErr{}, err, "truncated to %d bytes", i)
+	}
+
+	err = common.DefaultCodec.Decode(append(buffer[:n], 0), &decoded)
+	assert.IsType(t, &common.CodecErr{}, err)
+
+	err = common.DefaultCodec.Decode([]byte{2}, &decoded.Flag)
+	assert.IsType(t, &common.CodecErr{}, err)
+
+	err = common.DefaultCodec.Decode([]byte{0x80, 0x00}, &decoded.Count)
+	assert.IsType(t, &common.CodecErr{}, err)
+
+	err = common.DefaultCodec.Decode(buffer[:n], decoded)
+	assert.IsType(t, &common.CodecErr{}, err)
+}
+
+func TestCodecUnsupportedType(t *testing.T) {
+	type withMap struct {
+		Values map[string]int
+	}
+
+	_, err := common.DefaultCodec.Encode(make([]byte, 100), withMap{})
+	if assert.IsType(t, &common.CodecErr{}, err) {
+		assert.Equal(t, "Values", err.(*common.CodecErr).Field)
+	}
+
+	type badTag struct {
+		Name string `gochat:"maxlen=many"`
+	}
+
+	_, err = common.DefaultCodec.Encode(make([]byte, 100), badTag{})
+	assert.IsType(t, &common.CodecErr{}, err)
+}
+
+func TestEncodePacketUsesCodec(t *testing.T) {
+	buffer := make([]byte, 100)
+	n, err := common.EncodePacket(buffer, newCodecTestPacket(), nil)
+	assert.NoError(t, err)
+
+	var decoded codecTestPacket
+	err = common.DecodePacket(&decoded, buffer[:n], nil)
+	assert.NoError(t, err)
+	assert.Equal(t, *newCodecTestPacket(), decoded)
+}
+
+func FuzzCodecDecode(f *testing.F) {
+	buffer := make([]byte, 100)
+	n, err := common.DefaultCodec.Encode(buffer, newCodecTestPacket())
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(buffer[:n])
+	f.Add([]byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var decoded codecTestPacket
+		if err := common.DefaultCodec.Decode(data, &decoded); err != nil {
+			return
+		}
+
+		// Anything that decodes must encode back to the same bytes
+		buffer := make([]byte, len(data))
+		n, err := common.DefaultCodec.Encode(buffer, &decoded)
+		if err != nil {
+			t.Fatalf("decoded value does not encode: %v", err)
+		}
+
+		if string(buffer[:n]) != string(data) {
+			t.Fatalf("re-encoding %x gave %x", data, buffer[:n])
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// ControlType identifies the control message carried by a frame with FlagControl set
+type ControlType uint8
+
+const (
+	// ControlGoodbye is sent by a peer that is about to close the connection. Its payload is the close reason.
+	ControlGoodbye ControlType = iota + 1
+	// ControlPing asks the peer to show it is still there by answering with a ControlPong
+	ControlPing
+	// ControlPong answers a ControlPing, echoing its payload
+	ControlPong
+	// ControlAuth carries the Credentials a client presents when it connects
+	ControlAuth
+	// ControlAccept tells a client that the server has admitted it
+	ControlAccept
+	// ControlSerializer carries the serializers a client offers, and the one the server chose in reply
+	ControlSerializer
+	// ControlPacketNames carries the IDs the server assigned to the packet types registered by name
+	ControlPacketNames
+	// ControlCancel tells the peer that the caller gave up on the call with the frame's call ID
+	ControlCancel
+)
+
+// CloseReason describes why a connection was closed
+type CloseReason uint8
+
+const (
+	// CloseNormal means the peer closed the connection on purpose
+	CloseNormal CloseReason = iota
+	// CloseGoingAway means the peer is shutting down
+	CloseGoingAway
+	// CloseProtocolError means the peer received a frame it could not handle
+	CloseProtocolError
+	// CloseTimeout means the peer stopped hearing from the other side in time
+	CloseTimeout
+	// CloseKicked means the server removed the client on purpose
+	CloseKicked
+	// CloseUnauthorized means the server rejected the client while admitting it
+	CloseUnauthorized
+	// CloseVersionMismatch means the peer does not accept the other side's protocol or app version
+	CloseVersionMismatch
+	// CloseSlowConsumer means the server disconnected the client because it fell too far behind reading what it was sent
+	CloseSlowConsumer
+	// CloseBanned means the server refused the client because its address or identity is banned
+	CloseBanned
+	// CloseServerFull means the server turned the client away because it reached one of its connection limits.
+	// Unlike a ban, the limit may not be reached anymore if the client tries again later.
+	CloseServerFull
+	// CloseAbnormal means the connection ended without a goodbye, so the reason is unknown.
+	// It is never sent on the wire.
+	CloseAbnormal
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseNormal:
+		return "normal"
+	case CloseGoingAway:
+		return "going away"
+	case CloseProtocolError:
+		return "protocol error"
+	case CloseTimeout:
+		return "timeout"
+	case CloseKicked:
+		return "kicked"
+	case CloseUnauthorized:
+		return "unauthorized"
+	case CloseVersionMismatch:
+		return "version mismatch"
+	case CloseSlowConsumer:
+		return "slow consumer"
+	case CloseBanned:
+		return "banned"
+	case CloseServerFull:
+		return "server full"
+	case CloseAbnormal:
+		return "abnormal"
+	default:
+		return "unknown"
+	}
+}
+
+// GoodbyeTimeout is how long a peer that sent a goodbye waits
+// for the other side to close the connection before closing it itself
+const GoodbyeTimeout = time.Second
+
+// EncodeGoodbye returns the payload of a goodbye control frame
+func EncodeGoodbye(reason CloseReason, message string) []byte {
+	return append([]byte{byte(reason)}, message...)
+}
+
+// ParseGoodbye decodes the payload of a goodbye control frame
+func ParseGoodbye(payload []byte) (CloseReason, string, error) {
+	if len(payload) < 1 {
+		return 0, "", errors.New("goodbye is missing its close reason")
+	}
+
+	return CloseReason(payload[0]), string(payload[1:]), nil
+}
+
+// CloseWrite closes the write side of the connection if its transport supports it,
+// which lets the peer read everything that was sent before it sees the connection end
+func CloseWrite(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Credentials are what a client presents to prove who it is to a server that requires authentication.
+// They are sent as a control frame before any other packet, so they never collide with a registered packet type.
+type Credentials struct {
+	// Username is the name the client claims to be. It may be empty for authenticators that only check the secret.
+	Username string
+	// Secret is the password, shared secret or token that proves the claim
+	Secret string
+}
+
+// ID returns the control type credentials are sent with
+func (c Credentials) ID() uint16 {
+	return uint16(ControlAuth)
+}
+
+// Write decodes credentials from buffer
+func (c *Credentials) Write(buffer []byte) (int, error) {
+	if len(buffer) < 2 {
+		return 0, errors.New("credentials are missing the username length")
+	}
+
+	usernameLength := int(binary.LittleEndian.Uint16(buffer))
+	if len(buffer) < 2+usernameLength {
+		return 0, errors.New("credentials username is truncated")
+	}
+
+	c.Username = string(buffer[2 : 2+usernameLength])
+	c.Secret = string(buffer[2+usernameLength:])
+	return len(buffer), nil
+}
+
+// Size returns the number of bytes the credentials encode to
+func (c Credentials) Size() int {
+	return 2 + len(c.Username) + len(c.Secret)
+}
+
+// Read encodes the credentials into buffer
+func (c Credentials) Read(buffer []byte) (int, error) {
+	size := c.Size()
+	if len(c.Username) > 0xFFFF || len(buffer) < size {
+		return 0, io.ErrShortBuffer
+	}
+
+	binary.LittleEndian.PutUint16(buffer, uint16(len(c.Username)))
+	copy(buffer[2:], c.Username)
+	copy(buffer[2+len(c.Username):], c.Secret)
+	return size, nil
+}
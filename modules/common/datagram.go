@@ -0,0 +1,526 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DeliveryMode determines how packets of a given type are delivered over a datagram transport
+type DeliveryMode uint8
+
+const (
+	// Unreliable packets are sent once and may be lost, duplicated or arrive out of order
+	Unreliable DeliveryMode = iota
+
+	// ReliableOrdered packets are retransmitted until acknowledged and are
+	// delivered in the order they were sent, relative to other reliable packets
+	ReliableOrdered
+)
+
+// DatagramType identifies the purpose of a datagram sent between a datagram client and server
+type DatagramType uint8
+
+const (
+	// DatagramConnect is sent by a client to open a session. Its token is a random client nonce.
+	DatagramConnect DatagramType = iota + 1
+
+	// DatagramAccept is sent by the server in reply to DatagramConnect. Its token is the new session token,
+	// and its payload holds the client nonce it is answering.
+	DatagramAccept
+
+	// DatagramUnreliable carries a single frame that is not acknowledged
+	DatagramUnreliable
+
+	// DatagramReliable carries a single frame with a sequence number that must be acknowledged
+	DatagramReliable
+
+	// DatagramAck acknowledges the reliable datagram with the given sequence number
+	DatagramAck
+
+	// DatagramClose ends a session
+	DatagramClose
+
+	// DatagramKeepAlive is sent by a side that has had nothing else to send for a while,
+	// so the peer knows the session is still in use
+	DatagramKeepAlive
+)
+
+const (
+	// DatagramHeaderSize is the size of the header shared by every datagram: its type and session token
+	DatagramHeaderSize = 9
+
+	// MaxDatagramSize is the largest datagram a datagram transport sends or accepts
+	MaxDatagramSize = 65507
+
+	datagramSeqSize = 4
+
+	retransmitInterval = 100 * time.Millisecond
+	maxRetransmits     = 50
+
+	// keepAliveInterval is how long a side goes without sending anything before it sends a DatagramKeepAlive
+	keepAliveInterval = 5 * time.Second
+
+	// maxOutOfOrder is how far ahead of the next expected sequence number a reliable datagram
+	// may be before it is dropped, which bounds the memory used to reorder datagrams
+	maxOutOfOrder = 1024
+
+	// maxPending is how many reliable datagrams may wait to be acknowledged before writing another one fails
+	maxPending = 1024
+
+	// maxDelivered is how many received frames may wait to be read before more are dropped. Reliable
+	// datagrams are left unacknowledged instead, so the peer sends them again once there is room.
+	maxDelivered = 1024
+)
+
+// Datagram is a single parsed datagram
+type Datagram struct {
+	Type    DatagramType
+	Token   uint64
+	Seq     uint32
+	Payload []byte
+}
+
+// AppendDatagram encodes the datagram and appends it to buffer
+func AppendDatagram(buffer []byte, d Datagram) []byte {
+	var header [DatagramHeaderSize + datagramSeqSize]byte
+	header[0] = byte(d.Type)
+	binary.LittleEndian.PutUint64(header[1:], d.Token)
+
+	buffer = append(buffer, header[:DatagramHeaderSize]...)
+	if d.Type == DatagramReliable || d.Type == DatagramAck {
+		binary.LittleEndian.PutUint32(header[DatagramHeaderSize:], d.Seq)
+		buffer = append(buffer, header[DatagramHeaderSize:]...)
+	}
+
+	return append(buffer, d.Payload...)
+}
+
+// ParseDatagram decodes a datagram. The payload of the returned datagram aliases buffer.
+func ParseDatagram(buffer []byte) (Datagram, error) {
+	if len(buffer) < DatagramHeaderSize {
+		return Datagram{}, errors.New("datagram too short")
+	}
+
+	d := Datagram{
+		Type:  DatagramType(buffer[0]),
+		Token: binary.LittleEndian.Uint64(buffer[1:]),
+	}
+	buffer = buffer[DatagramHeaderSize:]
+
+	switch d.Type {
+	case DatagramReliable, DatagramAck:
+		if len(buffer) < datagramSeqSize {
+			return Datagram{}, errors.New("datagram too short")
+		}
+
+		d.Seq = binary.LittleEndian.Uint32(buffer)
+		buffer = buffer[datagramSeqSize:]
+	case DatagramConnect, DatagramAccept, DatagramUnreliable, DatagramClose, DatagramKeepAlive:
+	default:
+		return Datagram{}, errors.New("unknown datagram type")
+	}
+
+	d.Payload = buffer
+	return d, nil
+}
+
+// DeliveryModes maps packet IDs to the delivery mode used to send them.
+// It is safe for concurrent use.
+type DeliveryModes struct {
+	modes map[uint16]DeliveryMode
+	mutex sync.RWMutex
+}
+
+// NewDeliveryModes returns a mapping in which every packet type is unreliable
+func NewDeliveryModes() *DeliveryModes {
+	return &DeliveryModes{modes: make(map[uint16]DeliveryMode)}
+}
+
+// Set sets the delivery mode of the given packet type
+func (m *DeliveryModes) Set(packetID uint16, mode DeliveryMode) {
+	m.mutex.Lock()
+	m.modes[packetID] = mode
+	m.mutex.Unlock()
+}
+
+// Get returns the delivery mode of the given packet type
+func (m *DeliveryModes) Get(packetID uint16) DeliveryMode {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.modes[packetID]
+}
+
+// datagramTimeoutErr is returned by a datagram connection when a read deadline passes
+// or when a reliable datagram is never acknowledged
+type datagramTimeoutErr struct{}
+
+func (e datagramTimeoutErr) Error() string   { return "datagram connection timed out" }
+func (e datagramTimeoutErr) Timeout() bool   { return true }
+func (e datagramTimeoutErr) Temporary() bool { return true }
+
+// pendingDatagram is a reliable datagram waiting to be acknowledged
+type pendingDatagram struct {
+	datagram []byte
+	sentAt   time.Time
+	retries  int
+}
+
+// DatagramConn is one end of a session over a datagram transport such as UDP, presented as a net.Conn.
+// Every Write must contain exactly one frame or hello, which is sent in a single datagram using the delivery
+// mode of its packet type. Reads return the frames received from the peer. Writing a reliable frame fails with a
+// *SendWindowFullErr while too many reliable datagrams are waiting to be acknowledged, and frames received while
+// too many are waiting to be read are dropped, so a peer that stops acknowledging or reading cannot use up memory.
+type DatagramConn struct {
+	token      uint64
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	send       func(datagram []byte) error
+	modes      *DeliveryModes
+	onClose    func()
+
+	mutex        sync.Mutex
+	notify       chan struct{}
+	delivered    [][]byte
+	readBuffer   []byte
+	readDeadline time.Time
+	closed       bool
+	closeErr     error
+
+	nextSendSeq uint32
+	pending     map[uint32]*pendingDatagram
+	nextRecvSeq uint32
+	outOfOrder  map[uint32][]byte
+
+	// lastReceived and lastSent are when a datagram was last received from and sent to the peer.
+	// The session ends once nothing has been received for idleTimeout, if it is set.
+	lastReceived time.Time
+	lastSent     time.Time
+	idleTimeout  time.Duration
+
+	done chan struct{}
+}
+
+// NewDatagramConn returns a connection for the session with the given token. Datagrams are sent to
+// the peer with send, and datagrams received from the peer must be handed to Deliver.
+// onClose, if not nil, is called once when the session ends.
+func NewDatagramConn(token uint64, localAddr net.Addr, remoteAddr net.Addr, send func(datagram []byte) error, modes *DeliveryModes, onClose func()) *DatagramConn {
+	c := &DatagramConn{
+		token:      token,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+		send:       send,
+		modes:      modes,
+		onClose:    onClose,
+		notify:     make(chan struct{}),
+		pending:    make(map[uint32]*pendingDatagram),
+		outOfOrder: make(map[uint32][]byte),
+		done:       make(chan struct{}),
+	}
+	c.lastReceived = time.Now()
+	c.lastSent = c.lastReceived
+
+	go c.retransmit()
+
+	return c
+}
+
+// Token returns the token identifying this connection's session
+func (c *DatagramConn) Token() uint64 {
+	return c.token
+}
+
+// SetIdleTimeout ends the session with a timeout once nothing has been received from the peer for the given
+// duration. Both sides send a DatagramKeepAlive every 5 seconds they have nothing else to send, so the timeout
+// should be several times longer than that. A duration of 0, the default, never ends the session for being idle.
+func (c *DatagramConn) SetIdleTimeout(timeout time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.idleTimeout = timeout
+}
+
+// Deliver handles a datagram received from the peer of this session
+func (c *DatagramConn) Deliver(d Datagram) {
+	c.mutex.Lock()
+	c.lastReceived = time.Now()
+	c.mutex.Unlock()
+
+	switch d.Type {
+	case DatagramUnreliable:
+		c.mutex.Lock()
+		if len(c.delivered) < maxDelivered {
+			c.deliverLocked(append([]byte{}, d.Payload...))
+		}
+		c.mutex.Unlock()
+	case DatagramReliable:
+		c.mutex.Lock()
+		// Sequence numbers are compared using serial number arithmetic so they may wrap around
+		ahead := int32(d.Seq - c.nextRecvSeq)
+		if ahead >= maxOutOfOrder || ahead >= 0 && len(c.delivered) >= maxDelivered {
+			// Leave it unacknowledged, so the peer sends it again once the reader has caught up
+			c.mutex.Unlock()
+			return
+		}
+
+		// Datagrams that were already delivered are only acknowledged again
+		if ahead >= 0 {
+			c.outOfOrder[d.Seq] = append([]byte{}, d.Payload...)
+			for {
+				payload, ok := c.outOfOrder[c.nextRecvSeq]
+				if !ok {
+					break
+				}
+
+				delete(c.outOfOrder, c.nextRecvSeq)
+				c.nextRecvSeq++
+				c.deliverLocked(payload)
+			}
+		}
+		c.mutex.Unlock()
+
+		c.sendDatagram(Datagram{Type: DatagramAck, Token: c.token, Seq: d.Seq})
+	case DatagramAck:
+		c.mutex.Lock()
+		delete(c.pending, d.Seq)
+		c.mutex.Unlock()
+	case DatagramClose:
+		c.closeWithErr(io.EOF, false)
+	}
+}
+
+func (c *DatagramConn) deliverLocked(frame []byte) {
+	if c.closed {
+		return
+	}
+
+	c.delivered = append(c.delivered, frame)
+	c.signalLocked()
+}
+
+// signalLocked wakes up any goroutine waiting in Read
+func (c *DatagramConn) signalLocked() {
+	close(c.notify)
+	c.notify = make(chan struct{})
+}
+
+func (c *DatagramConn) Read(b []byte) (int, error) {
+	for {
+		c.mutex.Lock()
+		if len(c.readBuffer) == 0 && len(c.delivered) > 0 {
+			c.readBuffer = c.delivered[0]
+			c.delivered = c.delivered[1:]
+		}
+
+		if len(c.readBuffer) > 0 {
+			n := copy(b, c.readBuffer)
+			c.readBuffer = c.readBuffer[n:]
+			c.mutex.Unlock()
+			return n, nil
+		}
+
+		if c.closed {
+			err := c.closeErr
+			c.mutex.Unlock()
+			return 0, err
+		}
+
+		deadline := c.readDeadline
+		notify := c.notify
+		c.mutex.Unlock()
+
+		if deadline.IsZero() {
+			<-notify
+			continue
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, &datagramTimeoutErr{}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-notify:
+			timer.Stop()
+		case <-timer.C:
+			return 0, &datagramTimeoutErr{}
+		}
+	}
+}
+
+func (c *DatagramConn) Write(b []byte) (int, error) {
+	if len(b) < FrameHeaderSize {
+		return 0, errors.New("datagram connection writes must contain a whole frame or hello")
+	}
+
+	if len(b)+DatagramHeaderSize+datagramSeqSize > MaxDatagramSize {
+		return 0, &FrameTooLargeErr{Size: len(b), MaxSize: MaxDatagramSize - DatagramHeaderSize - datagramSeqSize}
+	}
+
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return 0, errors.New("use of closed datagram connection")
+	}
+
+	d := Datagram{Type: DatagramUnreliable, Token: c.token, Payload: b}
+
+	// Hellos and control frames such as goodbyes must not be lost, and neither must calls and their
+	// responses, which the caller would otherwise wait on until it gives up, so they are always reliable
+	header := ParseFrameHeader(b)
+	if isHello(b) || header.Flags&(FlagControl|FlagRequest|FlagResponse) != 0 || c.modes != nil && c.modes.Get(header.PacketID) == ReliableOrdered {
+		if len(c.pending) >= maxPending {
+			c.mutex.Unlock()
+			return 0, &SendWindowFullErr{Pending: len(c.pending)}
+		}
+
+		d.Type = DatagramReliable
+		d.Seq = c.nextSendSeq
+		c.nextSendSeq++
+	}
+
+	datagram := AppendDatagram(nil, d)
+	c.lastSent = time.Now()
+	if d.Type == DatagramReliable {
+		c.pending[d.Seq] = &pendingDatagram{datagram: datagram, sentAt: c.lastSent}
+	}
+	c.mutex.Unlock()
+
+	if err := c.send(datagram); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *DatagramConn) sendDatagram(d Datagram) error {
+	c.mutex.Lock()
+	c.lastSent = time.Now()
+	c.mutex.Unlock()
+
+	return c.send(AppendDatagram(nil, d))
+}
+
+// retransmit resends reliable datagrams that have not been acknowledged in time, and times the connection out
+// if one of them is never acknowledged or the peer has been idle for too long. It also keeps the session alive
+// while there is nothing else to send.
+func (c *DatagramConn) retransmit() {
+	ticker := time.NewTicker(retransmitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			var resend [][]byte
+			timedOut := false
+
+			c.mutex.Lock()
+			for _, p := range c.pending {
+				if now.Sub(p.sentAt) < retransmitInterval {
+					continue
+				}
+
+				if p.retries >= maxRetransmits {
+					timedOut = true
+					break
+				}
+
+				p.retries++
+				p.sentAt = now
+				resend = append(resend, p.datagram)
+			}
+
+			idle := c.idleTimeout > 0 && now.Sub(c.lastReceived) >= c.idleTimeout
+			keepAlive := len(resend) == 0 && now.Sub(c.lastSent) >= keepAliveInterval
+			if len(resend) > 0 {
+				c.lastSent = now
+			}
+			c.mutex.Unlock()
+
+			if timedOut {
+				c.closeWithErr(&datagramTimeoutErr{}, false)
+				return
+			}
+
+			// The peer may have gone away without saying so, but it is told in case it is still there
+			if idle {
+				c.closeWithErr(&datagramTimeoutErr{}, true)
+				return
+			}
+
+			for _, datagram := range resend {
+				c.send(datagram)
+			}
+
+			if keepAlive {
+				c.sendDatagram(Datagram{Type: DatagramKeepAlive, Token: c.token})
+			}
+		}
+	}
+}
+
+// closeWithErr ends the session, making Read return err once all delivered frames have been read.
+// If notifyPeer is set, the peer is told the session has ended.
+func (c *DatagramConn) closeWithErr(err error, notifyPeer bool) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return
+	}
+
+	c.closed = true
+	c.closeErr = err
+	c.signalLocked()
+	close(c.done)
+	c.mutex.Unlock()
+
+	if notifyPeer {
+		c.sendDatagram(Datagram{Type: DatagramClose, Token: c.token})
+	}
+
+	if c.onClose != nil {
+		c.onClose()
+	}
+}
+
+// Close ends the session and tells the peer it has ended
+func (c *DatagramConn) Close() error {
+	c.closeWithErr(errors.New("use of closed datagram connection"), true)
+	return nil
+}
+
+func (c *DatagramConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *DatagramConn) RemoteAddr() net.Addr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.remoteAddr
+}
+
+// SetDeadline sets the read deadline. Writes never block, so there is no write deadline.
+func (c *DatagramConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *DatagramConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.signalLocked()
+	c.mutex.Unlock()
+
+	return nil
+}
+
+func (c *DatagramConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
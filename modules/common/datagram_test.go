@@ -0,0 +1,241 @@
+package common_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// newDatagramPair links two datagram connections together. Every datagram sent
+// from a to b for which dropAToB returns true is lost, and datagrams are delivered
+// on their own goroutine in the order they were sent.
+func newDatagramPair(modes *common.DeliveryModes, dropAToB func(d common.Datagram) bool) (*common.DatagramConn, *common.DatagramConn) {
+	var a, b *common.DatagramConn
+	var mutex sync.Mutex
+
+	aToB := make(chan []byte, 1000)
+	bToA := make(chan []byte, 1000)
+
+	a = common.NewDatagramConn(1, common.PipeAddr("a"), common.PipeAddr("b"), func(datagram []byte) error {
+		d, _ := common.ParseDatagram(datagram)
+
+		mutex.Lock()
+		drop := dropAToB(d)
+		mutex.Unlock()
+
+		if !drop {
+			aToB <- datagram
+		}
+		return nil
+	}, modes, nil)
+
+	b = common.NewDatagramConn(1, common.PipeAddr("b"), common.PipeAddr("a"), func(datagram []byte) error {
+		bToA <- datagram
+		return nil
+	}, modes, nil)
+
+	go func() {
+		for datagram := range aToB {
+			d, _ := common.ParseDatagram(datagram)
+			b.Deliver(d)
+		}
+	}()
+
+	go func() {
+		for datagram := range bToA {
+			d, _ := common.ParseDatagram(datagram)
+			a.Deliver(d)
+		}
+	}()
+
+	return a, b
+}
+
+func TestDatagramRoundTrip(t *testing.T) {
+	d := common.Datagram{Type: common.DatagramReliable, Token: 42, Seq: 7, Payload: []byte("test data")}
+
+	parsed, err := common.ParseDatagram(common.AppendDatagram(nil, d))
+	assert.NoError(t, err)
+	assert.Equal(t, d, parsed)
+
+	_, err = common.ParseDatagram([]byte{byte(common.DatagramAck), 0, 0})
+	assert.Error(t, err)
+
+	_, err = common.ParseDatagram(make([]byte, common.DatagramHeaderSize))
+	assert.Error(t, err)
+}
+
+func TestDatagramConnReliableOrderedWithLoss(t *testing.T) {
+	modes := common.NewDeliveryModes()
+	modes.Set(1, common.ReliableOrdered)
+
+	sent := make(map[uint32]int)
+	a, b := newDatagramPair(modes, func(d common.Datagram) bool {
+		// Lose the first transmission of every other reliable datagram
+		if d.Type != common.DatagramReliable {
+			return false
+		}
+
+		sent[d.Seq]++
+		return d.Seq%2 == 0 && sent[d.Seq] == 1
+	})
+	defer a.Close()
+	defer b.Close()
+
+	for i := 0; i < 10; i++ {
+		err := common.WriteFrame(a, 1, []byte{byte(i)})
+		assert.NoError(t, err)
+	}
+
+	r := common.NewFrameReader(b, 10)
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for i := 0; i < 10; i++ {
+		frame, err := r.ReadFrame()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{byte(i)}, frame.Payload)
+	}
+}
+
+func TestDatagramConnUnreliableLoss(t *testing.T) {
+	a, b := newDatagramPair(common.NewDeliveryModes(), func(d common.Datagram) bool {
+		return bytes.HasSuffix(d.Payload, []byte("lost"))
+	})
+	defer a.Close()
+	defer b.Close()
+
+	common.WriteFrame(a, 1, []byte("lost"))
+	common.WriteFrame(a, 1, []byte("kept"))
+
+	r := common.NewFrameReader(b, 10)
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("kept"), frame.Payload)
+}
+
+func TestDatagramConnSendWindowFull(t *testing.T) {
+	modes := common.NewDeliveryModes()
+	modes.Set(1, common.ReliableOrdered)
+
+	// Nothing reaches b, so nothing a sends is ever acknowledged
+	a, b := newDatagramPair(modes, func(d common.Datagram) bool { return true })
+	defer a.Close()
+	defer b.Close()
+
+	for i := 0; i < 1024; i++ {
+		err := common.WriteFrame(a, 1, []byte{byte(i)})
+		assert.NoError(t, err)
+	}
+
+	err := common.WriteFrame(a, 1, []byte("full"))
+	assert.Equal(t, &common.SendWindowFullErr{Pending: 1024}, err)
+
+	// Unreliable frames are not waited on, so they can still be sent
+	err = common.WriteFrame(a, 2, []byte("unreliable"))
+	assert.NoError(t, err)
+}
+
+func TestDatagramConnDeliveredLimit(t *testing.T) {
+	modes := common.NewDeliveryModes()
+	modes.Set(1, common.ReliableOrdered)
+
+	a, b := newDatagramPair(modes, func(d common.Datagram) bool { return false })
+	defer a.Close()
+	defer b.Close()
+
+	// b does not read until it is sent more frames than it keeps
+	for i := 0; i < 1100; i++ {
+		err := common.WriteFrame(a, 2, []byte{byte(i)})
+		assert.NoError(t, err)
+	}
+
+	for i := 0; i < 1024; i++ {
+		err := common.WriteFrame(a, 1, []byte{byte(i)})
+		assert.NoError(t, err)
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	r := common.NewFrameReader(b, 10)
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// The unreliable frames past the limit were dropped
+	for i := 0; i < 1024; i++ {
+		frame, err := r.ReadFrame()
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(2), frame.PacketID)
+		assert.Equal(t, []byte{byte(i)}, frame.Payload)
+	}
+
+	// The reliable frames were not acknowledged while there was no room for them, so they are sent again
+	for i := 0; i < 1024; i++ {
+		frame, err := r.ReadFrame()
+		assert.NoError(t, err)
+		assert.Equal(t, uint16(1), frame.PacketID)
+		assert.Equal(t, []byte{byte(i)}, frame.Payload)
+	}
+}
+
+func TestDatagramConnReadDeadline(t *testing.T) {
+	a, b := newDatagramPair(common.NewDeliveryModes(), func(d common.Datagram) bool { return false })
+	defer a.Close()
+	defer b.Close()
+
+	b.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+
+	buffer := make([]byte, 10)
+	_, err := b.Read(buffer)
+
+	netErr, ok := err.(net.Error)
+	assert.True(t, ok)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestDatagramConnClose(t *testing.T) {
+	a, b := newDatagramPair(common.NewDeliveryModes(), func(d common.Datagram) bool { return false })
+	defer b.Close()
+
+	common.WriteFrame(a, 1, []byte("test"))
+	a.Close()
+
+	b.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := common.NewFrameReader(b, 10)
+
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("test"), frame.Payload)
+
+	_, err = r.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = a.Write([]byte("test data"))
+	assert.Error(t, err)
+}
+
+func TestDatagramConnIdleTimeout(t *testing.T) {
+	// Nothing a sends reaches b, so b hears nothing from it
+	a, b := newDatagramPair(common.NewDeliveryModes(), func(d common.Datagram) bool { return true })
+	defer a.Close()
+
+	b.SetIdleTimeout(time.Millisecond * 200)
+
+	start := time.Now()
+	_, err := b.Read(make([]byte, 10))
+	netErr, ok := err.(net.Error)
+	if assert.True(t, ok) {
+		assert.True(t, netErr.Timeout())
+	}
+	assert.Less(t, int64(time.Since(start)), int64(time.Second*2))
+
+	// The peer is told the session has ended
+	a.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = a.Read(make([]byte, 10))
+	assert.Equal(t, io.EOF, err)
+}
@@ -11,11 +11,20 @@ func (e *InvalidMaxPacketSizeErr) Error() string {
 	return fmt.Sprintf("invalid max packet size of %d", e.Size)
 }
 
-// DisconnectErr represents an error that causes a connection to end
-type DisconnectErr struct{}
+// DisconnectErr represents an error that causes a connection to end.
+// Reason says why the peer closed the connection and Message is
+// the optional explanation it sent along with its goodbye.
+type DisconnectErr struct {
+	Reason  CloseReason
+	Message string
+}
 
 func (e DisconnectErr) Error() string {
-	return "disconnected"
+	if e.Message != "" {
+		return fmt.Sprintf("disconnected (%v): %s", e.Reason, e.Message)
+	}
+
+	return fmt.Sprintf("disconnected (%v)", e.Reason)
 }
 
 // TimeoutErr is returned if a connection times out
@@ -25,9 +34,19 @@ func (e TimeoutErr) Error() string {
 	return "connection timed out"
 }
 
+// ProtocolErr is returned when a peer sends a frame that cannot be handled,
+// such as an unregistered packet or a frame that is too large
+type ProtocolErr struct {
+	Err error
+}
+
+func (e ProtocolErr) Error() string {
+	return fmt.Sprintf("protocol error: %v", e.Err)
+}
+
 // SendErr represents an error sending a packet
 type SendErr struct {
-	PacketID uint8
+	PacketID uint16
 	Err      error
 }
 
@@ -46,7 +65,7 @@ func (e ReceiveErr) Error() string {
 
 // PacketRegisteredErr is returned when a packet is already registered
 type PacketRegisteredErr struct {
-	PacketID uint8
+	PacketID uint16
 }
 
 func (e PacketRegisteredErr) Error() string {
@@ -55,9 +74,75 @@ func (e PacketRegisteredErr) Error() string {
 
 // PacketNotRegisteredErr is returned when a packet is being read that has not been registered
 type PacketNotRegisteredErr struct {
-	PacketID uint8
+	PacketID uint16
 }
 
 func (e PacketNotRegisteredErr) Error() string {
 	return fmt.Sprintf("packet with ID %d has not been registered", e.PacketID)
 }
+
+// PacketNameRegisteredErr is returned when a packet type is registered under a name that is already taken
+type PacketNameRegisteredErr struct {
+	Name string
+}
+
+func (e PacketNameRegisteredErr) Error() string {
+	return fmt.Sprintf("packet named %q already registered", e.Name)
+}
+
+// InvalidPacketIDErr is returned when a packet type is registered with an ID that is reserved.
+// Packets registered by ID must use an ID below FirstNamedPacketID, and packets registered by name must use NamedPacketID.
+type InvalidPacketIDErr struct {
+	PacketID uint16
+}
+
+func (e InvalidPacketIDErr) Error() string {
+	return fmt.Sprintf("packet ID %d is reserved", e.PacketID)
+}
+
+// PacketTypeNotRegisteredErr is returned when a packet identified by name is sent before its type was registered
+type PacketTypeNotRegisteredErr struct {
+	Type string
+}
+
+func (e PacketTypeNotRegisteredErr) Error() string {
+	return fmt.Sprintf("packet type %s has not been registered by name", e.Type)
+}
+
+// UnknownPacketNameErr is returned when a client sends a packet whose name the server did not register
+type UnknownPacketNameErr struct {
+	Name string
+}
+
+func (e UnknownPacketNameErr) Error() string {
+	return fmt.Sprintf("the server does not know packets named %q", e.Name)
+}
+
+// HandlerRegisteredErr is returned when a request handler is set for a packet type that already has one
+type HandlerRegisteredErr struct {
+	PacketID uint16
+}
+
+func (e HandlerRegisteredErr) Error() string {
+	return fmt.Sprintf("a request handler for packets with ID %d is already registered", e.PacketID)
+}
+
+// FrameTooLargeErr is returned when a frame is larger than the max packet size
+type FrameTooLargeErr struct {
+	Size    int
+	MaxSize int
+}
+
+func (e FrameTooLargeErr) Error() string {
+	return fmt.Sprintf("frame of %d bytes exceeds max packet size of %d", e.Size, e.MaxSize)
+}
+
+// SendWindowFullErr is returned when a reliable frame is written to a datagram connection that already has as
+// many reliable datagrams waiting to be acknowledged as it allows. The frame is not sent.
+type SendWindowFullErr struct {
+	Pending int
+}
+
+func (e SendWindowFullErr) Error() string {
+	return fmt.Sprintf("%d reliable datagrams are already waiting to be acknowledged", e.Pending)
+}
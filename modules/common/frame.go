@@ -0,0 +1,149 @@
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// FrameHeaderSize is the size in bytes of the header that precedes every packet on the wire
+const FrameHeaderSize = 11
+
+const (
+	// FlagControl marks a frame that carries a control message handled by the library itself,
+	// such as a goodbye, rather than a registered packet. Its packet ID is a ControlType.
+	FlagControl uint8 = 1 << iota
+	// FlagRequest marks a packet sent by Call. The peer answers it with a frame with FlagResponse and the same call ID.
+	FlagRequest
+	// FlagResponse marks the answer to the request with the same call ID
+	FlagResponse
+	// FlagError marks a response that carries a CallErr instead of a packet
+	FlagError
+)
+
+// FrameHeader describes the packet carried by a frame. On the wire it is laid out as a little endian uint32
+// payload length followed by the flags, a little endian uint16 packet ID and a little endian uint32 call ID.
+type FrameHeader struct {
+	Length   uint32
+	Flags    uint8
+	PacketID uint16
+	// CallID ties a response to its request. It is 0 for frames that are not part of a call.
+	CallID uint32
+}
+
+// Frame is a single packet as read from the network
+type Frame struct {
+	Flags    uint8
+	PacketID uint16
+	CallID   uint32
+	Payload  []byte
+}
+
+// IsControl reports whether the frame carries a control message rather than a registered packet
+func (f Frame) IsControl() bool {
+	return f.Flags&FlagControl != 0
+}
+
+// IsRequest reports whether the frame carries a request the peer expects an answer to
+func (f Frame) IsRequest() bool {
+	return f.Flags&FlagRequest != 0
+}
+
+// IsResponse reports whether the frame answers a request sent by Call
+func (f Frame) IsResponse() bool {
+	return f.Flags&FlagResponse != 0
+}
+
+// PutFrameHeader encodes the given header into the first FrameHeaderSize bytes of buffer
+func PutFrameHeader(buffer []byte, header FrameHeader) {
+	binary.LittleEndian.PutUint32(buffer, header.Length)
+	buffer[4] = header.Flags
+	binary.LittleEndian.PutUint16(buffer[5:], header.PacketID)
+	binary.LittleEndian.PutUint32(buffer[7:], header.CallID)
+}
+
+// ParseFrameHeader decodes a header from the first FrameHeaderSize bytes of buffer
+func ParseFrameHeader(buffer []byte) FrameHeader {
+	return FrameHeader{
+		Length:   binary.LittleEndian.Uint32(buffer),
+		Flags:    buffer[4],
+		PacketID: binary.LittleEndian.Uint16(buffer[5:]),
+		CallID:   binary.LittleEndian.Uint32(buffer[7:]),
+	}
+}
+
+// WriteFrame writes a single frame containing the given payload to w.
+// The header and payload are written with one call to Write so frames
+// sent concurrently on the same connection are never interleaved.
+func WriteFrame(w io.Writer, packetID uint16, payload []byte) error {
+	return writeFrame(w, FrameHeader{PacketID: packetID}, payload)
+}
+
+// WriteControlFrame writes a single frame containing a control message to w
+func WriteControlFrame(w io.Writer, controlType ControlType, payload []byte) error {
+	return writeFrame(w, FrameHeader{Flags: FlagControl, PacketID: uint16(controlType)}, payload)
+}
+
+// writeFrame writes a single frame with the given header, whose length is set to the length of the payload
+func writeFrame(w io.Writer, header FrameHeader, payload []byte) error {
+	header.Length = uint32(len(payload))
+
+	buffer := GetBuffer(FrameHeaderSize + len(payload))
+	defer PutBuffer(buffer)
+
+	PutFrameHeader(*buffer, header)
+	copy((*buffer)[FrameHeaderSize:], payload)
+
+	_, err := w.Write(*buffer)
+	return err
+}
+
+// FrameReader reads length-prefixed frames from a stream. Reads are buffered,
+// so a frame split across several reads is reassembled and several frames
+// arriving in a single read are returned one at a time.
+type FrameReader struct {
+	reader *bufio.Reader
+	header [FrameHeaderSize]byte
+
+	// payload is reused for every frame, and only grows as large as the largest frame read so far
+	payload        []byte
+	maxPayloadSize int
+}
+
+// NewFrameReader returns a frame reader that reads from r and rejects
+// frames with a payload larger than maxPayloadSize
+func NewFrameReader(r io.Reader, maxPayloadSize int) *FrameReader {
+	return &FrameReader{
+		reader:         bufio.NewReader(r),
+		maxPayloadSize: maxPayloadSize,
+	}
+}
+
+// ReadFrame blocks until the next full frame has been read.
+// The returned payload is only valid until the next call to ReadFrame.
+// io.EOF is returned if the stream ends cleanly between two frames.
+func (r *FrameReader) ReadFrame() (Frame, error) {
+	if _, err := io.ReadFull(r.reader, r.header[:]); err != nil {
+		return Frame{}, err
+	}
+
+	header := ParseFrameHeader(r.header[:])
+	if header.Length > uint32(r.maxPayloadSize) {
+		return Frame{}, &FrameTooLargeErr{Size: int(header.Length), MaxSize: r.maxPayloadSize}
+	}
+
+	if cap(r.payload) < int(header.Length) {
+		r.payload = make([]byte, header.Length)
+	}
+
+	payload := r.payload[:header.Length]
+	if _, err := io.ReadFull(r.reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return Frame{}, err
+	}
+
+	return Frame{Flags: header.Flags, PacketID: header.PacketID, CallID: header.CallID, Payload: payload}, nil
+}
@@ -0,0 +1,101 @@
+package common_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFrame(t *testing.T) {
+	var buffer bytes.Buffer
+	err := common.WriteFrame(&buffer, 0x0203, []byte("test data"))
+	assert.NoError(t, err)
+
+	expected := []byte{9, 0, 0, 0, 0, 3, 2, 0, 0, 0, 0}
+	expected = append(expected, []byte("test data")...)
+	assert.Equal(t, expected, buffer.Bytes())
+}
+
+func TestFrameReaderSplitFrame(t *testing.T) {
+	var buffer bytes.Buffer
+	common.WriteFrame(&buffer, 1, []byte("test data"))
+
+	r := common.NewFrameReader(iotest.OneByteReader(&buffer), 10)
+
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(1), frame.PacketID)
+	assert.Equal(t, []byte("test data"), frame.Payload)
+
+	_, err = r.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFrameReaderMergedFrames(t *testing.T) {
+	var buffer bytes.Buffer
+	common.WriteFrame(&buffer, 1, []byte("first"))
+	common.WriteFrame(&buffer, 2, []byte{})
+	common.WriteFrame(&buffer, 3, []byte("third"))
+
+	r := common.NewFrameReader(&buffer, 10)
+
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(1), frame.PacketID)
+	assert.Equal(t, []byte("first"), frame.Payload)
+
+	frame, err = r.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(2), frame.PacketID)
+	assert.Empty(t, frame.Payload)
+
+	frame, err = r.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(3), frame.PacketID)
+	assert.Equal(t, []byte("third"), frame.Payload)
+}
+
+func TestFrameReaderFrameTooLarge(t *testing.T) {
+	var buffer bytes.Buffer
+	common.WriteFrame(&buffer, 1, []byte("test data"))
+
+	r := common.NewFrameReader(&buffer, 5)
+
+	_, err := r.ReadFrame()
+	assert.IsType(t, &common.FrameTooLargeErr{}, err)
+}
+
+func TestFrameReaderTruncatedFrame(t *testing.T) {
+	var buffer bytes.Buffer
+	common.WriteFrame(&buffer, 1, []byte("test data"))
+	buffer.Truncate(buffer.Len() - 1)
+
+	r := common.NewFrameReader(&buffer, 10)
+
+	_, err := r.ReadFrame()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestWriteControlFrame(t *testing.T) {
+	var buffer bytes.Buffer
+	err := common.WriteControlFrame(&buffer, common.ControlGoodbye, common.EncodeGoodbye(common.CloseKicked, "bye"))
+	assert.NoError(t, err)
+
+	r := common.NewFrameReader(&buffer, 10)
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsControl())
+	assert.Equal(t, common.ControlGoodbye, common.ControlType(frame.PacketID))
+
+	reason, message, err := common.ParseGoodbye(frame.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, common.CloseKicked, reason)
+	assert.Equal(t, "bye", message)
+
+	_, _, err = common.ParseGoodbye(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,214 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ProtocolVersion is the version of the wire protocol spoken by this library. It changes
+// whenever the format of frames or control messages changes in a way older peers cannot read.
+const ProtocolVersion uint16 = 1
+
+// MinProtocolVersion is the oldest protocol version this library can still talk to
+const MinProtocolVersion uint16 = 1
+
+// helloMagic starts every hello, so a peer that is not speaking this protocol at all is told apart
+// from one speaking a different version of it
+var helloMagic = [4]byte{'G', 'C', 'H', 'T'}
+
+// errNotHello is returned when the peer's first bytes are not a hello
+var errNotHello = errors.New("peer did not say hello, so it is not speaking this protocol")
+
+// helloSize is the size of a hello without its app version, which follows it
+const helloSize = len(helloMagic) + 2 + 4 + 2
+
+// Feature is a bit in the feature bitmap a peer sends in its hello
+type Feature uint32
+
+const (
+	// FeatureSerializers means the peer negotiates serializers while connecting
+	FeatureSerializers Feature = 1 << iota
+	// FeatureAuth means the client presents credentials, or the server requires them
+	FeatureAuth
+	// FeatureHeartbeat means the server pings its clients
+	FeatureHeartbeat
+	// FeatureNamedPackets means the server follows its hello with the IDs of the packet types it registered by name
+	FeatureNamedPackets
+)
+
+// Hello is what a client and server tell each other about themselves before anything else. The client
+// sends its hello as soon as it connects and the server answers with its own once it accepts the client's.
+type Hello struct {
+	// Version is the protocol version the peer speaks
+	Version uint16
+	// Features are the optional parts of the protocol the peer uses
+	Features Feature
+	// AppVersion is the version of the application built on top of the library, which the library does not interpret
+	AppVersion string
+}
+
+// Has reports whether the peer uses the given feature
+func (h Hello) Has(feature Feature) bool {
+	return h.Features&feature != 0
+}
+
+// EncodeHello returns the encoding of a hello, which each side writes before its first frame. It is laid out as
+// the magic, a little endian uint16 protocol version, a little endian uint32 feature bitmap and the app version
+// prefixed by its little endian uint16 length. The layout never changes, so peers speaking any protocol version
+// can read each other's hellos. App versions longer than 65535 bytes are cut short.
+func EncodeHello(h Hello) []byte {
+	appVersion := h.AppVersion
+	if len(appVersion) > math.MaxUint16 {
+		appVersion = appVersion[:math.MaxUint16]
+	}
+
+	hello := make([]byte, helloSize, helloSize+len(appVersion))
+	copy(hello, helloMagic[:])
+	binary.LittleEndian.PutUint16(hello[4:], h.Version)
+	binary.LittleEndian.PutUint32(hello[6:], uint32(h.Features))
+	binary.LittleEndian.PutUint16(hello[10:], uint16(len(appVersion)))
+	return append(hello, appVersion...)
+}
+
+// ParseHello decodes a hello encoded by EncodeHello
+func ParseHello(hello []byte) (Hello, error) {
+	if len(hello) < helloSize || !isHello(hello) {
+		return Hello{}, errNotHello
+	}
+
+	length := int(binary.LittleEndian.Uint16(hello[10:]))
+	if len(hello) != helloSize+length {
+		return Hello{}, fmt.Errorf("hello should be %d bytes long, got %d", helloSize+length, len(hello))
+	}
+
+	return Hello{
+		Version:    binary.LittleEndian.Uint16(hello[4:]),
+		Features:   Feature(binary.LittleEndian.Uint32(hello[6:])),
+		AppVersion: string(hello[helloSize:]),
+	}, nil
+}
+
+// isHello reports whether b starts with a hello rather than a frame. No frame is long
+// enough for its length to be read as the magic, so the two cannot be mistaken.
+func isHello(b []byte) bool {
+	return len(b) >= len(helloMagic) && string(b[:len(helloMagic)]) == string(helloMagic[:])
+}
+
+// WriteHello writes the given hello to w with a single call to Write
+func WriteHello(w io.Writer, h Hello) error {
+	_, err := w.Write(EncodeHello(h))
+	return err
+}
+
+// ReadHello reads a hello from r without reading past its end
+func ReadHello(r io.Reader) (Hello, error) {
+	hello := make([]byte, helloSize)
+	if _, err := io.ReadFull(r, hello); err != nil {
+		return Hello{}, err
+	}
+
+	if !isHello(hello) {
+		return Hello{}, errNotHello
+	}
+
+	hello = append(hello, make([]byte, binary.LittleEndian.Uint16(hello[10:]))...)
+	if _, err := io.ReadFull(r, hello[helloSize:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return Hello{}, err
+	}
+
+	return ParseHello(hello)
+}
+
+// PeekHello reports whether the peer's hello is the next thing to be read, without reading anything.
+// A peer that turns this side away before saying hello sends a goodbye frame instead.
+func (r *FrameReader) PeekHello() (bool, error) {
+	magic, err := r.reader.Peek(len(helloMagic))
+	if err != nil {
+		return false, err
+	}
+
+	return isHello(magic), nil
+}
+
+// ReadHello reads the hello the peer sends before its first frame
+func (r *FrameReader) ReadHello() (Hello, error) {
+	isHello, err := r.PeekHello()
+	if err != nil {
+		return Hello{}, err
+	}
+
+	if !isHello {
+		return Hello{}, errNotHello
+	}
+
+	fixed, err := r.reader.Peek(helloSize)
+	if err != nil {
+		return Hello{}, err
+	}
+
+	if length := int(binary.LittleEndian.Uint16(fixed[10:])); length > r.maxPayloadSize {
+		return Hello{}, &FrameTooLargeErr{Size: length, MaxSize: r.maxPayloadSize}
+	}
+
+	return ReadHello(r.reader)
+}
+
+// VersionCheck decides whether the other side's hello is compatible with this side.
+// Returning an error turns the peer away, and the error's message is sent to it as the reason.
+type VersionCheck func(remote Hello) error
+
+// AcceptProtocolVersions returns a version check that accepts peers speaking a protocol version from min to max
+func AcceptProtocolVersions(min uint16, max uint16) VersionCheck {
+	return func(remote Hello) error {
+		if remote.Version < min || remote.Version > max {
+			return &VersionMismatchErr{
+				Remote:  remote,
+				Message: fmt.Sprintf("protocol version %d is not supported, expected %d to %d", remote.Version, min, max),
+			}
+		}
+
+		return nil
+	}
+}
+
+// DefaultVersionCheck accepts any peer speaking a protocol version this library can talk to
+var DefaultVersionCheck = AcceptProtocolVersions(MinProtocolVersion, ProtocolVersion)
+
+// VersionMismatchErr is returned when the client and server do not accept each other's versions
+type VersionMismatchErr struct {
+	// Remote is the hello the other side sent. It is the zero Hello if the other
+	// side turned this one away before sending its own.
+	Remote Hello
+	// Message says why the versions do not match
+	Message string
+}
+
+func (e VersionMismatchErr) Error() string {
+	return fmt.Sprintf("version mismatch: %s", e.Message)
+}
+
+// CheckVersion runs the version check against the other side's hello, using the DefaultVersionCheck if check
+// is nil. Errors returned by the check that are not a *VersionMismatchErr are wrapped in one.
+func CheckVersion(check VersionCheck, remote Hello) error {
+	if check == nil {
+		check = DefaultVersionCheck
+	}
+
+	err := check(remote)
+	if err == nil {
+		return nil
+	}
+
+	if mismatch, ok := err.(*VersionMismatchErr); ok {
+		return mismatch
+	}
+
+	return &VersionMismatchErr{Remote: remote, Message: err.Error()}
+}
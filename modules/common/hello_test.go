@@ -0,0 +1,80 @@
+package common_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelloRoundTrip(t *testing.T) {
+	hello := common.Hello{Version: common.ProtocolVersion, Features: common.FeatureSerializers | common.FeatureAuth, AppVersion: "1.2.3"}
+
+	parsed, err := common.ParseHello(common.EncodeHello(hello))
+	assert.NoError(t, err)
+	assert.Equal(t, hello, parsed)
+	assert.True(t, parsed.Has(common.FeatureAuth))
+	assert.False(t, parsed.Has(common.FeatureHeartbeat))
+
+	_, err = common.ParseHello([]byte("GET / HTTP/1.1"))
+	assert.Error(t, err)
+}
+
+func TestFrameReaderReadHello(t *testing.T) {
+	hello := common.Hello{Version: common.ProtocolVersion, Features: common.FeatureHeartbeat, AppVersion: "1.2.3"}
+
+	var buffer bytes.Buffer
+	common.WriteHello(&buffer, hello)
+	common.WriteFrame(&buffer, 1, []byte("hi"))
+
+	r := common.NewFrameReader(&buffer, 10)
+
+	isHello, err := r.PeekHello()
+	assert.NoError(t, err)
+	assert.True(t, isHello)
+
+	read, err := r.ReadHello()
+	assert.NoError(t, err)
+	assert.Equal(t, hello, read)
+
+	// The frame after the hello is left to be read
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hi"), frame.Payload)
+
+	// A goodbye sent instead of a hello is not mistaken for one
+	buffer.Reset()
+	common.WriteControlFrame(&buffer, common.ControlGoodbye, common.EncodeGoodbye(common.CloseServerFull, ""))
+
+	isHello, err = common.NewFrameReader(&buffer, 10).PeekHello()
+	assert.NoError(t, err)
+	assert.False(t, isHello)
+
+	// Anything else is not speaking the protocol at all
+	_, err = common.NewFrameReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")), 100).ReadHello()
+	assert.Error(t, err)
+}
+
+func TestCheckVersion(t *testing.T) {
+	err := common.CheckVersion(nil, common.Hello{Version: common.ProtocolVersion})
+	assert.NoError(t, err)
+
+	err = common.CheckVersion(nil, common.Hello{Version: common.ProtocolVersion + 1})
+	assert.IsType(t, &common.VersionMismatchErr{}, err)
+
+	check := func(remote common.Hello) error {
+		if remote.AppVersion != "2.0" {
+			return errors.New("app version 2.0 is required")
+		}
+
+		return nil
+	}
+
+	err = common.CheckVersion(check, common.Hello{Version: common.ProtocolVersion, AppVersion: "1.0"})
+	assert.Equal(t, &common.VersionMismatchErr{
+		Remote:  common.Hello{Version: common.ProtocolVersion, AppVersion: "1.0"},
+		Message: "app version 2.0 is required",
+	}, err)
+}
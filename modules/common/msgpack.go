@@ -0,0 +1,659 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// MessagePackSerializer encodes packets as MessagePack (https://msgpack.org), which has libraries in most languages.
+// Structs are encoded as maps keyed by field name, which can be changed with a `msgpack:"name"` tag or skipped with
+// `msgpack:"-"`. Keys without a matching field are ignored when decoding, so peers can add fields independently.
+type MessagePackSerializer struct{}
+
+// Name returns "msgpack"
+func (MessagePackSerializer) Name() string {
+	return "msgpack"
+}
+
+func (MessagePackSerializer) Encode(buffer []byte, v interface{}) (int, error) {
+	e := msgpackEncoder{buffer: buffer[:0]}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return 0, err
+	}
+
+	if len(e.buffer) > len(buffer) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return len(e.buffer), nil
+}
+
+func (MessagePackSerializer) Decode(data []byte, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errMsgpackNotPointer
+	}
+
+	d := msgpackDecoder{data: data}
+	if err := d.decode(value.Elem()); err != nil {
+		return err
+	}
+
+	if len(d.data) > 0 {
+		return errMsgpackTrailingData
+	}
+
+	return nil
+}
+
+// MessagePack format bytes
+const (
+	msgpackNil     = 0xc0
+	msgpackFalse   = 0xc2
+	msgpackTrue    = 0xc3
+	msgpackBin8    = 0xc4
+	msgpackBin16   = 0xc5
+	msgpackBin32   = 0xc6
+	msgpackFloat32 = 0xca
+	msgpackFloat64 = 0xcb
+	msgpackUint8   = 0xcc
+	msgpackUint16  = 0xcd
+	msgpackUint32  = 0xce
+	msgpackUint64  = 0xcf
+	msgpackInt8    = 0xd0
+	msgpackInt16   = 0xd1
+	msgpackInt32   = 0xd2
+	msgpackInt64   = 0xd3
+	msgpackStr8    = 0xd9
+	msgpackStr16   = 0xda
+	msgpackStr32   = 0xdb
+	msgpackArray16 = 0xdc
+	msgpackArray32 = 0xdd
+	msgpackMap16   = 0xde
+	msgpackMap32   = 0xdf
+
+	msgpackFixMap   = 0x80
+	msgpackFixArray = 0x90
+	msgpackFixStr   = 0xa0
+)
+
+var (
+	errMsgpackTruncated    = errors.New("msgpack: data is truncated")
+	errMsgpackTrailingData = errors.New("msgpack: data continues past the end of the value")
+	errMsgpackNotPointer   = errors.New("msgpack: can only decode into a non-nil pointer")
+	errMsgpackTooLong      = errors.New("msgpack: length is larger than the remaining data")
+)
+
+// msgpackField is an encoded struct field and the key it is encoded under
+type msgpackField struct {
+	key   string
+	index int
+}
+
+var msgpackFields sync.Map
+
+func msgpackFieldsOf(t reflect.Type) []msgpackField {
+	if fields, ok := msgpackFields.Load(t); ok {
+		return fields.([]msgpackField)
+	}
+
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		key := f.Tag.Get("msgpack")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = f.Name
+		}
+
+		fields = append(fields, msgpackField{key: key, index: i})
+	}
+
+	msgpackFields.Store(t, fields)
+	return fields
+}
+
+type msgpackEncoder struct {
+	buffer []byte
+}
+
+func (e *msgpackEncoder) put(format byte, x uint64, size int) {
+	e.buffer = append(e.buffer, format)
+	for i := size - 1; i >= 0; i-- {
+		e.buffer = append(e.buffer, byte(x>>(8*uint(i))))
+	}
+}
+
+// header writes the format of a string, binary, array or map of length n,
+// using its fix format if it has one and n fits
+func (e *msgpackEncoder) header(n int, fix byte, fixMax int, format8 byte, format16 byte, format32 byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.buffer = append(e.buffer, fix|byte(n))
+	case format8 != 0 && n <= math.MaxUint8:
+		e.put(format8, uint64(n), 1)
+	case n <= math.MaxUint16:
+		e.put(format16, uint64(n), 2)
+	default:
+		e.put(format32, uint64(n), 4)
+	}
+}
+
+func (e *msgpackEncoder) uint(x uint64) {
+	switch {
+	case x <= 0x7f:
+		e.buffer = append(e.buffer, byte(x))
+	case x <= math.MaxUint8:
+		e.put(msgpackUint8, x, 1)
+	case x <= math.MaxUint16:
+		e.put(msgpackUint16, x, 2)
+	case x <= math.MaxUint32:
+		e.put(msgpackUint32, x, 4)
+	default:
+		e.put(msgpackUint64, x, 8)
+	}
+}
+
+func (e *msgpackEncoder) int(x int64) {
+	switch {
+	case x >= 0:
+		e.uint(uint64(x))
+	case x >= -32:
+		e.buffer = append(e.buffer, byte(x))
+	case x >= math.MinInt8:
+		e.put(msgpackInt8, uint64(x), 1)
+	case x >= math.MinInt16:
+		e.put(msgpackInt16, uint64(x), 2)
+	case x >= math.MinInt32:
+		e.put(msgpackInt32, uint64(x), 4)
+	default:
+		e.put(msgpackInt64, uint64(x), 8)
+	}
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Invalid:
+		e.buffer = append(e.buffer, msgpackNil)
+	case reflect.Bool:
+		if v.Bool() {
+			e.buffer = append(e.buffer, msgpackTrue)
+		} else {
+			e.buffer = append(e.buffer, msgpackFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32:
+		e.put(msgpackFloat32, uint64(math.Float32bits(float32(v.Float()))), 4)
+	case reflect.Float64:
+		e.put(msgpackFloat64, math.Float64bits(v.Float()), 8)
+	case reflect.String:
+		e.header(v.Len(), msgpackFixStr, 31, msgpackStr8, msgpackStr16, msgpackStr32)
+		e.buffer = append(e.buffer, v.String()...)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.buffer = append(e.buffer, msgpackNil)
+			return nil
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.header(v.Len(), 0, 0, msgpackBin8, msgpackBin16, msgpackBin32)
+			for i := 0; i < v.Len(); i++ {
+				e.buffer = append(e.buffer, byte(v.Index(i).Uint()))
+			}
+			return nil
+		}
+
+		e.header(v.Len(), msgpackFixArray, 15, 0, msgpackArray16, msgpackArray32)
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.buffer = append(e.buffer, msgpackNil)
+			return nil
+		}
+
+		e.header(v.Len(), msgpackFixMap, 15, 0, msgpackMap16, msgpackMap32)
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := msgpackFieldsOf(v.Type())
+		e.header(len(fields), msgpackFixMap, 15, 0, msgpackMap16, msgpackMap32)
+		for _, f := range fields {
+			e.header(len(f.key), msgpackFixStr, 31, msgpackStr8, msgpackStr16, msgpackStr32)
+			e.buffer = append(e.buffer, f.key...)
+			if err := e.encode(v.Field(f.index)); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buffer = append(e.buffer, msgpackNil)
+			return nil
+		}
+
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("msgpack: unsupported type %v", v.Type())
+	}
+
+	return nil
+}
+
+type msgpackDecoder struct {
+	data []byte
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n > len(d.data) {
+		return nil, errMsgpackTruncated
+	}
+
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// uint reads a big endian unsigned integer of the given size in bytes
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var x uint64
+	for _, c := range b {
+		x = x<<8 | uint64(c)
+	}
+
+	return x, nil
+}
+
+// length reads a length of the given size in bytes. Every element takes at least one byte,
+// so lengths longer than the remaining data are rejected before anything is allocated for them.
+func (d *msgpackDecoder) length(size int) (int, error) {
+	n, err := d.uint(size)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > uint64(len(d.data)) {
+		return 0, errMsgpackTooLong
+	}
+
+	return int(n), nil
+}
+
+// value is a decoded scalar, or the header of a string, binary, array or map
+type msgpackValue struct {
+	kind   reflect.Kind
+	bits   uint64
+	length int
+	bytes  bool
+}
+
+// readValue reads the next format byte along with any scalar it carries or the length it announces.
+// Strings and binary data are both reported as reflect.String, with bytes set for binary.
+func (d *msgpackDecoder) readValue() (msgpackValue, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return msgpackValue{}, err
+	}
+
+	format := b[0]
+	switch {
+	case format <= 0x7f:
+		return msgpackValue{kind: reflect.Uint64, bits: uint64(format)}, nil
+	case format >= 0xe0:
+		return msgpackValue{kind: reflect.Int64, bits: uint64(int64(int8(format)))}, nil
+	case format&0xf0 == msgpackFixMap:
+		return msgpackValue{kind: reflect.Map, length: int(format & 0x0f)}, d.checkLength(int(format & 0x0f))
+	case format&0xf0 == msgpackFixArray:
+		return msgpackValue{kind: reflect.Slice, length: int(format & 0x0f)}, d.checkLength(int(format & 0x0f))
+	case format&0xe0 == msgpackFixStr:
+		return msgpackValue{kind: reflect.String, length: int(format & 0x1f)}, d.checkLength(int(format & 0x1f))
+	}
+
+	value := msgpackValue{}
+	switch format {
+	case msgpackNil:
+		value.kind = reflect.Invalid
+	case msgpackFalse, msgpackTrue:
+		value.kind = reflect.Bool
+		value.bits = uint64(format - msgpackFalse)
+	case msgpackUint8, msgpackUint16, msgpackUint32, msgpackUint64:
+		value.kind = reflect.Uint64
+		value.bits, err = d.uint(1 << (format - msgpackUint8))
+	case msgpackInt8, msgpackInt16, msgpackInt32, msgpackInt64:
+		size := 1 << (format - msgpackInt8)
+		value.kind = reflect.Int64
+		value.bits, err = d.uint(size)
+		// Sign extend the value to 64 bits
+		shift := uint(64 - 8*size)
+		value.bits = uint64(int64(value.bits<<shift) >> shift)
+	case msgpackFloat32:
+		value.kind = reflect.Float32
+		value.bits, err = d.uint(4)
+	case msgpackFloat64:
+		value.kind = reflect.Float64
+		value.bits, err = d.uint(8)
+	case msgpackStr8, msgpackStr16, msgpackStr32:
+		value.kind = reflect.String
+		value.length, err = d.length(1 << (format - msgpackStr8))
+	case msgpackBin8, msgpackBin16, msgpackBin32:
+		value.kind = reflect.String
+		value.bytes = true
+		value.length, err = d.length(1 << (format - msgpackBin8))
+	case msgpackArray16, msgpackArray32:
+		value.kind = reflect.Slice
+		value.length, err = d.length(2 << (format - msgpackArray16))
+	case msgpackMap16, msgpackMap32:
+		value.kind = reflect.Map
+		value.length, err = d.length(2 << (format - msgpackMap16))
+	default:
+		return msgpackValue{}, fmt.Errorf("msgpack: unsupported format 0x%x", format)
+	}
+
+	return value, err
+}
+
+func (d *msgpackDecoder) checkLength(n int) error {
+	if n > len(d.data) {
+		return errMsgpackTooLong
+	}
+
+	return nil
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	value, err := d.readValue()
+	if err != nil {
+		return err
+	}
+
+	return d.decodeValue(value, v)
+}
+
+func (d *msgpackDecoder) decodeValue(value msgpackValue, v reflect.Value) error {
+	if value.kind == reflect.Invalid {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		return d.decodeValue(value, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("msgpack: cannot decode into %v", v.Type())
+		}
+
+		x, err := d.decodeAny(value)
+		if err != nil {
+			return err
+		}
+
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	case reflect.Bool:
+		if value.kind != reflect.Bool {
+			return mismatch(value, v)
+		}
+		v.SetBool(value.bits == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x := int64(value.bits)
+		if (value.kind != reflect.Int64 && value.kind != reflect.Uint64) || (value.kind == reflect.Uint64 && x < 0) || v.OverflowInt(x) {
+			return mismatch(value, v)
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if (value.kind != reflect.Int64 && value.kind != reflect.Uint64) || (value.kind == reflect.Int64 && int64(value.bits) < 0) || v.OverflowUint(value.bits) {
+			return mismatch(value, v)
+		}
+		v.SetUint(value.bits)
+	case reflect.Float32, reflect.Float64:
+		switch value.kind {
+		case reflect.Float32:
+			v.SetFloat(float64(math.Float32frombits(uint32(value.bits))))
+		case reflect.Float64:
+			v.SetFloat(math.Float64frombits(value.bits))
+		case reflect.Int64:
+			v.SetFloat(float64(int64(value.bits)))
+		case reflect.Uint64:
+			v.SetFloat(float64(value.bits))
+		default:
+			return mismatch(value, v)
+		}
+	case reflect.String:
+		if value.kind != reflect.String {
+			return mismatch(value, v)
+		}
+
+		b, err := d.next(value.length)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && value.kind == reflect.String {
+			b, err := d.next(value.length)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+
+		if value.kind != reflect.Slice {
+			return mismatch(value, v)
+		}
+
+		slice := reflect.MakeSlice(v.Type(), value.length, value.length)
+		for i := 0; i < value.length; i++ {
+			if err := d.decode(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		if value.length != v.Len() {
+			return fmt.Errorf("msgpack: cannot decode %d elements into %v", value.length, v.Type())
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 && value.kind == reflect.String {
+			b, err := d.next(value.length)
+			if err != nil {
+				return err
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return nil
+		}
+
+		if value.kind != reflect.Slice {
+			return mismatch(value, v)
+		}
+
+		for i := 0; i < value.length; i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if value.kind != reflect.Map {
+			return mismatch(value, v)
+		}
+
+		m := reflect.MakeMapWithSize(v.Type(), value.length)
+		for i := 0; i < value.length; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decode(key); err != nil {
+				return err
+			}
+
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decode(elem); err != nil {
+				return err
+			}
+
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		if value.kind != reflect.Map {
+			return mismatch(value, v)
+		}
+
+		fields := msgpackFieldsOf(v.Type())
+		for i := 0; i < value.length; i++ {
+			var key string
+			if err := d.decode(reflect.ValueOf(&key).Elem()); err != nil {
+				return err
+			}
+
+			field := -1
+			for _, f := range fields {
+				if f.key == key {
+					field = f.index
+					break
+				}
+			}
+
+			if field < 0 {
+				if _, err := d.any(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := d.decode(v.Field(field)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %v", v.Type())
+	}
+
+	return nil
+}
+
+// any decodes the next value without knowing its type
+func (d *msgpackDecoder) any() (interface{}, error) {
+	value, err := d.readValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return d.decodeAny(value)
+}
+
+// decodeAny decodes a value into the Go type closest to its MessagePack type: int64, uint64, float32, float64,
+// string, []byte, []interface{} or map[interface{}]interface{}. Maps keyed only by strings are map[string]interface{}.
+func (d *msgpackDecoder) decodeAny(value msgpackValue) (interface{}, error) {
+	switch value.kind {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Bool:
+		return value.bits == 1, nil
+	case reflect.Int64:
+		return int64(value.bits), nil
+	case reflect.Uint64:
+		return value.bits, nil
+	case reflect.Float32:
+		return math.Float32frombits(uint32(value.bits)), nil
+	case reflect.Float64:
+		return math.Float64frombits(value.bits), nil
+	case reflect.String:
+		b, err := d.next(value.length)
+		if err != nil {
+			return nil, err
+		}
+
+		if value.bytes {
+			return append([]byte{}, b...), nil
+		}
+		return string(b), nil
+	case reflect.Slice:
+		slice := make([]interface{}, value.length)
+		for i := range slice {
+			x, err := d.any()
+			if err != nil {
+				return nil, err
+			}
+			slice[i] = x
+		}
+		return slice, nil
+	default:
+		m := make(map[interface{}]interface{}, value.length)
+		stringKeys := true
+		for i := 0; i < value.length; i++ {
+			key, err := d.any()
+			if err != nil {
+				return nil, err
+			}
+
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				return nil, fmt.Errorf("msgpack: map key of type %T is not comparable", key)
+			}
+			_, isString := key.(string)
+			stringKeys = stringKeys && isString
+
+			elem, err := d.any()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = elem
+		}
+
+		if !stringKeys {
+			return m, nil
+		}
+
+		strings := make(map[string]interface{}, len(m))
+		for key, elem := range m {
+			strings[key.(string)] = elem
+		}
+		return strings, nil
+	}
+}
+
+func mismatch(value msgpackValue, v reflect.Value) error {
+	kind := value.kind.String()
+	switch {
+	case value.bytes:
+		kind = "binary"
+	case value.kind == reflect.Slice:
+		kind = "array"
+	}
+
+	return fmt.Errorf("msgpack: cannot decode %s into %v", kind, v.Type())
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// FirstNamedPacketID is the first packet ID the server assigns to packet types registered by name.
+// Packet types registered by their own ID must use an ID below it.
+const FirstNamedPacketID uint16 = 0x8000
+
+// NamedPacketID is what the ID method of a packet type registered by name returns. Such packets are
+// identified by their name instead, and the client and server agree on the ID it is sent with when connecting,
+// so packet types from different packages never have to coordinate their IDs.
+const NamedPacketID uint16 = 0xffff
+
+// PacketType returns the type a packet is registered as, which is the same whether p is a pointer or not
+func PacketType(p Packet) reflect.Type {
+	t := reflect.TypeOf(p)
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+
+	return t
+}
+
+// packetName is a single entry of the table of named packets the server sends when a client connects
+type packetName struct {
+	Name string
+	ID   uint16
+}
+
+// EncodePacketNames returns the payload of a packet names control frame carrying the given IDs by name
+func EncodePacketNames(ids map[string]uint16) []byte {
+	names := make([]packetName, 0, len(ids))
+	for name, id := range ids {
+		names = append(names, packetName{Name: name, ID: id})
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].ID < names[j].ID })
+
+	e := encoder{}
+	e.encode(reflect.ValueOf(names), -1)
+	return e.buffer
+}
+
+// ParsePacketNames decodes the IDs by name carried by a packet names control frame
+func ParsePacketNames(payload []byte) (map[string]uint16, error) {
+	var names []packetName
+	if err := DefaultCodec.Decode(payload, &names); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]uint16, len(names))
+	for _, n := range names {
+		if n.ID < FirstNamedPacketID || n.ID == NamedPacketID {
+			return nil, fmt.Errorf("packet %q was assigned ID %d, outside of the range for named packets", n.Name, n.ID)
+		}
+
+		ids[n.Name] = n.ID
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,37 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketNamesRoundTrip(t *testing.T) {
+	ids := map[string]uint16{
+		"chat.message": common.FirstNamedPacketID,
+		"plugin.emote": common.FirstNamedPacketID + 1,
+	}
+
+	parsed, err := common.ParsePacketNames(common.EncodePacketNames(ids))
+	assert.NoError(t, err)
+	assert.Equal(t, ids, parsed)
+
+	parsed, err = common.ParsePacketNames(common.EncodePacketNames(nil))
+	assert.NoError(t, err)
+	assert.Empty(t, parsed)
+}
+
+func TestParsePacketNamesRejectsFixedIDs(t *testing.T) {
+	for _, id := range []uint16{0, common.FirstNamedPacketID - 1, common.NamedPacketID} {
+		_, err := common.ParsePacketNames(common.EncodePacketNames(map[string]uint16{"chat.message": id}))
+		assert.Error(t, err, "ID %d", id)
+	}
+
+	_, err := common.ParsePacketNames([]byte{5})
+	assert.IsType(t, &common.CodecErr{}, err)
+}
+
+func TestPacketType(t *testing.T) {
+	assert.Equal(t, common.PacketType(codecTestPacket{}), common.PacketType(&codecTestPacket{}))
+}
@@ -2,11 +2,52 @@ package common
 
 import (
 	"io"
+	"reflect"
 )
 
-// Packet is the interface a client and server use to send packets across the network
+// Packet is the interface a client and server use to send packets across the network.
+// A packet only needs an ID. Unless it is a RawPacket, its exported fields are encoded by the serializer
+// negotiated for the connection, which is the DefaultCodec unless the client and server agree on another.
 type Packet interface {
+	ID() uint16
+}
+
+// RawPacket is a packet that encodes itself. Read encodes the packet into the given
+// buffer and Write decodes the packet from the given buffer.
+type RawPacket interface {
+	Packet
 	io.ReadWriter
+}
+
+// EncodePacket encodes the packet into buffer using the connection's serializer s and returns the number
+// of bytes written. RawPacket and SerializedPacket types ignore s, and a nil s means the DefaultCodec.
+func EncodePacket(buffer []byte, p Packet, s Serializer) (int, error) {
+	if raw, ok := p.(RawPacket); ok {
+		return raw.Read(buffer)
+	}
+
+	return packetSerializer(p, s).Encode(buffer, p)
+}
+
+// DecodePacket decodes the packet from the given payload using the connection's serializer s.
+// Packets that are not a RawPacket must be pointers for their fields to be decoded.
+func DecodePacket(p Packet, payload []byte, s Serializer) error {
+	if raw, ok := p.(RawPacket); ok {
+		_, err := raw.Write(payload)
+		return err
+	}
+
+	return packetSerializer(p, s).Decode(payload, p)
+}
+
+// NewPacket returns a new zero value packet of the same type as p.
+// Registered packet types are used as prototypes, so every received
+// packet is decoded into its own value instead of a shared instance.
+func NewPacket(p Packet) Packet {
+	t := reflect.TypeOf(p)
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface().(Packet)
+	}
 
-	ID() uint8
+	return reflect.Zero(t).Interface().(Packet)
 }
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+var (
+	pipeListeners   = make(map[string]*PipeListener)
+	pipeListenersMu sync.Mutex
+)
+
+// PipeAddr is the address of an in-memory pipe listener
+type PipeAddr string
+
+// Network returns the name of the network, "pipe"
+func (a PipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a PipeAddr) String() string {
+	return string(a)
+}
+
+// PipeListener is a net.Listener that hands out in-memory connections created with net.Pipe.
+// It lets a server and client talk to each other within a single process without opening sockets.
+type PipeListener struct {
+	addr   PipeAddr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// ListenPipe starts listening for in-memory connections under the given name
+func ListenPipe(name string) (*PipeListener, error) {
+	pipeListenersMu.Lock()
+	defer pipeListenersMu.Unlock()
+
+	if _, ok := pipeListeners[name]; ok {
+		return nil, errors.New("pipe address already in use")
+	}
+
+	l := &PipeListener{
+		addr:   PipeAddr(name),
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	pipeListeners[name] = l
+
+	return l, nil
+}
+
+// DialPipe connects to the pipe listener with the given name,
+// giving up if the connection is not accepted before the context ends
+func DialPipe(ctx context.Context, name string) (net.Conn, error) {
+	pipeListenersMu.Lock()
+	l, ok := pipeListeners[name]
+	pipeListenersMu.Unlock()
+
+	if !ok {
+		return nil, errors.New("no pipe listener at address")
+	}
+
+	serverConn, clientConn := net.Pipe()
+
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.closed:
+		return nil, errors.New("pipe listener closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Accept waits for and returns the next in-memory connection
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("pipe listener closed")
+	}
+}
+
+// Close stops the listener from accepting new connections
+func (l *PipeListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+
+		pipeListenersMu.Lock()
+		delete(pipeListeners, string(l.addr))
+		pipeListenersMu.Unlock()
+	})
+
+	return nil
+}
+
+// Addr returns the name the listener was created with
+func (l *PipeListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"io"
+	"math/bits"
+	"sync"
+)
+
+// Sizer is implemented by packets that know how many bytes they encode to before they are encoded,
+// such as a RawPacket with a simple layout. Such a packet is encoded straight into a buffer of its size
+// instead of one of the max packet size. Size may be an upper bound, but if it is too small the packet
+// is encoded again into a buffer of the max packet size.
+type Sizer interface {
+	Size() int
+}
+
+const (
+	// minBufferBits and maxBufferBits are the sizes of the smallest and largest pooled buffers as powers of two.
+	// Larger buffers are allocated when needed and left to the garbage collector.
+	minBufferBits = 6
+	maxBufferBits = 20
+)
+
+// bufferPools holds one pool of buffers for each power of two size from minBufferBits to maxBufferBits
+var bufferPools [maxBufferBits - minBufferBits + 1]sync.Pool
+
+// bufferClass returns the index of the pool holding buffers large enough for size bytes
+func bufferClass(size int) int {
+	if size <= 1<<minBufferBits {
+		return 0
+	}
+
+	return bits.Len(uint(size-1)) - minBufferBits
+}
+
+// GetBuffer returns a buffer of length size from a pool shared by every connection.
+// Once it is no longer used, it should be returned with PutBuffer.
+func GetBuffer(size int) *[]byte {
+	class := bufferClass(size)
+	if class >= len(bufferPools) {
+		buffer := make([]byte, size)
+		return &buffer
+	}
+
+	if buffer, ok := bufferPools[class].Get().(*[]byte); ok {
+		*buffer = (*buffer)[:size]
+		return buffer
+	}
+
+	buffer := make([]byte, size, 1<<(class+minBufferBits))
+	return &buffer
+}
+
+// PutBuffer returns a buffer from GetBuffer to its pool. The buffer must not be used afterwards.
+func PutBuffer(buffer *[]byte) {
+	class := bufferClass(cap(*buffer))
+	if class >= len(bufferPools) || cap(*buffer) != 1<<(class+minBufferBits) {
+		return
+	}
+
+	bufferPools[class].Put(buffer)
+}
+
+// EncodeFrame encodes the packet using the connection's serializer s into a frame with the given header, whose length
+// is set to the length of the encoded packet. The frame is returned in a buffer from GetBuffer that is only as large
+// as the frame needs, so it can be held on to, such as while it waits to be written, without holding on to the max
+// packet size. The packet may encode to at most maxPayloadSize bytes.
+func EncodeFrame(header FrameHeader, p Packet, s Serializer, maxPayloadSize int) (*[]byte, error) {
+	if sizer, ok := p.(Sizer); ok {
+		if size := sizer.Size(); size <= maxPayloadSize {
+			buffer := GetBuffer(FrameHeaderSize + size)
+			n, err := EncodePacket((*buffer)[FrameHeaderSize:], p, s)
+			if err == nil {
+				return finishFrame(buffer, header, n), nil
+			}
+
+			PutBuffer(buffer)
+			if err != io.ErrShortBuffer {
+				return nil, err
+			}
+		}
+	}
+
+	buffer := GetBuffer(FrameHeaderSize + maxPayloadSize)
+	n, err := EncodePacket((*buffer)[FrameHeaderSize:], p, s)
+	if err != nil {
+		PutBuffer(buffer)
+		return nil, err
+	}
+
+	// Move the frame into a smaller buffer if it is much smaller than the max packet size
+	if bufferClass(FrameHeaderSize+n) < bufferClass(cap(*buffer)) {
+		frame := GetBuffer(FrameHeaderSize + n)
+		copy((*frame)[FrameHeaderSize:], (*buffer)[FrameHeaderSize:FrameHeaderSize+n])
+		PutBuffer(buffer)
+		buffer = frame
+	}
+
+	return finishFrame(buffer, header, n), nil
+}
+
+// finishFrame puts the header of a frame with a payload of n bytes into the buffer the payload was encoded into
+func finishFrame(buffer *[]byte, header FrameHeader, n int) *[]byte {
+	header.Length = uint32(n)
+	PutFrameHeader(*buffer, header)
+	*buffer = (*buffer)[:FrameHeaderSize+n]
+
+	return buffer
+}
@@ -0,0 +1,93 @@
+package common_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// sizedPacket is a raw packet that reports its size up front, which may be wrong
+type sizedPacket struct {
+	data []byte
+	size int
+}
+
+func (p *sizedPacket) ID() uint16 {
+	return 5
+}
+
+func (p *sizedPacket) Size() int {
+	return p.size
+}
+
+func (p *sizedPacket) Read(buffer []byte) (int, error) {
+	if len(buffer) < len(p.data) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return copy(buffer, p.data), nil
+}
+
+func (p *sizedPacket) Write(buffer []byte) (int, error) {
+	p.data = append(p.data[:0], buffer...)
+	return len(buffer), nil
+}
+
+func TestGetBuffer(t *testing.T) {
+	buffer := common.GetBuffer(100)
+	assert.Len(t, *buffer, 100)
+	assert.Equal(t, 128, cap(*buffer))
+	common.PutBuffer(buffer)
+
+	buffer = common.GetBuffer(10)
+	assert.Len(t, *buffer, 10)
+	common.PutBuffer(buffer)
+
+	// Buffers larger than the largest pool are still returned
+	buffer = common.GetBuffer(3 << 20)
+	assert.Len(t, *buffer, 3<<20)
+	common.PutBuffer(buffer)
+}
+
+func TestEncodeFrameSizer(t *testing.T) {
+	p := &sizedPacket{data: []byte("test data"), size: 9}
+
+	frame, err := common.EncodeFrame(common.FrameHeader{PacketID: p.ID(), CallID: 4}, p, nil, 1000)
+	assert.NoError(t, err)
+
+	header := common.ParseFrameHeader(*frame)
+	assert.Equal(t, uint32(9), header.Length)
+	assert.Equal(t, uint16(5), header.PacketID)
+	assert.Equal(t, uint32(4), header.CallID)
+	assert.Equal(t, []byte("test data"), (*frame)[common.FrameHeaderSize:])
+	assert.Equal(t, 64, cap(*frame))
+	common.PutBuffer(frame)
+}
+
+func TestEncodeFrameSizerTooSmall(t *testing.T) {
+	data := make([]byte, 200)
+	p := &sizedPacket{data: data, size: 10}
+
+	frame, err := common.EncodeFrame(common.FrameHeader{PacketID: p.ID()}, p, nil, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(200), common.ParseFrameHeader(*frame).Length)
+	assert.Len(t, *frame, common.FrameHeaderSize+200)
+	common.PutBuffer(frame)
+
+	_, err = common.EncodeFrame(common.FrameHeader{PacketID: p.ID()}, p, nil, 100)
+	assert.Equal(t, io.ErrShortBuffer, err)
+}
+
+func TestEncodeFrameShrinksBuffer(t *testing.T) {
+	frame, err := common.EncodeFrame(common.FrameHeader{PacketID: 1}, newCodecTestPacket(), nil, 65535)
+	assert.NoError(t, err)
+	assert.Less(t, cap(*frame), 1024)
+
+	var decoded codecTestPacket
+	err = common.DecodePacket(&decoded, (*frame)[common.FrameHeaderSize:], nil)
+	assert.NoError(t, err)
+	assert.Equal(t, *newCodecTestPacket(), decoded)
+	common.PutBuffer(frame)
+}
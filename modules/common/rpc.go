@@ -0,0 +1,217 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// CallErrCode says why a call failed. Codes below 256 are reserved for the library,
+// and handlers are free to use any other code for errors of their own.
+type CallErrCode uint16
+
+const (
+	// CallErrInternal means the handler failed with an error that is not a *CallErr
+	CallErrInternal CallErrCode = iota + 1
+	// CallErrUnhandled means the peer has no handler for the type of the request
+	CallErrUnhandled
+	// CallErrBusy means the peer was already running as many handlers for the caller's requests as it allows.
+	// The request was not handled, so it can be sent again once earlier calls have been answered.
+	CallErrBusy
+)
+
+// DefaultMaxHandlers is how many handlers for the requests of a single peer may run at once unless configured otherwise
+const DefaultMaxHandlers = 64
+
+// CallErr is the error a handler answers a request with. Handlers can return one to give the caller
+// a code it can act on. Any other error is sent to the caller as a CallErr with CallErrInternal.
+type CallErr struct {
+	Code    CallErrCode
+	Message string
+}
+
+func (e CallErr) Error() string {
+	return fmt.Sprintf("call failed (%d): %s", e.Code, e.Message)
+}
+
+// CallResult is the outcome of a call: the response the peer answered with, or the error the call failed with
+type CallResult struct {
+	Packet Packet
+	Err    error
+}
+
+// WriteRequestCancel tells the peer that the caller gave up on the call with the given ID
+func WriteRequestCancel(w io.Writer, callID uint32) error {
+	return writeFrame(w, FrameHeader{Flags: FlagControl, PacketID: uint16(ControlCancel), CallID: callID}, nil)
+}
+
+// WriteCallErr answers the call with the given ID with an error. Errors that are not a *CallErr are sent with CallErrInternal.
+func WriteCallErr(w io.Writer, callID uint32, err error) error {
+	callErr, ok := err.(*CallErr)
+	if !ok {
+		callErr = &CallErr{Code: CallErrInternal, Message: err.Error()}
+	}
+
+	e := encoder{}
+	e.encode(reflect.ValueOf(callErr).Elem(), -1)
+	return writeFrame(w, FrameHeader{Flags: FlagResponse | FlagError, CallID: callID}, e.buffer)
+}
+
+// ParseCallErr decodes the error carried by a response with FlagError
+func ParseCallErr(payload []byte) (*CallErr, error) {
+	var callErr CallErr
+	if err := DefaultCodec.Decode(payload, &callErr); err != nil {
+		return nil, err
+	}
+
+	return &callErr, nil
+}
+
+// Calls keeps track of the calls made on a single connection: the ones waiting
+// on the peer's response, and the requests from the peer whose handlers are still running
+type Calls struct {
+	nextID   uint32
+	waiting  map[uint32]chan CallResult
+	running  map[uint32]context.CancelFunc
+	closeErr error
+	mutex    sync.Mutex
+
+	// maxHandlers bounds how many requests can be handled at once
+	maxHandlers int
+}
+
+// NewCalls returns an empty set of calls for a new connection, which runs up to maxHandlers request handlers at once
+func NewCalls(maxHandlers int) *Calls {
+	return &Calls{
+		waiting:     make(map[uint32]chan CallResult),
+		running:     make(map[uint32]context.CancelFunc),
+		maxHandlers: maxHandlers,
+	}
+}
+
+// Call sends a request with a new call ID and waits for the peer's response. If the context ends first,
+// the peer is told to cancel the call by writing to w and the context's error is returned.
+func (c *Calls) Call(ctx context.Context, w io.Writer, send func(callID uint32) error) (Packet, error) {
+	c.mutex.Lock()
+	if c.closeErr != nil {
+		c.mutex.Unlock()
+		return nil, c.closeErr
+	}
+
+	// Call ID 0 means a frame is not part of a call, so it is skipped when the IDs wrap around,
+	// and so are the IDs of calls that have been waiting ever since they were last used
+	for {
+		c.nextID++
+		if _, ok := c.waiting[c.nextID]; c.nextID != 0 && !ok {
+			break
+		}
+	}
+	callID := c.nextID
+
+	result := make(chan CallResult, 1)
+	c.waiting[callID] = result
+	c.mutex.Unlock()
+
+	if err := send(callID); err != nil {
+		c.forget(callID)
+		return nil, err
+	}
+
+	select {
+	case r := <-result:
+		return r.Packet, r.Err
+	case <-ctx.Done():
+		c.forget(callID)
+		WriteRequestCancel(w, callID)
+		return nil, ctx.Err()
+	}
+}
+
+// forget stops waiting on a call, so a late response to it is ignored
+func (c *Calls) forget(callID uint32) {
+	c.mutex.Lock()
+	delete(c.waiting, callID)
+	c.mutex.Unlock()
+}
+
+// Resolve delivers the result of the call with the given ID and reports whether the caller was still waiting on it
+func (c *Calls) Resolve(callID uint32, result CallResult) bool {
+	c.mutex.Lock()
+	waiting, ok := c.waiting[callID]
+	delete(c.waiting, callID)
+	c.mutex.Unlock()
+
+	if ok {
+		waiting <- result
+	}
+
+	return ok
+}
+
+// Handle returns the context the handler of the request with the given call ID runs with, which is cancelled
+// once the caller cancels the call or the connection ends, and a function to call once the handler has returned.
+// A *CallErr with CallErrBusy is returned if maxHandlers handlers are already running, which the caller should be
+// answered with. A *ProtocolErr is returned if a request with the same call ID is still being handled, since the
+// caller could not tell their responses apart.
+func (c *Calls) Handle(callID uint32) (context.Context, func(), error) {
+	c.mutex.Lock()
+	if _, ok := c.running[callID]; ok {
+		c.mutex.Unlock()
+		return nil, nil, &ProtocolErr{Err: fmt.Errorf("request with call ID %d is already being handled", callID)}
+	}
+
+	if len(c.running) >= c.maxHandlers {
+		c.mutex.Unlock()
+		return nil, nil, &CallErr{Code: CallErrBusy, Message: fmt.Sprintf("already handling %d requests", len(c.running))}
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	if c.closeErr != nil {
+		cancelFunc()
+	} else {
+		c.running[callID] = cancelFunc
+	}
+	c.mutex.Unlock()
+
+	return ctx, func() {
+		c.mutex.Lock()
+		delete(c.running, callID)
+		c.mutex.Unlock()
+
+		cancelFunc()
+	}, nil
+}
+
+// Cancel cancels the context of the handler of the request with the given call ID
+func (c *Calls) Cancel(callID uint32) {
+	c.mutex.Lock()
+	cancelFunc, ok := c.running[callID]
+	c.mutex.Unlock()
+
+	if ok {
+		cancelFunc()
+	}
+}
+
+// Close fails every call still waiting on a response with err and cancels every running handler.
+// Calls made after the connection has been closed fail with err too.
+func (c *Calls) Close(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closeErr != nil {
+		return
+	}
+	c.closeErr = err
+
+	for callID, waiting := range c.waiting {
+		waiting <- CallResult{Err: err}
+		delete(c.waiting, callID)
+	}
+
+	for _, cancelFunc := range c.running {
+		cancelFunc()
+	}
+}
@@ -0,0 +1,161 @@
+package common_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameHeaderCallID(t *testing.T) {
+	buffer := make([]byte, common.FrameHeaderSize)
+	header := common.FrameHeader{Length: 3, Flags: common.FlagRequest, PacketID: 0x0102, CallID: 0x01020304}
+	common.PutFrameHeader(buffer, header)
+	assert.Equal(t, header, common.ParseFrameHeader(buffer))
+}
+
+func TestCallErrRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	err := common.WriteCallErr(&buffer, 7, &common.CallErr{Code: 300, Message: "name taken"})
+	assert.NoError(t, err)
+
+	err = common.WriteCallErr(&buffer, 8, errors.New("database is down"))
+	assert.NoError(t, err)
+
+	r := common.NewFrameReader(&buffer, 100)
+	for _, expected := range []struct {
+		callID uint32
+		err    *common.CallErr
+	}{
+		{callID: 7, err: &common.CallErr{Code: 300, Message: "name taken"}},
+		{callID: 8, err: &common.CallErr{Code: common.CallErrInternal, Message: "database is down"}},
+	} {
+		frame, err := r.ReadFrame()
+		assert.NoError(t, err)
+		assert.True(t, frame.IsResponse())
+		assert.Equal(t, expected.callID, frame.CallID)
+
+		callErr, err := common.ParseCallErr(frame.Payload)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.err, callErr)
+	}
+}
+
+func TestCallsResolve(t *testing.T) {
+	calls := common.NewCalls(common.DefaultMaxHandlers)
+
+	sent := make(chan uint32, 2)
+	results := make(chan common.CallResult, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			p, err := calls.Call(context.Background(), ioutil.Discard, func(callID uint32) error {
+				sent <- callID
+				return nil
+			})
+			results <- common.CallResult{Packet: p, Err: err}
+		}()
+	}
+
+	first, second := <-sent, <-sent
+	assert.NotEqual(t, first, second)
+	assert.NotZero(t, first)
+	assert.NotZero(t, second)
+
+	// Answer the calls out of order
+	assert.True(t, calls.Resolve(second, common.CallResult{Packet: &codecTestPacket{Text: "second"}}))
+	assert.Equal(t, "second", (<-results).Packet.(*codecTestPacket).Text)
+
+	assert.True(t, calls.Resolve(first, common.CallResult{Packet: &codecTestPacket{Text: "first"}}))
+	assert.Equal(t, "first", (<-results).Packet.(*codecTestPacket).Text)
+
+	// A response to a call no one is waiting on is ignored
+	assert.False(t, calls.Resolve(first, common.CallResult{}))
+}
+
+func TestCallsCancel(t *testing.T) {
+	calls := common.NewCalls(common.DefaultMaxHandlers)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	var callID uint32
+	var written bytes.Buffer
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		cancelFunc()
+	}()
+
+	_, err := calls.Call(ctx, &written, func(id uint32) error {
+		callID = id
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, calls.Resolve(callID, common.CallResult{}))
+
+	// The peer is told to stop handling the call
+	frame, err := common.NewFrameReader(&written, 10).ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsControl())
+	assert.Equal(t, common.ControlCancel, common.ControlType(frame.PacketID))
+	assert.Equal(t, callID, frame.CallID)
+
+	handlerCtx, done, err := calls.Handle(1)
+	assert.NoError(t, err)
+	defer done()
+
+	calls.Cancel(1)
+	assert.Equal(t, context.Canceled, handlerCtx.Err())
+}
+
+func TestCallsClose(t *testing.T) {
+	calls := common.NewCalls(common.DefaultMaxHandlers)
+	closeErr := &common.DisconnectErr{Reason: common.CloseGoingAway}
+
+	handlerCtx, done, err := calls.Handle(1)
+	assert.NoError(t, err)
+	defer done()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := calls.Call(context.Background(), ioutil.Discard, func(callID uint32) error { return nil })
+		result <- err
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	calls.Close(closeErr)
+
+	assert.Equal(t, closeErr, <-result)
+	assert.Equal(t, context.Canceled, handlerCtx.Err())
+
+	_, err = calls.Call(context.Background(), ioutil.Discard, func(callID uint32) error {
+		t.Error("closed calls should not send requests")
+		return nil
+	})
+	assert.Equal(t, closeErr, err)
+}
+
+func TestCallsHandleLimits(t *testing.T) {
+	calls := common.NewCalls(1)
+
+	_, done, err := calls.Handle(1)
+	assert.NoError(t, err)
+
+	// A request whose call ID is still being handled could not be told apart from the first
+	_, _, err = calls.Handle(1)
+	assert.IsType(t, &common.ProtocolErr{}, err)
+
+	_, _, err = calls.Handle(2)
+	if assert.IsType(t, &common.CallErr{}, err) {
+		assert.Equal(t, common.CallErrBusy, err.(*common.CallErr).Code)
+	}
+
+	// Once the handler returns, both its call ID and its place can be used again
+	done()
+
+	_, done, err = calls.Handle(1)
+	assert.NoError(t, err)
+	done()
+}
@@ -0,0 +1,145 @@
+package common
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Serializer encodes packets that do not encode themselves. The serializer a client and server use for a
+// connection is negotiated when the client connects, so the same packet types can be shared with peers that
+// are not written in Go by having them speak a common format such as JSON or MessagePack.
+type Serializer interface {
+	// Name identifies the serializer during negotiation, so it must be the same on both sides
+	Name() string
+	// Encode encodes v into buffer and returns the number of bytes written.
+	// io.ErrShortBuffer is returned if the buffer is too small to hold the encoded value.
+	Encode(buffer []byte, v interface{}) (int, error)
+	// Decode decodes data into v, which must be a non-nil pointer
+	Decode(data []byte, v interface{}) error
+}
+
+// SerializedPacket is a packet that is always encoded by the same serializer,
+// no matter which serializer was negotiated for the connection it is sent on
+type SerializedPacket interface {
+	Packet
+	Serializer() Serializer
+}
+
+// Name returns "gochat"
+func (Codec) Name() string {
+	return "gochat"
+}
+
+// JSONSerializer encodes packets as JSON objects using encoding/json, so json struct tags apply
+type JSONSerializer struct{}
+
+// Name returns "json"
+func (JSONSerializer) Name() string {
+	return "json"
+}
+
+func (JSONSerializer) Encode(buffer []byte, v interface{}) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	return copyEncoded(buffer, data)
+}
+
+func (JSONSerializer) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobSerializer encodes packets using encoding/gob. Every packet carries its own type description,
+// so it is larger than with the other serializers, but can only be decoded by Go peers.
+type GobSerializer struct{}
+
+// Name returns "gob"
+func (GobSerializer) Name() string {
+	return "gob"
+}
+
+func (GobSerializer) Encode(buffer []byte, v interface{}) (int, error) {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(v); err != nil {
+		return 0, err
+	}
+
+	return copyEncoded(buffer, data.Bytes())
+}
+
+func (GobSerializer) Decode(data []byte, v interface{}) error {
+	r := bytes.NewReader(data)
+	if err := gob.NewDecoder(r).Decode(v); err != nil {
+		return err
+	}
+
+	if r.Len() > 0 {
+		return fmt.Errorf("gob: %d bytes of trailing data", r.Len())
+	}
+
+	return nil
+}
+
+// copyEncoded copies data that was encoded elsewhere into the caller's buffer
+func copyEncoded(buffer []byte, data []byte) (int, error) {
+	if len(data) > len(buffer) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return copy(buffer, data), nil
+}
+
+// packetSerializer returns the serializer the packet is encoded with on a connection that negotiated s
+func packetSerializer(p Packet, s Serializer) Serializer {
+	if serialized, ok := p.(SerializedPacket); ok {
+		return serialized.Serializer()
+	}
+
+	if s == nil {
+		return DefaultCodec
+	}
+
+	return s
+}
+
+// EncodeSerializers returns the payload of a serializer control frame listing the given serializers
+func EncodeSerializers(serializers []Serializer) []byte {
+	names := make([]string, len(serializers))
+	for i, s := range serializers {
+		names[i] = s.Name()
+	}
+
+	e := encoder{}
+	e.encode(reflect.ValueOf(names), -1)
+	return e.buffer
+}
+
+// ParseSerializers decodes the serializer names carried by a serializer control frame
+func ParseSerializers(payload []byte) ([]string, error) {
+	var names []string
+	if err := DefaultCodec.Decode(payload, &names); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// ChooseSerializer returns the first of the offered serializer names that is one of the supported serializers,
+// or nil if there is none. The offer is in the order the peer prefers, so the peer's preference wins.
+func ChooseSerializer(offered []string, supported []Serializer) Serializer {
+	for _, name := range offered {
+		for _, s := range supported {
+			if s.Name() == name {
+				return s
+			}
+		}
+	}
+
+	return nil
+}
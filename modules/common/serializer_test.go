@@ -0,0 +1,138 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type serializerTestPacket struct {
+	Name    string
+	Count   int
+	Ratio   float64
+	Tags    []string
+	Data    []byte
+	Scores  map[string]int
+	Enabled bool
+}
+
+func (p serializerTestPacket) ID() uint16 {
+	return 1
+}
+
+func TestSerializersRoundTrip(t *testing.T) {
+	p := &serializerTestPacket{
+		Name:    "alice",
+		Count:   -300,
+		Ratio:   1.5,
+		Tags:    []string{"a", "b"},
+		Data:    []byte{1, 2, 3},
+		Scores:  map[string]int{"x": 1 << 40},
+		Enabled: true,
+	}
+
+	serializers := []common.Serializer{common.JSONSerializer{}, common.GobSerializer{}, common.MessagePackSerializer{}}
+	for _, s := range serializers {
+		buffer := make([]byte, 500)
+		n, err := s.Encode(buffer, p)
+		assert.NoError(t, err, s.Name())
+
+		var decoded serializerTestPacket
+		err = s.Decode(buffer[:n], &decoded)
+		assert.NoError(t, err, s.Name())
+		assert.Equal(t, *p, decoded, s.Name())
+
+		_, err = s.Encode(make([]byte, 2), p)
+		assert.Error(t, err, s.Name())
+	}
+}
+
+func TestMessagePackEncoding(t *testing.T) {
+	type small struct {
+		A int
+		B string `msgpack:"b"`
+		C []byte `msgpack:"-"`
+	}
+
+	buffer := make([]byte, 100)
+	n, err := common.MessagePackSerializer{}.Encode(buffer, small{A: -1, B: "hi", C: []byte{1}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x82, 0xa1, 'A', 0xff, 0xa1, 'b', 0xa2, 'h', 'i'}, buffer[:n])
+
+	// Keys without a field are skipped, so peers can add fields the other side does not know about
+	data := []byte{0x83, 0xa1, 'A', 0xd1, 0x01, 0x00, 0xa1, 'Z', 0x92, 0xc3, 0xc0, 0xa1, 'b', 0xc4, 0x01, 'x'}
+	var decoded small
+	err = common.MessagePackSerializer{}.Decode(data, &decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, small{A: 256, B: "x"}, decoded)
+
+	var value interface{}
+	err = common.MessagePackSerializer{}.Decode(data, &value)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"A": int64(256), "Z": []interface{}{true, nil}, "b": []byte("x")}, value)
+
+	err = common.MessagePackSerializer{}.Decode([]byte{0x81, 0xa1, 'A', 0xa1, 'x'}, &decoded)
+	assert.Error(t, err)
+
+	var small8 struct{ A int8 }
+	err = common.MessagePackSerializer{}.Decode([]byte{0x81, 0xa1, 'A', 0xcc, 0xff}, &small8)
+	assert.Error(t, err)
+
+	err = common.MessagePackSerializer{}.Decode([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, &value)
+	assert.Error(t, err)
+}
+
+type jsonOnlyPacket struct {
+	Text string `json:"text"`
+}
+
+func (p jsonOnlyPacket) ID() uint16 {
+	return 2
+}
+
+func (p jsonOnlyPacket) Serializer() common.Serializer {
+	return common.JSONSerializer{}
+}
+
+func TestSerializedPacketIgnoresConnectionSerializer(t *testing.T) {
+	buffer := make([]byte, 100)
+	n, err := common.EncodePacket(buffer, jsonOnlyPacket{Text: "hi"}, common.MessagePackSerializer{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"text":"hi"}`, string(buffer[:n]))
+
+	var decoded jsonOnlyPacket
+	err = common.DecodePacket(&decoded, buffer[:n], common.GobSerializer{})
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", decoded.Text)
+}
+
+func TestChooseSerializer(t *testing.T) {
+	supported := []common.Serializer{common.DefaultCodec, common.JSONSerializer{}, common.MessagePackSerializer{}}
+
+	names, err := common.ParseSerializers(common.EncodeSerializers([]common.Serializer{common.GobSerializer{}, common.MessagePackSerializer{}, common.JSONSerializer{}}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gob", "msgpack", "json"}, names)
+
+	assert.Equal(t, common.MessagePackSerializer{}, common.ChooseSerializer(names, supported))
+	assert.Nil(t, common.ChooseSerializer([]string{"gob"}, supported))
+}
+
+func FuzzMessagePackDecode(f *testing.F) {
+	buffer := make([]byte, 500)
+	n, err := common.MessagePackSerializer{}.Encode(buffer, serializerTestPacket{Name: "alice", Tags: []string{"a"}, Scores: map[string]int{"x": 1}})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(buffer[:n])
+	f.Add([]byte{0x92, 0x81, 0x01, 0x02, 0xcb})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var decoded serializerTestPacket
+		common.MessagePackSerializer{}.Decode(data, &decoded)
+
+		var value interface{}
+		common.MessagePackSerializer{}.Decode(data, &value)
+	})
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator decides whether a connecting client is admitted to the server
+type Authenticator interface {
+	// Authenticate checks the first packet the client sent and returns the identity the client proved.
+	// The packet is a *common.Credentials if the client was configured with credentials, and otherwise
+	// a new value of the registered packet type the client sent first. Clients from the client package
+	// always present credentials. Returning an error rejects the client, and the error's message is sent
+	// to it as the reason.
+	Authenticate(ctx context.Context, conn net.Conn, firstPacket common.Packet) (Identity, error)
+}
+
+// WithAuthenticator makes every client pass the given authenticator before it is admitted. Until it has,
+// the client has no ClientID, receives no broadcasts and onClientConnected is not called for it.
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(s *TCPServer) {
+		s.authenticator = authenticator
+	}
+}
+
+// credentials returns the credentials the client sent as its first packet
+func credentials(firstPacket common.Packet) (*common.Credentials, error) {
+	credentials, ok := firstPacket.(*common.Credentials)
+	if !ok {
+		return nil, &CredentialsRequiredErr{}
+	}
+
+	return credentials, nil
+}
+
+// SharedSecretAuthenticator admits any client that presents the same secret as the server.
+// The client is identified by the username it presents.
+type SharedSecretAuthenticator struct {
+	secret []byte
+}
+
+// NewSharedSecretAuthenticator returns an authenticator that admits clients presenting the given secret
+func NewSharedSecretAuthenticator(secret string) *SharedSecretAuthenticator {
+	return &SharedSecretAuthenticator{secret: []byte(secret)}
+}
+
+func (a *SharedSecretAuthenticator) Authenticate(ctx context.Context, conn net.Conn, firstPacket common.Packet) (Identity, error) {
+	credentials, err := credentials(firstPacket)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(credentials.Secret), a.secret) != 1 {
+		return Identity{}, &InvalidCredentialsErr{}
+	}
+
+	return Identity{Name: credentials.Username}, nil
+}
+
+// PasswordFileAuthenticator admits clients whose username and password match an entry of a password file
+type PasswordFileAuthenticator struct {
+	hashes map[string][]byte
+	// dummyHash is checked for unknown usernames, so they cannot be told apart from known ones by how long they take
+	dummyHash []byte
+}
+
+// NewPasswordFileAuthenticator reads a password file made of "username:hash" lines, where each hash is a bcrypt hash
+// of the user's password, such as the ones written by "htpasswd -B". Blank lines and lines starting with # are ignored.
+func NewPasswordFileAuthenticator(path string) (*PasswordFileAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readPasswordFile(file)
+}
+
+func readPasswordFile(r io.Reader) (*PasswordFileAuthenticator, error) {
+	hashes := make(map[string][]byte)
+	dummyCost := bcrypt.DefaultCost
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		separator := strings.Index(entry, ":")
+		if separator < 1 {
+			return nil, fmt.Errorf("password file line %d is not of the form username:hash", line)
+		}
+
+		hash := []byte(entry[separator+1:])
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return nil, fmt.Errorf("password file line %d: %v", line, err)
+		}
+
+		if len(hashes) == 0 || cost > dummyCost {
+			dummyCost = cost
+		}
+
+		hashes[entry[:separator]] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The dummy hash costs as much to check as the costliest hash in the file
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), dummyCost)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasswordFileAuthenticator{hashes: hashes, dummyHash: dummyHash}, nil
+}
+
+func (a *PasswordFileAuthenticator) Authenticate(ctx context.Context, conn net.Conn, firstPacket common.Packet) (Identity, error) {
+	credentials, err := credentials(firstPacket)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	hash, ok := a.hashes[credentials.Username]
+	if !ok {
+		hash = a.dummyHash
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(credentials.Secret)); err != nil || !ok {
+		return Identity{}, &InvalidCredentialsErr{}
+	}
+
+	return Identity{Name: credentials.Username}, nil
+}
+
+// TokenAuthenticator admits clients that present a token signed with the server's key.
+// Tokens name the user they were issued to, so the client's username does not need to be set.
+type TokenAuthenticator struct {
+	key []byte
+}
+
+// NewTokenAuthenticator returns an authenticator that accepts tokens signed with the given key
+func NewTokenAuthenticator(key []byte) *TokenAuthenticator {
+	return &TokenAuthenticator{key: key}
+}
+
+// NewToken issues a token for the given user that is valid until expiry.
+// A zero expiry makes a token that never expires.
+func (a *TokenAuthenticator) NewToken(username string, expiry time.Time) string {
+	var expiryUnix int64
+	if !expiry.IsZero() {
+		expiryUnix = expiry.Unix()
+	}
+
+	payload := []byte(strconv.FormatInt(expiryUnix, 10) + ":" + username)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(a.sign(payload))
+}
+
+func (a *TokenAuthenticator) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (a *TokenAuthenticator) Authenticate(ctx context.Context, conn net.Conn, firstPacket common.Packet) (Identity, error) {
+	credentials, err := credentials(firstPacket)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	parts := strings.Split(credentials.Secret, ".")
+	if len(parts) != 2 {
+		return Identity{}, &InvalidCredentialsErr{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Identity{}, &InvalidCredentialsErr{}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, a.sign(payload)) {
+		return Identity{}, &InvalidCredentialsErr{}
+	}
+
+	fields := strings.SplitN(string(payload), ":", 2)
+	if len(fields) != 2 {
+		return Identity{}, &InvalidCredentialsErr{}
+	}
+
+	expiryUnix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return Identity{}, &InvalidCredentialsErr{}
+	}
+
+	if expiryUnix != 0 && time.Now().After(time.Unix(expiryUnix, 0)) {
+		return Identity{}, &TokenExpiredErr{Expiry: time.Unix(expiryUnix, 0)}
+	}
+
+	username := fields[1]
+	if credentials.Username != "" && credentials.Username != username {
+		return Identity{}, &InvalidCredentialsErr{}
+	}
+
+	return Identity{Name: username}, nil
+}
+
+// CredentialsRequiredErr is returned when a client does not present credentials to an authenticator that needs them
+type CredentialsRequiredErr struct{}
+
+func (e *CredentialsRequiredErr) Error() string {
+	return "credentials required"
+}
+
+// InvalidCredentialsErr is returned when a client presents credentials that do not match
+type InvalidCredentialsErr struct{}
+
+func (e *InvalidCredentialsErr) Error() string {
+	return "invalid credentials"
+}
+
+// TokenExpiredErr is returned when a client presents a token that has expired
+type TokenExpiredErr struct {
+	Expiry time.Time
+}
+
+func (e *TokenExpiredErr) Error() string {
+	return fmt.Sprintf("token expired at %v", e.Expiry)
+}
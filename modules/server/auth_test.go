@@ -0,0 +1,214 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTCPServerAuthenticator(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+
+	var s *server.TCPServer
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {}, server.WithAuthenticator(server.NewSharedSecretAuthenticator("secret")))
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100, client.WithCredentials(common.Credentials{Username: "alice", Secret: "wrong"}))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	if assert.IsType(t, &client.ConnectErr{}, err) {
+		assert.Equal(t, &common.DisconnectErr{Reason: common.CloseUnauthorized, Message: "invalid credentials"}, err.(*client.ConnectErr).Err)
+	}
+	assert.Empty(t, connected)
+
+	c, err = client.NewTCPClient(100, client.WithCredentials(common.Credentials{Username: "alice", Secret: "secret"}))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+	defer c.Disconnect()
+
+	identity, err := s.Identity(<-connected)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", identity.Name)
+}
+
+func TestTCPServerCredentialsWithoutAuthenticator(t *testing.T) {
+	received := make(chan string, 1)
+	connected := make(chan server.ClientID, 1)
+
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&TestDataPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received <- string(p.(*TestDataPacket).Data)
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	// The server does not require authentication, so the client does not present its credentials
+	c, err := client.NewTCPClient(100, client.WithCredentials(common.Credentials{Username: "alice", Secret: "secret"}))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+	defer c.Disconnect()
+
+	identity, err := s.Identity(<-connected)
+	assert.NoError(t, err)
+	assert.Equal(t, server.Identity{}, identity)
+
+	err = c.SendPacket(&TestDataPacket{Data: []byte("hello")})
+	assert.NoError(t, err)
+
+	select {
+	case data := <-received:
+		assert.Equal(t, "hello", data)
+	case <-time.After(time.Second * 5):
+		t.Fatal("packet was not received")
+	}
+}
+
+func TestTCPServerAuthenticatorWithoutCredentials(t *testing.T) {
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		t.Error("client without credentials should not have been admitted")
+	}, func(clientID server.ClientID, err error) {}, server.WithAuthenticator(server.NewSharedSecretAuthenticator("secret")))
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100)
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	if assert.IsType(t, &client.ConnectErr{}, err) {
+		assert.Equal(t, &client.CredentialsRequiredErr{}, err.(*client.ConnectErr).Err)
+	}
+}
+
+// nameAuthenticator admits clients that introduce themselves with a TestDataPacket
+type nameAuthenticator struct{}
+
+func (a nameAuthenticator) Authenticate(ctx context.Context, conn net.Conn, firstPacket common.Packet) (server.Identity, error) {
+	p, ok := firstPacket.(*TestDataPacket)
+	if !ok {
+		return server.Identity{}, errors.New("expected a name")
+	}
+
+	return server.Identity{Name: string(p.Data)}, nil
+}
+
+func TestTCPServerAuthenticatorFirstPacket(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+
+	var s *server.TCPServer
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {}, server.WithAuthenticator(nameAuthenticator{}))
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&TestDataPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	common.WriteFrame(conn, 1, []byte("bob"))
+
+	frame, err := common.NewFrameReader(conn, 100).ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsControl())
+	assert.Equal(t, common.ControlAccept, common.ControlType(frame.PacketID))
+
+	identity, err := s.Identity(<-connected)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", identity.Name)
+}
+
+func TestPasswordFileAuthenticator(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "gochat")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "passwords")
+	err = ioutil.WriteFile(path, []byte("# users\n\nalice:"+string(hash)+"\n"), 0600)
+	assert.NoError(t, err)
+
+	a, err := server.NewPasswordFileAuthenticator(path)
+	assert.NoError(t, err)
+
+	identity, err := a.Authenticate(context.Background(), nil, &common.Credentials{Username: "alice", Secret: "hunter2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", identity.Name)
+
+	_, err = a.Authenticate(context.Background(), nil, &common.Credentials{Username: "alice", Secret: "hunter3"})
+	assert.IsType(t, &server.InvalidCredentialsErr{}, err)
+
+	_, err = a.Authenticate(context.Background(), nil, &common.Credentials{Username: "bob", Secret: "hunter2"})
+	assert.IsType(t, &server.InvalidCredentialsErr{}, err)
+
+	_, err = a.Authenticate(context.Background(), nil, &TestDataPacket{})
+	assert.IsType(t, &server.CredentialsRequiredErr{}, err)
+
+	err = ioutil.WriteFile(path, []byte("alice:not a hash\n"), 0600)
+	assert.NoError(t, err)
+
+	_, err = server.NewPasswordFileAuthenticator(path)
+	assert.Error(t, err)
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	a := server.NewTokenAuthenticator([]byte("key"))
+
+	token := a.NewToken("alice", time.Now().Add(time.Hour))
+	identity, err := a.Authenticate(context.Background(), nil, &common.Credentials{Secret: token})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", identity.Name)
+
+	_, err = a.Authenticate(context.Background(), nil, &common.Credentials{Username: "bob", Secret: token})
+	assert.IsType(t, &server.InvalidCredentialsErr{}, err)
+
+	forged := server.NewTokenAuthenticator([]byte("other key")).NewToken("alice", time.Time{})
+	_, err = a.Authenticate(context.Background(), nil, &common.Credentials{Secret: forged})
+	assert.IsType(t, &server.InvalidCredentialsErr{}, err)
+
+	expired := a.NewToken("alice", time.Now().Add(-time.Hour))
+	_, err = a.Authenticate(context.Background(), nil, &common.Credentials{Secret: expired})
+	assert.IsType(t, &server.TokenExpiredErr{}, err)
+
+	_, err = a.Authenticate(context.Background(), nil, &common.Credentials{Secret: "garbage"})
+	assert.IsType(t, &server.InvalidCredentialsErr{}, err)
+}
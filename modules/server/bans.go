@@ -0,0 +1,212 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bans keeps track of the addresses and identities that may not connect to a server. A server checks the
+// address of every connection before anything else, and the identity of every client once it has proven
+// one, before admitting it. Banned clients are turned away with common.CloseBanned and the ban's reason.
+// Bans only turn away new connections, so a client that is already connected should be kicked as well.
+// It is safe for concurrent use.
+type Bans struct {
+	networks   map[string]networkBan
+	identities map[string]Ban
+	mutex      sync.Mutex
+}
+
+// Ban says why an address or identity is banned and for how long
+type Ban struct {
+	Reason string
+	// Expires is when the ban is lifted, or the zero time if it never is
+	Expires time.Time
+}
+
+// networkBan is a ban on every address within a network
+type networkBan struct {
+	network *net.IPNet
+	ban     Ban
+}
+
+// NewBans returns an empty ban list
+func NewBans() *Bans {
+	return &Bans{
+		networks:   make(map[string]networkBan),
+		identities: make(map[string]Ban),
+	}
+}
+
+// newBan returns a ban with the given reason that is lifted after the given duration, or never if it is 0
+func newBan(reason string, duration time.Duration) Ban {
+	ban := Ban{Reason: reason}
+	if duration > 0 {
+		ban.Expires = time.Now().Add(duration)
+	}
+
+	return ban
+}
+
+// expired reports whether the ban has been lifted
+func (b Ban) expired(now time.Time) bool {
+	return !b.Expires.IsZero() && !now.Before(b.Expires)
+}
+
+// parseNetwork parses an IP address, which is treated as a network of one address, or a network in CIDR notation
+func parseNetwork(addr string) (*net.IPNet, error) {
+	if strings.Contains(addr, "/") {
+		_, network, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, &InvalidAddrErr{Addr: addr}
+		}
+
+		return network, nil
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, &InvalidAddrErr{Addr: addr}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// BanAddr bans an IP address, such as "203.0.113.7", or every address in a network in CIDR notation, such as
+// "203.0.113.0/24", for the given duration. A duration of 0 bans it until it is unbanned. Banning an address
+// that is already banned replaces its ban. An *InvalidAddrErr is returned if the address cannot be parsed.
+func (b *Bans) BanAddr(addr string, duration time.Duration, reason string) error {
+	network, err := parseNetwork(addr)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.networks[network.String()] = networkBan{network: network, ban: newBan(reason, duration)}
+	return nil
+}
+
+// UnbanAddr lifts the ban on an address or network, which must be given as it was banned.
+// Addresses within a banned network cannot be unbanned on their own.
+func (b *Bans) UnbanAddr(addr string) error {
+	network, err := parseNetwork(addr)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.networks, network.String())
+	return nil
+}
+
+// BanIdentity bans the identity with the given name for the given duration.
+// A duration of 0 bans it until it is unbanned. Banning an identity that is already banned replaces its ban.
+func (b *Bans) BanIdentity(name string, duration time.Duration, reason string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.identities[name] = newBan(reason, duration)
+}
+
+// UnbanIdentity lifts the ban on the identity with the given name
+func (b *Bans) UnbanIdentity(name string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.identities, name)
+}
+
+// Addr returns the ban on the given address, or false if it is not banned. The address is banned if
+// it is within any banned network, and the ban returned is the one of those that is lifted last.
+func (b *Bans) Addr(addr net.Addr) (Ban, bool) {
+	ip := remoteIP(addr)
+	if ip == nil {
+		return Ban{}, false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+
+	var match Ban
+	var matched bool
+	for key, n := range b.networks {
+		if n.ban.expired(now) {
+			delete(b.networks, key)
+			continue
+		}
+
+		if !n.network.Contains(ip) {
+			continue
+		}
+
+		if !matched || n.ban.Expires.IsZero() || (!match.Expires.IsZero() && n.ban.Expires.After(match.Expires)) {
+			match = n.ban
+			matched = true
+		}
+	}
+
+	return match, matched
+}
+
+// Identity returns the ban on the identity with the given name, or false if it is not banned.
+// Clients that did not prove an identity have an empty name, which cannot be banned.
+func (b *Bans) Identity(name string) (Ban, bool) {
+	if name == "" {
+		return Ban{}, false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ban, ok := b.identities[name]
+	if !ok {
+		return Ban{}, false
+	}
+
+	if ban.expired(time.Now()) {
+		delete(b.identities, name)
+		return Ban{}, false
+	}
+
+	return ban, true
+}
+
+// remoteIP returns the IP address of the given address, or nil if it does not have one, such as for a pipe
+func remoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	return net.ParseIP(host)
+}
+
+// InvalidAddrErr is returned when an address to ban is neither an IP address nor a network in CIDR notation
+type InvalidAddrErr struct {
+	Addr string
+}
+
+func (e *InvalidAddrErr) Error() string {
+	return fmt.Sprintf("invalid address %q", e.Addr)
+}
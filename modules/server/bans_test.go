@@ -0,0 +1,195 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBansAddr(t *testing.T) {
+	bans := server.NewBans()
+
+	err := bans.BanAddr("203.0.113.7", 0, "spamming")
+	assert.NoError(t, err)
+
+	err = bans.BanAddr("198.51.100.0/24", time.Hour, "abuse")
+	assert.NoError(t, err)
+
+	err = bans.BanAddr("2001:db8::/32", 0, "abuse")
+	assert.NoError(t, err)
+
+	err = bans.BanAddr("not an address", 0, "")
+	assert.IsType(t, &server.InvalidAddrErr{}, err)
+
+	ban, ok := bans.Addr(&net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 1234})
+	assert.True(t, ok)
+	assert.Equal(t, server.Ban{Reason: "spamming"}, ban)
+
+	ban, ok = bans.Addr(&net.TCPAddr{IP: net.ParseIP("198.51.100.200")})
+	assert.True(t, ok)
+	assert.Equal(t, "abuse", ban.Reason)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), ban.Expires, time.Minute)
+
+	_, ok = bans.Addr(&net.UDPAddr{IP: net.ParseIP("2001:db8::1")})
+	assert.True(t, ok)
+
+	_, ok = bans.Addr(&net.TCPAddr{IP: net.ParseIP("203.0.113.8")})
+	assert.False(t, ok)
+
+	// Addresses without an IP, such as those of pipes, are never banned
+	_, ok = bans.Addr(&net.UnixAddr{Name: "pipe"})
+	assert.False(t, ok)
+
+	err = bans.UnbanAddr("203.0.113.7")
+	assert.NoError(t, err)
+
+	_, ok = bans.Addr(&net.TCPAddr{IP: net.ParseIP("203.0.113.7")})
+	assert.False(t, ok)
+}
+
+func TestBansExpire(t *testing.T) {
+	bans := server.NewBans()
+
+	err := bans.BanAddr("203.0.113.7", time.Millisecond*20, "")
+	assert.NoError(t, err)
+	bans.BanIdentity("alice", time.Millisecond*20, "")
+
+	_, ok := bans.Addr(&net.TCPAddr{IP: net.ParseIP("203.0.113.7")})
+	assert.True(t, ok)
+	_, ok = bans.Identity("alice")
+	assert.True(t, ok)
+
+	time.Sleep(time.Millisecond * 30)
+
+	_, ok = bans.Addr(&net.TCPAddr{IP: net.ParseIP("203.0.113.7")})
+	assert.False(t, ok)
+	_, ok = bans.Identity("alice")
+	assert.False(t, ok)
+}
+
+func TestBansIdentity(t *testing.T) {
+	bans := server.NewBans()
+	bans.BanIdentity("alice", 0, "cheating")
+
+	ban, ok := bans.Identity("alice")
+	assert.True(t, ok)
+	assert.Equal(t, server.Ban{Reason: "cheating"}, ban)
+
+	_, ok = bans.Identity("bob")
+	assert.False(t, ok)
+
+	bans.UnbanIdentity("alice")
+	_, ok = bans.Identity("alice")
+	assert.False(t, ok)
+}
+
+func TestTCPServerBannedAddr(t *testing.T) {
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		t.Error("banned client should not have been admitted")
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	// The client may connect over IPv4 or IPv6 depending on the host
+	for _, addr := range []string{"127.0.0.0/8", "::1"} {
+		err = s.Bans().BanAddr(addr, 0, "go away")
+		assert.NoError(t, err)
+	}
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100)
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	if assert.IsType(t, &client.ConnectErr{}, err) {
+		assert.Equal(t, &common.DisconnectErr{Reason: common.CloseBanned, Message: "go away"}, err.(*client.ConnectErr).Err)
+	}
+}
+
+func TestTCPServerBannedIdentity(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {}, server.WithAuthenticator(server.NewSharedSecretAuthenticator("secret")))
+	assert.NoError(t, err)
+
+	s.Bans().BanIdentity("mallory", 0, "cheating")
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100, client.WithCredentials(common.Credentials{Username: "mallory", Secret: "secret"}))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	if assert.IsType(t, &client.ConnectErr{}, err) {
+		assert.Equal(t, &common.DisconnectErr{Reason: common.CloseBanned, Message: "cheating"}, err.(*client.ConnectErr).Err)
+	}
+	assert.Empty(t, connected)
+
+	c, err = client.NewTCPClient(100, client.WithCredentials(common.Credentials{Username: "alice", Secret: "secret"}))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+	defer c.Disconnect()
+	<-connected
+}
+
+func TestTCPServerKick(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	disconnected := make(chan error, 1)
+
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100)
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(context.Background())
+	}()
+
+	clientID := <-connected
+	err = s.Kick(clientID, "spamming")
+	assert.NoError(t, err)
+
+	select {
+	case err := <-runErr:
+		assert.Equal(t, &common.DisconnectErr{Reason: common.CloseKicked, Message: "spamming"}, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("client was not kicked")
+	}
+
+	select {
+	case err := <-disconnected:
+		assert.Equal(t, &server.KickedErr{Reason: "spamming"}, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("kicked client was not reported")
+	}
+
+	err = s.Kick(clientID, "")
+	assert.IsType(t, &server.InvalidClientID{}, err)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// WithAppVersion sets the application version the server sends clients in its hello
+func WithAppVersion(version string) Option {
+	return func(s *TCPServer) {
+		s.appVersion = version
+	}
+}
+
+// WithVersionCheck replaces the common.DefaultVersionCheck the server runs against every client's hello,
+// such as to turn away clients whose app version is too old. Rejected clients are sent a goodbye with
+// common.CloseVersionMismatch before they are admitted, and fail to connect with a *common.VersionMismatchErr.
+func WithVersionCheck(check common.VersionCheck) Option {
+	return func(s *TCPServer) {
+		s.versionCheck = check
+	}
+}
+
+// hello reads the client's hello and answers with the server's own if the server accepts the client's version,
+// followed by the IDs of the packet types registered by name
+func (s *TCPServer) hello(conn net.Conn, reader *common.FrameReader) (common.Hello, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	remote, err := reader.ReadHello()
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return common.Hello{}, err
+	}
+
+	if err := common.CheckVersion(s.versionCheck, remote); err != nil {
+		return common.Hello{}, err
+	}
+
+	local := common.Hello{Version: common.ProtocolVersion, AppVersion: s.appVersion}
+	if len(s.serializers) > 0 {
+		local.Features |= common.FeatureSerializers
+	}
+	if s.authenticator != nil {
+		local.Features |= common.FeatureAuth
+	}
+	if s.heartbeatInterval > 0 {
+		local.Features |= common.FeatureHeartbeat
+	}
+	if len(s.namedPackets) > 0 {
+		local.Features |= common.FeatureNamedPackets
+	}
+
+	if err := common.WriteHello(conn, local); err != nil {
+		return common.Hello{}, err
+	}
+
+	if err := s.sendPacketNames(conn); err != nil {
+		return common.Hello{}, err
+	}
+
+	return remote, nil
+}
+
+// rejectHandshake turns away a client that failed a step of being admitted with the close reason that matches the error
+func rejectHandshake(conn net.Conn, err error) {
+	switch err := err.(type) {
+	case *common.VersionMismatchErr:
+		reject(conn, common.CloseVersionMismatch, err.Message)
+	default:
+		reject(conn, common.CloseProtocolError, err.Error())
+	}
+}
+
+// Hello returns the hello the given client sent when it connected, which includes its app version
+func (s *TCPServer) Hello(clientID ClientID) (common.Hello, error) {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	c, ok := s.connections[clientID]
+	if !ok {
+		return common.Hello{}, &InvalidClientID{ClientID: clientID}
+	}
+
+	return c.hello, nil
+}
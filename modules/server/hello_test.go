@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func requireAppVersion(version string) common.VersionCheck {
+	return func(remote common.Hello) error {
+		if err := common.DefaultVersionCheck(remote); err != nil {
+			return err
+		}
+
+		if remote.AppVersion != version {
+			return errors.New("app version " + version + " is required")
+		}
+
+		return nil
+	}
+}
+
+func TestTCPServerVersionCheck(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {}, server.WithAppVersion("server 2"), server.WithVersionCheck(requireAppVersion("2")))
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100, client.WithAppVersion("1"))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	if assert.IsType(t, &client.ConnectErr{}, err) {
+		assert.Equal(t, &common.VersionMismatchErr{Message: "app version 2 is required"}, err.(*client.ConnectErr).Err)
+	}
+	assert.Empty(t, connected)
+
+	c, err = client.NewTCPClient(100, client.WithAppVersion("2"))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+	defer c.Disconnect()
+
+	hello, err := s.Hello(<-connected)
+	assert.NoError(t, err)
+	assert.Equal(t, common.Hello{Version: common.ProtocolVersion, AppVersion: "2"}, hello)
+
+	// The client can turn the server away too
+	c, err = client.NewTCPClient(100, client.WithAppVersion("2"), client.WithVersionCheck(requireAppVersion("server 3")))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	if assert.IsType(t, &client.ConnectErr{}, err) {
+		mismatch, ok := err.(*client.ConnectErr).Err.(*common.VersionMismatchErr)
+		if assert.True(t, ok) {
+			assert.Equal(t, "server 2", mismatch.Remote.AppVersion)
+		}
+	}
+}
+
+func TestTCPServerRejectsUnsupportedProtocolVersion(t *testing.T) {
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		t.Error("client should not have been admitted")
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	common.WriteHello(conn, common.Hello{Version: common.ProtocolVersion + 1})
+
+	frame, err := common.NewFrameReader(conn, 100).ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, common.ControlGoodbye, common.ControlType(frame.PacketID))
+
+	reason, _, err := common.ParseGoodbye(frame.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, common.CloseVersionMismatch, reason)
+
+	// A peer that does not say hello at all is not speaking the protocol
+	conn, err = net.Dial("tcp", s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	common.WriteFrame(conn, 1, []byte("hello"))
+
+	frame, err = common.NewFrameReader(conn, 100).ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, common.ControlGoodbye, common.ControlType(frame.PacketID))
+
+	reason, _, err = common.ParseGoodbye(frame.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, common.CloseProtocolError, reason)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// Rejection says why a connection was turned away before its client was admitted
+type Rejection uint8
+
+const (
+	// RejectBanned means the address of the connection or the identity of the client is banned
+	RejectBanned Rejection = iota
+	// RejectMaxClients means the server already had as many connections as WithMaxClients allows
+	RejectMaxClients
+	// RejectMaxConnectionsPerIP means the server already had as many connections
+	// from the same IP address as WithMaxConnectionsPerIP allows
+	RejectMaxConnectionsPerIP
+	// RejectAcceptRate means connections arrived faster than WithAcceptRate allows
+	RejectAcceptRate
+)
+
+func (r Rejection) String() string {
+	switch r {
+	case RejectBanned:
+		return "banned"
+	case RejectMaxClients:
+		return "too many clients"
+	case RejectMaxConnectionsPerIP:
+		return "too many connections from the same address"
+	case RejectAcceptRate:
+		return "too many new connections"
+	default:
+		return "unknown"
+	}
+}
+
+// RejectionCounts counts the connections a server turned away for each kind of Rejection
+type RejectionCounts struct {
+	Banned              uint64
+	MaxClients          uint64
+	MaxConnectionsPerIP uint64
+	AcceptRate          uint64
+}
+
+// WithMaxClients limits how many clients can be connected to the server at once. Connections whose
+// clients are still being admitted, such as those that are authenticating, count toward the limit.
+// Connections over the limit are turned away with common.CloseServerFull.
+func WithMaxClients(max int) Option {
+	return func(s *TCPServer) {
+		s.maxClients = max
+	}
+}
+
+// WithMaxConnectionsPerIP limits how many connections can come from the same IP address at once, counting
+// connections whose clients are still being admitted. Connections over the limit are turned away with
+// common.CloseServerFull. Connections from transports without IP addresses, such as pipes, are not limited.
+func WithMaxConnectionsPerIP(max int) Option {
+	return func(s *TCPServer) {
+		s.maxConnectionsPerIP = max
+	}
+}
+
+// WithAcceptRate limits how many connections the server accepts per second, allowing bursts of up to burst
+// connections at once. Connections over the limit are turned away with common.CloseServerFull.
+// Creating the server fails with an *InvalidAcceptRateErr if perSecond is not positive or burst is less than 1,
+// as such a limit would turn away every connection.
+func WithAcceptRate(perSecond float64, burst int) Option {
+	return func(s *TCPServer) {
+		if !(perSecond > 0) || burst < 1 {
+			s.optionErr = &InvalidAcceptRateErr{PerSecond: perSecond, Burst: burst}
+			return
+		}
+
+		s.acceptLimiter = &rateLimiter{rate: perSecond, burst: float64(burst)}
+	}
+}
+
+// InvalidAcceptRateErr is returned when creating a server with an accept rate that would turn away every connection
+type InvalidAcceptRateErr struct {
+	PerSecond float64
+	Burst     int
+}
+
+func (e *InvalidAcceptRateErr) Error() string {
+	return fmt.Sprintf("invalid accept rate of %g per second with bursts of %d, both must be positive", e.PerSecond, e.Burst)
+}
+
+// WithOnReject sets a callback to be called with the remote address of every connection the server turns away
+// because of a ban or a connection limit, and why. Together with TCPServer.Rejections, it helps tune the limits.
+func WithOnReject(onReject func(addr net.Addr, rejection Rejection)) Option {
+	return func(s *TCPServer) {
+		s.onReject = onReject
+	}
+}
+
+// rateLimiter is a token bucket that allows up to rate events per second, with bursts of up to burst events
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// allow reports whether an event that happened at the given time is within the limit
+func (l *rateLimiter) allow(now time.Time) bool {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
+
+// admit decides whether a connection that was just accepted may go on to be admitted. If it may, it is counted
+// toward the connection limits until release is called for it. Otherwise the rejection and the reason and
+// message to turn it away with are returned. connMutex must be held.
+func (s *TCPServer) admit(conn net.Conn) (Rejection, common.CloseReason, string, bool) {
+	if s.acceptLimiter != nil && !s.acceptLimiter.allow(time.Now()) {
+		return RejectAcceptRate, common.CloseServerFull, RejectAcceptRate.String(), false
+	}
+
+	if ban, ok := s.bans.Addr(conn.RemoteAddr()); ok {
+		return RejectBanned, common.CloseBanned, ban.Reason, false
+	}
+
+	if s.maxClients > 0 && s.connCount >= s.maxClients {
+		return RejectMaxClients, common.CloseServerFull, RejectMaxClients.String(), false
+	}
+
+	ip := remoteIP(conn.RemoteAddr())
+	if ip != nil && s.maxConnectionsPerIP > 0 && s.connsPerIP[ip.String()] >= s.maxConnectionsPerIP {
+		return RejectMaxConnectionsPerIP, common.CloseServerFull, RejectMaxConnectionsPerIP.String(), false
+	}
+
+	s.connCount++
+	if ip != nil {
+		s.connsPerIP[ip.String()]++
+	}
+
+	return 0, 0, "", true
+}
+
+// release stops counting a connection that was admitted toward the connection limits once it has ended
+func (s *TCPServer) release(conn net.Conn) {
+	s.connMutex.Lock()
+	defer s.connMutex.Unlock()
+
+	s.connCount--
+	if ip := remoteIP(conn.RemoteAddr()); ip != nil {
+		key := ip.String()
+		if s.connsPerIP[key]--; s.connsPerIP[key] <= 0 {
+			delete(s.connsPerIP, key)
+		}
+	}
+}
+
+// refuse turns away a connection before its client is admitted, counting the rejection and reporting it to onReject
+func (s *TCPServer) refuse(conn net.Conn, rejection Rejection, reason common.CloseReason, message string) {
+	s.connMutex.Lock()
+	switch rejection {
+	case RejectBanned:
+		s.rejections.Banned++
+	case RejectMaxClients:
+		s.rejections.MaxClients++
+	case RejectMaxConnectionsPerIP:
+		s.rejections.MaxConnectionsPerIP++
+	case RejectAcceptRate:
+		s.rejections.AcceptRate++
+	}
+	s.connMutex.Unlock()
+
+	if s.onReject != nil {
+		s.onReject(conn.RemoteAddr(), rejection)
+	}
+
+	reject(conn, reason, message)
+}
+
+// Rejections returns how many connections the server has turned away because of bans and connection limits
+func (s *TCPServer) Rejections() RejectionCounts {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	return s.rejections
+}
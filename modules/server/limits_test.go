@@ -0,0 +1,141 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// startLimitedServer starts a server with the given limits that reports the connections it turns away to rejected
+func startLimitedServer(t *testing.T, rejected chan<- server.Rejection, opts ...server.Option) *server.TCPServer {
+	opts = append(opts, server.WithOnReject(func(addr net.Addr, rejection server.Rejection) {
+		rejected <- rejection
+	}))
+
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {}, opts...)
+	assert.NoError(t, err)
+	t.Cleanup(s.Stop)
+
+	go s.Start("0")
+	time.Sleep(time.Millisecond * 10)
+
+	return s
+}
+
+// connectClient connects a new client to the server and returns it, along with the error connecting failed with
+func connectClient(t *testing.T, s *server.TCPServer) (*client.TCPClient, error) {
+	c, err := client.NewTCPClient(100)
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	if err == nil {
+		t.Cleanup(func() { c.Disconnect() })
+	}
+
+	return c, err
+}
+
+// assertServerFull checks that connecting failed because the server reached a connection limit
+func assertServerFull(t *testing.T, err error, message string) {
+	if assert.IsType(t, &client.ConnectErr{}, err) {
+		assert.Equal(t, &common.DisconnectErr{Reason: common.CloseServerFull, Message: message}, err.(*client.ConnectErr).Err)
+	}
+}
+
+func TestTCPServerMaxClients(t *testing.T) {
+	rejected := make(chan server.Rejection, 10)
+	s := startLimitedServer(t, rejected, server.WithMaxClients(2))
+
+	_, err := connectClient(t, s)
+	assert.NoError(t, err)
+
+	c, err := connectClient(t, s)
+	assert.NoError(t, err)
+
+	_, err = connectClient(t, s)
+	assertServerFull(t, err, "too many clients")
+	assert.Equal(t, server.RejectMaxClients, <-rejected)
+	assert.Equal(t, server.RejectionCounts{MaxClients: 1}, s.Rejections())
+
+	// A client leaving makes room for another
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		c, err := client.NewTCPClient(100)
+		assert.NoError(t, err)
+
+		if err := c.Connect(s.Addr().String()); err != nil {
+			<-rejected
+			return false
+		}
+
+		c.Disconnect()
+		return true
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestTCPServerMaxConnectionsPerIP(t *testing.T) {
+	rejected := make(chan server.Rejection, 10)
+	s := startLimitedServer(t, rejected, server.WithMaxConnectionsPerIP(1))
+
+	_, err := connectClient(t, s)
+	assert.NoError(t, err)
+
+	_, err = connectClient(t, s)
+	assertServerFull(t, err, "too many connections from the same address")
+	assert.Equal(t, server.RejectMaxConnectionsPerIP, <-rejected)
+	assert.Equal(t, server.RejectionCounts{MaxConnectionsPerIP: 1}, s.Rejections())
+}
+
+func TestTCPServerAcceptRate(t *testing.T) {
+	rejected := make(chan server.Rejection, 10)
+	s := startLimitedServer(t, rejected, server.WithAcceptRate(10, 2))
+
+	for i := 0; i < 2; i++ {
+		_, err := connectClient(t, s)
+		assert.NoError(t, err)
+	}
+
+	_, err := connectClient(t, s)
+	assertServerFull(t, err, "too many new connections")
+	assert.Equal(t, server.RejectAcceptRate, <-rejected)
+
+	// The limit lets another connection through after a tenth of a second
+	time.Sleep(time.Millisecond * 150)
+
+	_, err = connectClient(t, s)
+	assert.NoError(t, err)
+	assert.Equal(t, server.RejectionCounts{AcceptRate: 1}, s.Rejections())
+}
+
+func TestNewTCPServerInvalidAcceptRate(t *testing.T) {
+	for _, rate := range []struct {
+		perSecond float64
+		burst     int
+	}{{10, 0}, {0, 2}, {-1, 2}} {
+		s, err := server.NewTCPServer(100, nil, nil, server.WithAcceptRate(rate.perSecond, rate.burst))
+		assert.Nil(t, s)
+		assert.Equal(t, &server.InvalidAcceptRateErr{PerSecond: rate.perSecond, Burst: rate.burst}, err)
+	}
+}
+
+func TestTCPServerRejectionsCountBans(t *testing.T) {
+	rejected := make(chan server.Rejection, 10)
+	s := startLimitedServer(t, rejected)
+
+	for _, addr := range []string{"127.0.0.0/8", "::1"} {
+		err := s.Bans().BanAddr(addr, 0, "go away")
+		assert.NoError(t, err)
+	}
+
+	_, err := connectClient(t, s)
+	assert.Error(t, err)
+	assert.Equal(t, server.RejectBanned, <-rejected)
+	assert.Equal(t, server.RejectionCounts{Banned: 1}, s.Rejections())
+}
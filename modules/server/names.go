@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// RegisterNamedPacketType is like RegisterPacketType, but identifies the packet type by name instead of by its ID,
+// so packet types defined by different packages never have to agree on their IDs. The packet type's ID method must
+// return common.NamedPacketID. The server assigns each name an ID in the order the names are registered and sends
+// the names along with their IDs to every client that connects, so all packet types must be registered before the
+// server starts. Names are best namespaced by the package that defines the packet type, such as "chat.message".
+func (s *TCPServer) RegisterNamedPacketType(name string, p common.Packet, receiveCallback func(clientID ClientID, conn net.Conn, p common.Packet)) error {
+	if p.ID() != common.NamedPacketID {
+		return &common.InvalidPacketIDErr{PacketID: p.ID()}
+	}
+
+	if _, ok := s.namedPackets[name]; ok {
+		return &common.PacketNameRegisteredErr{Name: name}
+	}
+
+	packetType := common.PacketType(p)
+	if _, ok := s.namedTypes[packetType]; ok {
+		return &common.PacketRegisteredErr{PacketID: p.ID()}
+	}
+
+	packetID := common.FirstNamedPacketID + uint16(len(s.namedPackets))
+	if packetID == common.NamedPacketID {
+		return &common.InvalidPacketIDErr{PacketID: packetID}
+	}
+
+	s.namedPackets[name] = packetID
+	s.namedTypes[packetType] = packetID
+	s.registeredPackets[packetID] = struct {
+		packet   common.Packet
+		callback func(clientID ClientID, conn net.Conn, p common.Packet)
+	}{packet: p, callback: receiveCallback}
+
+	return nil
+}
+
+// packetID returns the ID the packet is sent with, which is the ID the server assigned to its name if it is a named packet
+func (s *TCPServer) packetID(p common.Packet) (uint16, error) {
+	packetID := p.ID()
+	if packetID != common.NamedPacketID {
+		return packetID, nil
+	}
+
+	packetID, ok := s.namedTypes[common.PacketType(p)]
+	if !ok {
+		return 0, &common.PacketTypeNotRegisteredErr{Type: common.PacketType(p).String()}
+	}
+
+	return packetID, nil
+}
+
+// sendPacketNames tells a client that was just greeted which IDs the server assigned to its named packet types
+func (s *TCPServer) sendPacketNames(conn net.Conn) error {
+	if len(s.namedPackets) == 0 {
+		return nil
+	}
+
+	return common.WriteControlFrame(conn, common.ControlPacketNames, common.EncodePacketNames(s.namedPackets))
+}
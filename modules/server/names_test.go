@@ -0,0 +1,140 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type chatPacket struct {
+	Text string
+}
+
+func (p chatPacket) ID() uint16 {
+	return common.NamedPacketID
+}
+
+type emotePacket struct {
+	Emote string
+}
+
+func (p emotePacket) ID() uint16 {
+	return common.NamedPacketID
+}
+
+func TestTCPServerNamedPackets(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	received := make(chan string, 1)
+
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	err = s.RegisterNamedPacketType("plugin.emote", &emotePacket{}, nil)
+	assert.NoError(t, err)
+
+	err = s.RegisterNamedPacketType("chat.message", &chatPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received <- p.(*chatPacket).Text
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	clientReceived := make(chan string, 1)
+	c, err := client.NewTCPClient(100)
+	assert.NoError(t, err)
+
+	// The client registers its packet types in a different order than the server
+	err = c.RegisterNamedPacketType("chat.message", &chatPacket{}, func(conn net.Conn, p common.Packet) {
+		clientReceived <- p.(*chatPacket).Text
+	})
+	assert.NoError(t, err)
+
+	err = c.RegisterNamedPacketType("plugin.emote", &emotePacket{}, nil)
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+	defer c.Disconnect()
+
+	clientID := <-connected
+	go c.ReceivePacket()
+
+	err = c.SendPacket(chatPacket{Text: "hello"})
+	assert.NoError(t, err)
+
+	select {
+	case text := <-received:
+		assert.Equal(t, "hello", text)
+	case <-time.After(time.Second):
+		t.Fatal("server did not receive the packet")
+	}
+
+	err = s.SendPacket(clientID, &chatPacket{Text: "welcome"})
+	assert.NoError(t, err)
+
+	select {
+	case text := <-clientReceived:
+		assert.Equal(t, "welcome", text)
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive the packet")
+	}
+}
+
+func TestTCPServerUnknownPacketName(t *testing.T) {
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	err = s.RegisterNamedPacketType("chat.message", &chatPacket{}, nil)
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100)
+	assert.NoError(t, err)
+
+	err = c.RegisterNamedPacketType("plugin.emote", &emotePacket{}, nil)
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+	defer c.Disconnect()
+
+	err = c.SendPacket(emotePacket{Emote: "wave"})
+	if assert.IsType(t, &common.UnknownPacketNameErr{}, err) {
+		assert.Equal(t, "plugin.emote", err.(*common.UnknownPacketNameErr).Name)
+	}
+
+	err = c.SendPacket(chatPacket{Text: "hello"})
+	assert.IsType(t, &common.PacketTypeNotRegisteredErr{}, err)
+}
+
+func TestTCPServerRegisterNamedPacketTypeErrors(t *testing.T) {
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	err = s.RegisterNamedPacketType("chat.message", &chatPacket{}, nil)
+	assert.NoError(t, err)
+
+	err = s.RegisterNamedPacketType("chat.message", &emotePacket{}, nil)
+	assert.IsType(t, &common.PacketNameRegisteredErr{}, err)
+
+	err = s.RegisterNamedPacketType("chat.text", chatPacket{}, nil)
+	assert.IsType(t, &common.PacketRegisteredErr{}, err)
+
+	err = s.RegisterNamedPacketType("test", &TestPacket{}, nil)
+	assert.IsType(t, &common.InvalidPacketIDErr{}, err)
+
+	err = s.RegisterPacketType(&chatPacket{}, nil)
+	assert.IsType(t, &common.InvalidPacketIDErr{}, err)
+}
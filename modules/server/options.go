@@ -0,0 +1,17 @@
+package server
+
+import "time"
+
+// Option configures optional behavior of a server
+type Option func(s *TCPServer)
+
+// WithHeartbeat makes the server ping every client at the given interval and disconnect any client
+// it has not heard from within idleTimeout, reporting a *common.TimeoutErr to onClientDisconnected.
+// Clients answer pings on their own while they are receiving packets, so idleTimeout should be a few
+// intervals long. An interval of 0 disables pinging while still enforcing the idle timeout.
+func WithHeartbeat(interval time.Duration, idleTimeout time.Duration) Option {
+	return func(s *TCPServer) {
+		s.heartbeatInterval = interval
+		s.idleTimeout = idleTimeout
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+var _ Server = (*PipeServer)(nil)
+
+// PipeServer is a server that communicates with clients over in-memory pipes
+// instead of sockets. It behaves exactly like a TCPServer, which makes it useful
+// for unit testing packet handlers together with a client.PipeClient.
+type PipeServer struct {
+	*TCPServer
+}
+
+// NewPipeServer returns an initialized pipe server. When started, the port
+// passed to Start is used as the name a client.PipeClient connects to.
+func NewPipeServer(maxPacketSize int, onClientConnected func(clientID ClientID), onClientDisconnected func(clientID ClientID, err error), opts ...Option) (*PipeServer, error) {
+	s, err := NewTCPServer(maxPacketSize, onClientConnected, onClientDisconnected, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.listen = listenPipe
+	return &PipeServer{TCPServer: s}, nil
+}
+
+func listenPipe(name string) (net.Listener, error) {
+	return common.ListenPipe(name)
+}
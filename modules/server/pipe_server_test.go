@@ -0,0 +1,75 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeServerListenFailure(t *testing.T) {
+	s1, err := server.NewPipeServer(10, nil, nil)
+	assert.NoError(t, err)
+
+	go s1.Start("pipe-in-use")
+	time.Sleep(time.Millisecond * 10)
+	defer s1.Stop()
+
+	s2, err := server.NewPipeServer(10, nil, nil)
+	assert.NoError(t, err)
+
+	err = s2.Start("pipe-in-use")
+	assert.IsType(t, &server.ListenErr{}, err)
+}
+
+func TestPipeServerAndClient(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	received := make(chan server.ClientID, 1)
+
+	s, err := server.NewPipeServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&TestPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received <- clientID
+	})
+	assert.NoError(t, err)
+
+	go s.Start("pipe-test")
+	time.Sleep(time.Millisecond * 10)
+	defer s.Stop()
+
+	assert.Equal(t, "pipe-test", s.Addr().String())
+
+	c, err := client.NewPipeClient(10)
+	assert.NoError(t, err)
+
+	clientReceived := make(chan struct{}, 1)
+	err = c.RegisterPacketType(&TestPacket{}, func(conn net.Conn, p common.Packet) {
+		clientReceived <- struct{}{}
+	})
+	assert.NoError(t, err)
+
+	err = c.Connect("pipe-test")
+	assert.NoError(t, err)
+
+	clientID := <-connected
+
+	err = c.SendPacket(&TestPacket{})
+	assert.NoError(t, err)
+	assert.Equal(t, clientID, <-received)
+
+	go func() {
+		err := s.SendPacket(clientID, &TestPacket{})
+		assert.NoError(t, err)
+	}()
+
+	err = c.ReceivePacket()
+	assert.NoError(t, err)
+	<-clientReceived
+}
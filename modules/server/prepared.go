@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// PreparedPacket is a packet that is encoded once and then sent as is to any number of clients,
+// instead of being encoded again for each of them. It is encoded once for each serializer negotiated
+// by the clients it is sent to, the first time it is sent to one of them. A PreparedPacket can be passed
+// anywhere a packet is sent, and BroadcastPacket and BroadcastToRoom prepare the packets they are given.
+// The packet it was prepared from must not be modified afterwards.
+type PreparedPacket struct {
+	packet        common.Packet
+	packetID      uint16
+	maxPacketSize int
+
+	frames []preparedFrame
+	mutex  sync.Mutex
+}
+
+// preparedFrame is a prepared packet encoded by a single serializer
+type preparedFrame struct {
+	serializer string
+	frame      []byte
+	err        error
+}
+
+// PreparePacket prepares a packet to be sent to many clients of the server
+func (s *TCPServer) PreparePacket(p common.Packet) (*PreparedPacket, error) {
+	if prepared, ok := p.(*PreparedPacket); ok {
+		return prepared, nil
+	}
+
+	packetID, err := s.packetID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PreparedPacket{
+		packet:        p,
+		packetID:      packetID,
+		maxPacketSize: s.maxPacketSize,
+	}, nil
+}
+
+// ID returns the ID of the packet that was prepared
+func (p *PreparedPacket) ID() uint16 {
+	return p.packet.ID()
+}
+
+// Packet returns the packet that was prepared
+func (p *PreparedPacket) Packet() common.Packet {
+	return p.packet
+}
+
+// frame returns the packet encoded by the given serializer, encoding it if this is the first time it is needed.
+// The frame is shared by every client it is sent to, so it must not be modified.
+func (p *PreparedPacket) frame(s common.Serializer) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, f := range p.frames {
+		if f.serializer == s.Name() {
+			return f.frame, f.err
+		}
+	}
+
+	f := preparedFrame{serializer: s.Name()}
+
+	buffer, err := common.EncodeFrame(common.FrameHeader{PacketID: p.packetID}, p.packet, s, p.maxPacketSize)
+	if err != nil {
+		f.err = err
+	} else {
+		f.frame = append([]byte(nil), *buffer...)
+		common.PutBuffer(buffer)
+	}
+
+	p.frames = append(p.frames, f)
+	return f.frame, f.err
+}
@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPServerPreparedPacket(t *testing.T) {
+	connected := make(chan server.ClientID, 2)
+
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {}, server.WithSerializers(common.DefaultCodec, common.JSONSerializer{}))
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&greetingPacket{}, nil)
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	// The clients negotiate different serializers, so each of them needs its own encoding of the packet
+	received := make(chan string, 4)
+	for _, opts := range [][]client.Option{nil, {client.WithSerializers(common.JSONSerializer{})}} {
+		c, err := client.NewTCPClient(100, opts...)
+		assert.NoError(t, err)
+
+		err = c.RegisterPacketType(&greetingPacket{}, func(conn net.Conn, p common.Packet) {
+			received <- p.(*greetingPacket).Text
+		})
+		assert.NoError(t, err)
+
+		err = c.Connect(s.Addr().String())
+		assert.NoError(t, err)
+		defer c.Disconnect()
+		go c.Run(context.Background())
+
+		<-connected
+	}
+
+	p, err := s.PreparePacket(&greetingPacket{Text: "hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(2), p.ID())
+	assert.Equal(t, &greetingPacket{Text: "hello"}, p.Packet())
+
+	assert.Nil(t, s.BroadcastPacket(p, -1))
+	assert.Nil(t, s.BroadcastPacket(p, -1))
+
+	for i := 0; i < 4; i++ {
+		select {
+		case text := <-received:
+			assert.Equal(t, "hello", text)
+		case <-time.After(time.Second):
+			t.Fatal("packet was not received")
+		}
+	}
+
+	// Preparing a prepared packet gives it back as is
+	prepared, err := s.PreparePacket(p)
+	assert.NoError(t, err)
+	assert.Same(t, p, prepared)
+}
+
+func TestTCPServerPreparedPacketErrors(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	clientID := <-connected
+
+	_, err = s.PreparePacket(&chatPacket{Text: "hello"})
+	assert.IsType(t, &common.PacketTypeNotRegisteredErr{}, err)
+
+	errs := s.BroadcastPacket(&chatPacket{Text: "hello"}, -1)
+	if assert.Len(t, errs, 1) {
+		assert.IsType(t, &common.PacketTypeNotRegisteredErr{}, errs[clientID])
+	}
+
+	// The packet is too large for the server, which is only found out once it is encoded
+	p, err := s.PreparePacket(&greetingPacket{Text: "hello, this is too long"})
+	assert.NoError(t, err)
+
+	errs = s.BroadcastPacket(p, -1)
+	if assert.Len(t, errs, 1) {
+		assert.Error(t, errs[clientID])
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// WithOnJoin sets a callback that is called whenever a client joins a room
+func WithOnJoin(onJoin func(clientID ClientID, room string)) Option {
+	return func(s *TCPServer) {
+		s.onJoin = onJoin
+	}
+}
+
+// WithOnLeave sets a callback that is called whenever a client leaves a room,
+// including when it disconnects while still in the room
+func WithOnLeave(onLeave func(clientID ClientID, room string)) Option {
+	return func(s *TCPServer) {
+		s.onLeave = onLeave
+	}
+}
+
+// Join adds the client to the given room, creating the room if it does not exist yet.
+// Joining a room the client is already in does nothing.
+func (s *TCPServer) Join(clientID ClientID, room string) error {
+	s.connMutex.Lock()
+	c, ok := s.connections[clientID]
+	if !ok {
+		s.connMutex.Unlock()
+		return &InvalidClientID{ClientID: clientID}
+	}
+
+	if _, ok := c.rooms[room]; ok {
+		s.connMutex.Unlock()
+		return nil
+	}
+
+	members, ok := s.rooms[room]
+	if !ok {
+		members = make(map[ClientID]struct{})
+		s.rooms[room] = members
+	}
+
+	members[clientID] = struct{}{}
+	c.rooms[room] = struct{}{}
+	s.connMutex.Unlock()
+
+	if s.onJoin != nil {
+		s.onJoin(clientID, room)
+	}
+
+	return nil
+}
+
+// Leave removes the client from the given room. A room is removed once its last member leaves.
+// Leaving a room the client is not in does nothing.
+func (s *TCPServer) Leave(clientID ClientID, room string) error {
+	s.connMutex.Lock()
+	c, ok := s.connections[clientID]
+	if !ok {
+		s.connMutex.Unlock()
+		return &InvalidClientID{ClientID: clientID}
+	}
+
+	if _, ok := c.rooms[room]; !ok {
+		s.connMutex.Unlock()
+		return nil
+	}
+
+	s.removeFromRoom(c, room)
+	s.connMutex.Unlock()
+
+	if s.onLeave != nil {
+		s.onLeave(clientID, room)
+	}
+
+	return nil
+}
+
+// leaveAll removes a disconnecting client from every room it is in
+func (s *TCPServer) leaveAll(c *connection) {
+	s.connMutex.Lock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+		s.removeFromRoom(c, room)
+	}
+	s.connMutex.Unlock()
+
+	if s.onLeave != nil {
+		for _, room := range rooms {
+			s.onLeave(c.id, room)
+		}
+	}
+}
+
+// removeFromRoom removes the client from the room. connMutex must be held.
+func (s *TCPServer) removeFromRoom(c *connection, room string) {
+	delete(c.rooms, room)
+
+	members := s.rooms[room]
+	delete(members, c.id)
+	if len(members) == 0 {
+		delete(s.rooms, room)
+	}
+}
+
+// Members returns the IDs of the clients in the given room in ascending order
+func (s *TCPServer) Members(room string) []ClientID {
+	s.connMutex.RLock()
+	members := make([]ClientID, 0, len(s.rooms[room]))
+	for clientID := range s.rooms[room] {
+		members = append(members, clientID)
+	}
+	s.connMutex.RUnlock()
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i] < members[j]
+	})
+
+	return members
+}
+
+// BroadcastToRoom sends a packet to every client in the given room, except for the given clients.
+// It returns the errors sending to each client failed with, or nil if the packet was sent to every client.
+func (s *TCPServer) BroadcastToRoom(room string, p common.Packet, clientIDsToExclude ...ClientID) map[ClientID]error {
+	excluded := make(map[ClientID]struct{}, len(clientIDsToExclude))
+	for _, clientID := range clientIDsToExclude {
+		excluded[clientID] = struct{}{}
+	}
+
+	members := s.Members(room)
+	clientIDs := members[:0]
+	for _, clientID := range members {
+		if _, ok := excluded[clientID]; !ok {
+			clientIDs = append(clientIDs, clientID)
+		}
+	}
+
+	return s.Multicast(p, clientIDs)
+}
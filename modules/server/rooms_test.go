@@ -0,0 +1,119 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type roomEvent struct {
+	joined   bool
+	clientID server.ClientID
+	room     string
+}
+
+func TestTCPServerRooms(t *testing.T) {
+	connected := make(chan server.ClientID, 3)
+	events := make(chan roomEvent, 10)
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {},
+		server.WithOnJoin(func(clientID server.ClientID, room string) {
+			events <- roomEvent{joined: true, clientID: clientID, room: room}
+		}),
+		server.WithOnLeave(func(clientID server.ClientID, room string) {
+			events <- roomEvent{joined: false, clientID: clientID, room: room}
+		}),
+	)
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conns := make(map[server.ClientID]net.Conn)
+	for i := 0; i < 3; i++ {
+		conn, err := dial(s.Addr().String())
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		conns[<-connected] = conn
+	}
+
+	for clientID := range conns {
+		err = s.Join(clientID, "lobby")
+		assert.NoError(t, err)
+		assert.Equal(t, roomEvent{joined: true, clientID: clientID, room: "lobby"}, <-events)
+	}
+
+	// Joining twice does nothing
+	err = s.Join(0, "lobby")
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	err = s.Join(10, "lobby")
+	assert.IsType(t, &server.InvalidClientID{}, err)
+
+	assert.Equal(t, []server.ClientID{0, 1, 2}, s.Members("lobby"))
+	assert.Empty(t, s.Members("empty"))
+
+	s.BroadcastToRoom("lobby", &TestPacket{}, 0)
+
+	for _, clientID := range []server.ClientID{1, 2} {
+		conns[clientID].SetReadDeadline(time.Now().Add(time.Second * 5))
+		frame, err := common.NewFrameReader(conns[clientID], 10).ReadFrame()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("test data"), frame.Payload)
+	}
+
+	err = s.Leave(1, "lobby")
+	assert.NoError(t, err)
+	assert.Equal(t, roomEvent{joined: false, clientID: 1, room: "lobby"}, <-events)
+	assert.Equal(t, []server.ClientID{0, 2}, s.Members("lobby"))
+
+	// Disconnecting leaves every room
+	conns[2].Close()
+	assert.Equal(t, roomEvent{joined: false, clientID: 2, room: "lobby"}, <-events)
+	assert.Equal(t, []server.ClientID{0}, s.Members("lobby"))
+}
+
+func TestTCPServerJoinWhileDisconnecting(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	joinErr := make(chan error, 1)
+
+	var s *server.TCPServer
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {
+		joinErr <- s.Join(clientID, "lobby")
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+
+	clientID := <-connected
+	err = s.Join(clientID, "lobby")
+	assert.NoError(t, err)
+
+	conn.Close()
+
+	select {
+	case err := <-joinErr:
+		assert.Equal(t, &server.InvalidClientID{ClientID: clientID}, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("client did not disconnect")
+	}
+
+	// The client is not left behind in the room
+	assert.Empty(t, s.Members("lobby"))
+}
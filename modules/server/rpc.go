@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// WithMaxRequestHandlers limits how many handlers for the requests of a single client may run at once, which
+// defaults to common.DefaultMaxHandlers. Requests that arrive while a client is at the limit are not handled,
+// and the client's Call fails with a *common.CallErr with common.CallErrBusy.
+func WithMaxRequestHandlers(max int) Option {
+	return func(s *TCPServer) {
+		s.maxHandlers = max
+	}
+}
+
+// HandleRequest sets the handler that answers requests of the given type sent by a client's Call.
+// The packet type must already be registered, and its receive callback is not called for requests.
+// The handler runs on its own goroutine, so several requests from the same client can be handled at once.
+// Its context is cancelled if the client gives up on the call or disconnects. The response it returns is
+// sent back to the caller, which must have registered the response's type. If it fails instead, the caller's
+// Call returns a *common.CallErr: the handler's own if it returned one, or one with common.CallErrInternal.
+func (s *TCPServer) HandleRequest(req common.Packet, handler func(ctx context.Context, clientID ClientID, req common.Packet) (common.Packet, error)) error {
+	packetID, err := s.packetID(req)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.registeredPackets[packetID]; !ok {
+		return &common.PacketNotRegisteredErr{PacketID: packetID}
+	}
+
+	packetType := common.PacketType(req)
+	if _, ok := s.requestHandlers[packetType]; ok {
+		return &common.HandlerRegisteredErr{PacketID: packetID}
+	}
+
+	s.requestHandlers[packetType] = handler
+	return nil
+}
+
+// Call sends a request to the given client and waits for the response of the handler the client set with HandleRequest.
+// The type of the response must be registered. Call gives up once the context ends, and fails with a *common.CallErr
+// if the client's handler failed, or with the error the connection ended with if the client disconnects first.
+func (s *TCPServer) Call(ctx context.Context, clientID ClientID, req common.Packet) (common.Packet, error) {
+	s.connMutex.RLock()
+	c, ok := s.connections[clientID]
+	s.connMutex.RUnlock()
+	if !ok {
+		return nil, &InvalidClientID{ClientID: clientID}
+	}
+
+	return c.calls.Call(ctx, frameWriter{s: s, c: c}, func(callID uint32) error {
+		return s.sendPacket(clientID, req, common.FrameHeader{Flags: common.FlagRequest, CallID: callID})
+	})
+}
+
+// handleRequest runs the handler for a request received from a client and answers the client with its result.
+// A *common.ProtocolErr is returned if the client reused the call ID of a request that is still being handled.
+func (s *TCPServer) handleRequest(c *connection, frame common.Frame, req common.Packet) error {
+	ctx, done, err := c.calls.Handle(frame.CallID)
+	if err != nil {
+		if callErr, ok := err.(*common.CallErr); ok {
+			common.WriteCallErr(frameWriter{s: s, c: c}, frame.CallID, callErr)
+			return nil
+		}
+
+		return err
+	}
+
+	handler, ok := s.requestHandlers[common.PacketType(req)]
+	if !ok {
+		done()
+		common.WriteCallErr(frameWriter{s: s, c: c}, frame.CallID, &common.CallErr{
+			Code:    common.CallErrUnhandled,
+			Message: fmt.Sprintf("no handler for packet with ID %d", frame.PacketID),
+		})
+		return nil
+	}
+
+	go func() {
+		defer done()
+
+		resp, err := handler(ctx, c.id, req)
+		if err == nil && resp == nil {
+			err = errors.New("handler returned no response")
+		}
+
+		if err == nil {
+			err = s.sendPacket(c.id, resp, common.FrameHeader{Flags: common.FlagResponse, CallID: frame.CallID})
+		}
+
+		if err != nil {
+			common.WriteCallErr(frameWriter{s: s, c: c}, frame.CallID, err)
+		}
+	}()
+
+	return nil
+}
+
+// handleCallErr hands the error a client answered a call with to the call waiting on it
+func (s *TCPServer) handleCallErr(c *connection, frame common.Frame) error {
+	callErr, err := common.ParseCallErr(frame.Payload)
+	if err != nil {
+		return &common.ProtocolErr{Err: err}
+	}
+
+	c.calls.Resolve(frame.CallID, common.CallResult{Err: callErr})
+	return nil
+}
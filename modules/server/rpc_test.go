@@ -0,0 +1,286 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type lookupRequest struct {
+	Name string
+}
+
+func (p lookupRequest) ID() uint16 {
+	return 10
+}
+
+type lookupResponse struct {
+	Name  string
+	Found bool
+}
+
+func (p lookupResponse) ID() uint16 {
+	return 11
+}
+
+// startRPCServer starts a server with the given options that answers lookup requests with the given handler
+// and connects a running client to it
+func startRPCServer(t *testing.T, handler func(ctx context.Context, clientID server.ClientID, req common.Packet) (common.Packet, error), opts ...server.Option) (*server.TCPServer, *client.TCPClient, server.ClientID) {
+	connected := make(chan server.ClientID, 1)
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {}, opts...)
+	assert.NoError(t, err)
+
+	for _, p := range []common.Packet{&lookupRequest{}, &lookupResponse{}} {
+		err = s.RegisterPacketType(p, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+			t.Error("receive callbacks should not be called for calls")
+		})
+		assert.NoError(t, err)
+	}
+
+	err = s.HandleRequest(&lookupRequest{}, handler)
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100)
+	assert.NoError(t, err)
+
+	for _, p := range []common.Packet{&lookupRequest{}, &lookupResponse{}} {
+		err = c.RegisterPacketType(p, func(conn net.Conn, p common.Packet) {
+			t.Error("receive callbacks should not be called for calls")
+		})
+		assert.NoError(t, err)
+	}
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+	go c.Run(context.Background())
+
+	return s, c, <-connected
+}
+
+func TestTCPServerHandleRequest(t *testing.T) {
+	s, c, _ := startRPCServer(t, func(ctx context.Context, clientID server.ClientID, req common.Packet) (common.Packet, error) {
+		name := req.(*lookupRequest).Name
+		switch name {
+		case "banned":
+			return nil, &common.CallErr{Code: 300, Message: "banned"}
+		case "broken":
+			return nil, errors.New("lookup failed")
+		}
+
+		// Answer the first call last to show responses are matched to their calls
+		if name == "alice" {
+			time.Sleep(time.Millisecond * 50)
+		}
+
+		return &lookupResponse{Name: name, Found: true}, nil
+	})
+	defer s.Stop()
+	defer c.Disconnect()
+
+	results := make(chan string, 2)
+	for _, name := range []string{"alice", "bob"} {
+		go func(name string) {
+			resp, err := c.Call(context.Background(), &lookupRequest{Name: name})
+			if assert.NoError(t, err) {
+				results <- resp.(*lookupResponse).Name
+			}
+		}(name)
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	assert.Equal(t, "bob", <-results)
+	assert.Equal(t, "alice", <-results)
+
+	_, err := c.Call(context.Background(), &lookupRequest{Name: "banned"})
+	assert.Equal(t, &common.CallErr{Code: 300, Message: "banned"}, err)
+
+	_, err = c.Call(context.Background(), &lookupRequest{Name: "broken"})
+	assert.Equal(t, &common.CallErr{Code: common.CallErrInternal, Message: "lookup failed"}, err)
+
+	// The server registered the response type, but has no handler for it
+	_, err = c.Call(context.Background(), &lookupResponse{})
+	if assert.IsType(t, &common.CallErr{}, err) {
+		assert.Equal(t, common.CallErrUnhandled, err.(*common.CallErr).Code)
+	}
+}
+
+func TestTCPServerCallCancelled(t *testing.T) {
+	cancelled := make(chan struct{})
+	s, c, _ := startRPCServer(t, func(ctx context.Context, clientID server.ClientID, req common.Packet) (common.Packet, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	defer s.Stop()
+	defer c.Disconnect()
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancelFunc()
+
+	_, err := c.Call(ctx, &lookupRequest{Name: "slow"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("handler was not cancelled")
+	}
+}
+
+func TestTCPServerMaxRequestHandlers(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s, c, _ := startRPCServer(t, func(ctx context.Context, clientID server.ClientID, req common.Packet) (common.Packet, error) {
+		started <- struct{}{}
+		<-release
+		return &lookupResponse{Name: req.(*lookupRequest).Name}, nil
+	}, server.WithMaxRequestHandlers(1))
+	defer s.Stop()
+	defer c.Disconnect()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.Call(context.Background(), &lookupRequest{Name: "alice"})
+		result <- err
+	}()
+	<-started
+
+	_, err := c.Call(context.Background(), &lookupRequest{Name: "bob"})
+	if assert.IsType(t, &common.CallErr{}, err) {
+		assert.Equal(t, common.CallErrBusy, err.(*common.CallErr).Code)
+	}
+
+	close(release)
+	assert.NoError(t, <-result)
+
+	// The handler that returned makes room for another
+	resp, err := c.Call(context.Background(), &lookupRequest{Name: "bob"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &lookupResponse{Name: "bob"}, resp)
+	}
+}
+
+func TestTCPServerDuplicateCallID(t *testing.T) {
+	release := make(chan struct{})
+	disconnected := make(chan error, 1)
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	})
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&lookupRequest{}, nil)
+	assert.NoError(t, err)
+
+	err = s.HandleRequest(&lookupRequest{}, func(ctx context.Context, clientID server.ClientID, req common.Packet) (common.Packet, error) {
+		<-release
+		return nil, ctx.Err()
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	defer close(release)
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// Send the same request twice under the same call ID while the first is still being handled
+	payload := make([]byte, 100)
+	n, err := common.DefaultCodec.Encode(payload, &lookupRequest{Name: "alice"})
+	assert.NoError(t, err)
+
+	frame := make([]byte, common.FrameHeaderSize+n)
+	common.PutFrameHeader(frame, common.FrameHeader{Length: uint32(n), Flags: common.FlagRequest, PacketID: lookupRequest{}.ID(), CallID: 1})
+	copy(frame[common.FrameHeaderSize:], payload[:n])
+
+	for i := 0; i < 2; i++ {
+		_, err = conn.Write(frame)
+		assert.NoError(t, err)
+	}
+
+	goodbye, err := common.NewFrameReader(conn, 100).ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, goodbye.IsControl())
+
+	reason, _, err := common.ParseGoodbye(goodbye.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, common.CloseProtocolError, reason)
+	conn.Close()
+
+	select {
+	case err := <-disconnected:
+		assert.IsType(t, &common.ProtocolErr{}, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("client was not disconnected")
+	}
+}
+
+func TestTCPServerCallClient(t *testing.T) {
+	s, c, clientID := startRPCServer(t, func(ctx context.Context, clientID server.ClientID, req common.Packet) (common.Packet, error) {
+		return nil, errors.New("unused")
+	})
+	defer s.Stop()
+
+	err := c.HandleRequest(&lookupRequest{}, func(ctx context.Context, req common.Packet) (common.Packet, error) {
+		return &lookupResponse{Name: req.(*lookupRequest).Name}, nil
+	})
+	assert.NoError(t, err)
+
+	resp, err := s.Call(context.Background(), clientID, &lookupRequest{Name: "server"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &lookupResponse{Name: "server"}, resp)
+	}
+
+	// Calls waiting on the client fail once it disconnects
+	err = c.HandleRequest(&lookupResponse{}, func(ctx context.Context, req common.Packet) (common.Packet, error) {
+		c.Disconnect()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.NoError(t, err)
+
+	_, err = s.Call(context.Background(), clientID, &lookupResponse{})
+	assert.Error(t, err)
+	assert.NotEqual(t, context.Canceled, err)
+}
+
+func TestHandleRequestErrors(t *testing.T) {
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	handler := func(ctx context.Context, clientID server.ClientID, req common.Packet) (common.Packet, error) {
+		return req, nil
+	}
+
+	err = s.HandleRequest(&lookupRequest{}, handler)
+	assert.IsType(t, &common.PacketNotRegisteredErr{}, err)
+
+	err = s.HandleRequest(&chatPacket{}, handler)
+	assert.IsType(t, &common.PacketTypeNotRegisteredErr{}, err)
+
+	err = s.RegisterPacketType(&lookupRequest{}, nil)
+	assert.NoError(t, err)
+
+	err = s.HandleRequest(&lookupRequest{}, handler)
+	assert.NoError(t, err)
+
+	err = s.HandleRequest(lookupRequest{}, handler)
+	assert.IsType(t, &common.HandlerRegisteredErr{}, err)
+
+	_, err = s.Call(context.Background(), 0, &lookupRequest{})
+	assert.IsType(t, &server.InvalidClientID{}, err)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// WithSerializers lets clients choose how their packets are encoded from the given serializers. Clients configured
+// with client.WithSerializers offer the serializers they support when connecting, and the first one they offer that
+// the server also supports is used for their connection. Clients that do not offer any can only connect if the
+// common.DefaultCodec is one of the given serializers. Without this option, packets are encoded by the common.DefaultCodec.
+func WithSerializers(serializers ...common.Serializer) Option {
+	return func(s *TCPServer) {
+		s.serializers = serializers
+	}
+}
+
+// chooseSerializer picks the serializer for the connection of a client that sent the given hello
+func (s *TCPServer) chooseSerializer(conn net.Conn, reader *common.FrameReader, remote common.Hello) (common.Serializer, error) {
+	if len(s.serializers) == 0 {
+		return common.DefaultCodec, nil
+	}
+
+	if remote.Has(common.FeatureSerializers) {
+		return s.negotiateSerializer(conn, reader)
+	}
+
+	serializer := common.ChooseSerializer([]string{common.DefaultCodec.Name()}, s.serializers)
+	if serializer == nil {
+		return nil, &NoCommonSerializerErr{}
+	}
+
+	return serializer, nil
+}
+
+// negotiateSerializer reads the serializers the client offers and tells it which one the server chose
+func (s *TCPServer) negotiateSerializer(conn net.Conn, reader *common.FrameReader) (common.Serializer, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	frame, err := reader.ReadFrame()
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	if !frame.IsControl() || common.ControlType(frame.PacketID) != common.ControlSerializer {
+		return nil, &common.ProtocolErr{Err: fmt.Errorf("expected the client to offer serializers, got frame with ID %d", frame.PacketID)}
+	}
+
+	offered, err := common.ParseSerializers(frame.Payload)
+	if err != nil {
+		return nil, &common.ProtocolErr{Err: err}
+	}
+
+	serializer := common.ChooseSerializer(offered, s.serializers)
+	if serializer == nil {
+		return nil, &NoCommonSerializerErr{Offered: offered}
+	}
+
+	if err := common.WriteControlFrame(conn, common.ControlSerializer, common.EncodeSerializers([]common.Serializer{serializer})); err != nil {
+		return nil, err
+	}
+
+	return serializer, nil
+}
+
+// Serializer returns the serializer the given client's packets are encoded with
+func (s *TCPServer) Serializer(clientID ClientID) (common.Serializer, error) {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	c, ok := s.connections[clientID]
+	if !ok {
+		return nil, &InvalidClientID{ClientID: clientID}
+	}
+
+	return c.serializer, nil
+}
+
+// NoCommonSerializerErr is returned when a client offers none of the serializers the server supports.
+// Offered is empty if the client did not offer any and the server does not support the default codec.
+type NoCommonSerializerErr struct {
+	Offered []string
+}
+
+func (e *NoCommonSerializerErr) Error() string {
+	if len(e.Offered) == 0 {
+		return "serializers must be negotiated"
+	}
+
+	return fmt.Sprintf("none of the offered serializers %q are supported", e.Offered)
+}
@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type greetingPacket struct {
+	Text string `json:"text"`
+}
+
+func (p greetingPacket) ID() uint16 {
+	return 2
+}
+
+func TestTCPServerNegotiatesSerializer(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	received := make(chan string, 1)
+
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {}, server.WithSerializers(common.MessagePackSerializer{}, common.JSONSerializer{}))
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&greetingPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received <- p.(*greetingPacket).Text
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100, client.WithSerializers(common.GobSerializer{}, common.JSONSerializer{}))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+	defer c.Disconnect()
+
+	serializer, err := c.Serializer()
+	assert.NoError(t, err)
+	assert.Equal(t, common.JSONSerializer{}, serializer)
+
+	serializer, err = s.Serializer(<-connected)
+	assert.NoError(t, err)
+	assert.Equal(t, common.JSONSerializer{}, serializer)
+
+	err = c.SendPacket(greetingPacket{Text: "hello"})
+	assert.NoError(t, err)
+
+	select {
+	case text := <-received:
+		assert.Equal(t, "hello", text)
+	case <-time.After(time.Second):
+		t.Fatal("packet was not received")
+	}
+}
+
+func TestTCPServerRejectsUnsupportedSerializers(t *testing.T) {
+	s, err := server.NewTCPServer(100, func(clientID server.ClientID) {
+		t.Error("client should not have been admitted")
+	}, func(clientID server.ClientID, err error) {}, server.WithSerializers(common.JSONSerializer{}))
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(100, client.WithSerializers(common.GobSerializer{}))
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	if assert.IsType(t, &client.ConnectErr{}, err) {
+		disconnectErr, ok := err.(*client.ConnectErr).Err.(*common.DisconnectErr)
+		if assert.True(t, ok) {
+			assert.Equal(t, common.CloseProtocolError, disconnectErr.Reason)
+		}
+	}
+
+	// A client that does not offer serializers is turned away too
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	common.WriteFrame(conn, 2, []byte(`{"text":"hello"}`))
+
+	frame, err := common.NewFrameReader(conn, 100).ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsControl())
+	assert.Equal(t, common.ControlGoodbye, common.ControlType(frame.PacketID))
+}
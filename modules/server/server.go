@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
 
@@ -10,23 +11,42 @@ import (
 // ClientID is a unique ID given to each client upon connection
 type ClientID int32
 
-// Server is an interface for a network server that can accept
-// connections from and communicate with clients
+// Identity describes who a client has proven itself to be when connecting
+type Identity struct {
+	// Name is the name the client is known by, such as the common name of its certificate.
+	// It is empty for clients that connected without proving an identity.
+	Name string
+}
+
+// Server is an interface for a network server that can accept connections from and communicate with clients.
+// Like client.Client, it covers starting and stopping, sending and receiving packets and registering packet
+// types. Servers offer more through the smaller interfaces below and through accessors such as Sessions.
 type Server interface {
-	// Start establishes the server and begins listening for client connections
-	Start(port int) error
+	// Start establishes the server and begins listening for client connections on the given port
+	Start(port string) error
+
+	// StartContext is like Start, but gracefully shuts down the server once the context is cancelled
+	StartContext(ctx context.Context, port string) error
 
 	// Stop disconnects all clients and shuts down the server
 	Stop()
 
+	// Shutdown gracefully shuts down the server, waiting for all connections to finish
+	// or for the context to end
+	Shutdown(ctx context.Context) error
+
 	// Addr returns the address of the server
 	Addr() net.Addr
 
 	// SendPacket sends the given packet to a given connection
 	SendPacket(clientID ClientID, p common.Packet) error
 
-	// BroadcastPacket sends a packet to all connected clients, with the option to exclude a client
-	BroadcastPacket(p common.Packet, clientIDToExclude ClientID)
+	// BroadcastPacket sends a packet to all connected clients, with the option to exclude a client.
+	// It returns the errors sending to each client failed with, or nil if the packet was sent to every client.
+	BroadcastPacket(p common.Packet, clientIDToExclude ClientID) map[ClientID]error
+
+	// Kick disconnects a client, telling it why it was kicked
+	Kick(clientID ClientID, reason string) error
 
 	// ReceivePacket receives the next packet from a connection and calls the
 	// registered callback function associated with the packet type.
@@ -35,8 +55,63 @@ type Server interface {
 
 	// RegisterPacketType registers the given packet type as a packet that can be sent and received,
 	// and associates a callback function to be called when the given packet type is received.
-	// When registering a packet type, pass the zero value for that packet type.
+	// When registering a packet type, pass the zero value for that packet type. It is used as a
+	// prototype, and each received packet is decoded into a new value of the same type.
+	// IDs from common.FirstNamedPacketID up are reserved for packet types registered by name.
 	RegisterPacketType(p common.Packet, receiveCallback func(clientID ClientID, conn net.Conn, p common.Packet)) error
+
+	// RegisterNamedPacketType is like RegisterPacketType, but identifies the packet type by name
+	// instead of by its ID. The ID it is sent with is agreed on with each client as it connects.
+	RegisterNamedPacketType(name string, p common.Packet, receiveCallback func(clientID ClientID, conn net.Conn, p common.Packet)) error
+}
+
+// RoomServer is implemented by servers that can group their clients into rooms
+type RoomServer interface {
+	// Join adds the client to the given room, creating the room if it does not exist yet
+	Join(clientID ClientID, room string) error
+
+	// Leave removes the client from the given room
+	Leave(clientID ClientID, room string) error
+
+	// Members returns the IDs of the clients in the given room in ascending order
+	Members(room string) []ClientID
+
+	// BroadcastToRoom sends a packet to every client in the given room, except for the given clients.
+	// It returns the errors sending to each client failed with, or nil if the packet was sent to every client.
+	BroadcastToRoom(room string, p common.Packet, clientIDsToExclude ...ClientID) map[ClientID]error
+}
+
+// Multicaster is implemented by servers that can send a packet to a chosen set of clients
+type Multicaster interface {
+	// BroadcastWhere sends a packet to every connected client for which include returns true.
+	// It returns the errors sending to each client failed with, or nil if the packet was sent to every client.
+	BroadcastWhere(p common.Packet, include func(clientID ClientID) bool) map[ClientID]error
+
+	// Multicast sends a packet to each of the given clients. Clients that are not connected fail with
+	// an *InvalidClientID. It returns the errors sending to each client failed with, or nil if the
+	// packet was sent to every client.
+	Multicast(p common.Packet, clientIDs []ClientID) map[ClientID]error
+
+	// PreparePacket prepares a packet to be encoded once and sent as is to many clients
+	PreparePacket(p common.Packet) (*PreparedPacket, error)
+}
+
+// Caller is implemented by servers that can make calls to their clients and answer calls from them
+type Caller interface {
+	// Call sends a request to a given connection and waits for the client's response
+	Call(ctx context.Context, clientID ClientID, req common.Packet) (common.Packet, error)
+
+	// HandleRequest sets the handler that answers requests of the given registered packet type sent by clients
+	HandleRequest(req common.Packet, handler func(ctx context.Context, clientID ClientID, req common.Packet) (common.Packet, error)) error
+}
+
+// Moderator is implemented by servers that can turn connections away
+type Moderator interface {
+	// Bans returns the list of addresses and identities that may not connect to the server
+	Bans() *Bans
+
+	// Rejections returns how many connections the server has turned away because of bans and connection limits
+	Rejections() RejectionCounts
 }
 
 // InvalidClientID is an error thrown when a client ID is invalid
@@ -48,6 +123,19 @@ func (e *InvalidClientID) Error() string {
 	return fmt.Sprintf("invalid client ID of %d", e.ClientID)
 }
 
+// KickedErr is reported to onClientDisconnected for a client that was kicked
+type KickedErr struct {
+	Reason string
+}
+
+func (e *KickedErr) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("kicked: %s", e.Reason)
+	}
+
+	return "kicked"
+}
+
 // ListenErr represents an error encountered when trying to
 // listen on a port for incoming connections
 type ListenErr struct {
@@ -67,3 +155,10 @@ type AcceptErr struct {
 func (e AcceptErr) Error() string {
 	return fmt.Sprintf("could not accept connection: %v", e.Err)
 }
+
+// ServerClosedErr is returned when trying to use a server that is shutting down
+type ServerClosedErr struct{}
+
+func (e ServerClosedErr) Error() string {
+	return "server closed"
+}
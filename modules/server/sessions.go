@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Sessions keeps track of per-client state, such as a unique display name and arbitrary
+// attributes, for the clients connected to a server. It is safe for concurrent use.
+// A server clears a client's session once its onClientDisconnected callback returns,
+// so the callback can still look up who the client was.
+type Sessions struct {
+	sessions map[ClientID]*session
+	names    map[string]ClientID
+	mutex    sync.RWMutex
+
+	// isConnected reports whether a client is connected to the server the sessions belong to, if any.
+	// Sessions are only created for connected clients, so a client that has left cannot be given one again.
+	isConnected func(clientID ClientID) bool
+}
+
+// session is the state kept for a single client
+type session struct {
+	name       string
+	attributes map[string]interface{}
+}
+
+// NewSessions returns an empty session registry
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: make(map[ClientID]*session),
+		names:    make(map[string]ClientID),
+	}
+}
+
+// session returns the client's session, creating it if needed. The mutex must be held for writing, so the
+// client cannot be removed between checking that it is connected and creating its session.
+func (s *Sessions) session(clientID ClientID) (*session, error) {
+	sess, ok := s.sessions[clientID]
+	if !ok {
+		if s.isConnected != nil && !s.isConnected(clientID) {
+			return nil, &InvalidClientID{ClientID: clientID}
+		}
+
+		sess = &session{attributes: make(map[string]interface{})}
+		s.sessions[clientID] = sess
+	}
+
+	return sess, nil
+}
+
+// SetName gives the client a display name, replacing any name it had before.
+// A NameTakenErr is returned if another client already has the name, and an
+// InvalidClientID if the sessions belong to a server the client is not connected to.
+func (s *Sessions) SetName(clientID ClientID, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if owner, ok := s.names[name]; ok {
+		if owner == clientID {
+			return nil
+		}
+
+		return &NameTakenErr{Name: name}
+	}
+
+	sess, err := s.session(clientID)
+	if err != nil {
+		return err
+	}
+
+	if sess.name != "" {
+		delete(s.names, sess.name)
+	}
+
+	sess.name = name
+	s.names[name] = clientID
+	return nil
+}
+
+// Name returns the client's display name, or false if it has not been given one
+func (s *Sessions) Name(clientID ClientID) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sess, ok := s.sessions[clientID]
+	if !ok || sess.name == "" {
+		return "", false
+	}
+
+	return sess.name, true
+}
+
+// ClientID returns the ID of the client with the given display name, or false if no client has it
+func (s *Sessions) ClientID(name string) (ClientID, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	clientID, ok := s.names[name]
+	return clientID, ok
+}
+
+// Set stores an attribute in the client's session. An InvalidClientID is returned
+// if the sessions belong to a server the client is not connected to.
+func (s *Sessions) Set(clientID ClientID, key string, value interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, err := s.session(clientID)
+	if err != nil {
+		return err
+	}
+
+	sess.attributes[key] = value
+	return nil
+}
+
+// Get returns an attribute from the client's session, or false if it has not been set
+func (s *Sessions) Get(clientID ClientID, key string) (interface{}, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sess, ok := s.sessions[clientID]
+	if !ok {
+		return nil, false
+	}
+
+	value, ok := sess.attributes[key]
+	return value, ok
+}
+
+// Remove clears the client's session, freeing up its display name
+func (s *Sessions) Remove(clientID ClientID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess, ok := s.sessions[clientID]
+	if !ok {
+		return
+	}
+
+	if sess.name != "" {
+		delete(s.names, sess.name)
+	}
+
+	delete(s.sessions, clientID)
+}
+
+// NameTakenErr is returned when a client is given a display name another client already has
+type NameTakenErr struct {
+	Name string
+}
+
+func (e *NameTakenErr) Error() string {
+	return fmt.Sprintf("name %q is already taken", e.Name)
+}
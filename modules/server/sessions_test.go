@@ -0,0 +1,136 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionsNames(t *testing.T) {
+	sessions := server.NewSessions()
+
+	err := sessions.SetName(1, "alice")
+	assert.NoError(t, err)
+
+	err = sessions.SetName(1, "alice")
+	assert.NoError(t, err)
+
+	err = sessions.SetName(2, "alice")
+	assert.IsType(t, &server.NameTakenErr{}, err)
+
+	name, ok := sessions.Name(1)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", name)
+
+	clientID, ok := sessions.ClientID("alice")
+	assert.True(t, ok)
+	assert.Equal(t, server.ClientID(1), clientID)
+
+	// Renaming frees up the old name
+	err = sessions.SetName(1, "bob")
+	assert.NoError(t, err)
+
+	_, ok = sessions.ClientID("alice")
+	assert.False(t, ok)
+
+	err = sessions.SetName(2, "alice")
+	assert.NoError(t, err)
+
+	_, ok = sessions.Name(3)
+	assert.False(t, ok)
+}
+
+func TestSessionsAttributes(t *testing.T) {
+	sessions := server.NewSessions()
+
+	_, ok := sessions.Get(1, "room")
+	assert.False(t, ok)
+
+	sessions.Set(1, "room", "lobby")
+
+	value, ok := sessions.Get(1, "room")
+	assert.True(t, ok)
+	assert.Equal(t, "lobby", value)
+
+	sessions.SetName(1, "alice")
+	sessions.Remove(1)
+
+	_, ok = sessions.Get(1, "room")
+	assert.False(t, ok)
+
+	_, ok = sessions.ClientID("alice")
+	assert.False(t, ok)
+}
+
+func TestTCPServerSessionsClearedOnDisconnect(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	disconnected := make(chan string, 1)
+
+	var s *server.TCPServer
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {
+		// The session is still available while the callback runs
+		name, _ := s.Sessions().Name(clientID)
+		disconnected <- name
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+
+	clientID := <-connected
+	err = s.Sessions().SetName(clientID, "alice")
+	assert.NoError(t, err)
+
+	conn.Close()
+	assert.Equal(t, "alice", <-disconnected)
+
+	// The session is cleared right after the callback returns
+	time.Sleep(time.Millisecond * 10)
+	_, ok := s.Sessions().ClientID("alice")
+	assert.False(t, ok)
+}
+
+func TestTCPServerSessionsNotRecreatedAfterDisconnect(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	setErrs := make(chan error, 2)
+
+	var s *server.TCPServer
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {
+		setErrs <- s.Sessions().Set(clientID, "room", "lobby")
+		setErrs <- s.Sessions().SetName(clientID, "alice")
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+
+	clientID := <-connected
+	conn.Close()
+
+	assert.Equal(t, &server.InvalidClientID{ClientID: clientID}, <-setErrs)
+	assert.Equal(t, &server.InvalidClientID{ClientID: clientID}, <-setErrs)
+
+	// Clients that never connected cannot be given a session either
+	err = s.Sessions().Set(100, "room", "lobby")
+	assert.Equal(t, &server.InvalidClientID{ClientID: 100}, err)
+
+	time.Sleep(time.Millisecond * 10)
+	_, ok := s.Sessions().Get(clientID, "room")
+	assert.False(t, ok)
+	_, ok = s.Sessions().ClientID("alice")
+	assert.False(t, ok)
+}
@@ -1,116 +1,652 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/rpj5582/gochat/modules/common"
 )
 
+var (
+	_ Server      = (*TCPServer)(nil)
+	_ Multicaster = (*TCPServer)(nil)
+	_ Caller      = (*TCPServer)(nil)
+	_ Moderator   = (*TCPServer)(nil)
+	_ RoomServer  = (*TCPServer)(nil)
+)
+
+// handshakeTimeout is how long a client has to complete each step of being admitted,
+// such as the TLS handshake or authenticating
+const handshakeTimeout = 10 * time.Second
+
 // TCPServer is a server that can communicate with clients via TCP
 type TCPServer struct {
-	registeredPackets map[uint8]struct {
+	registeredPackets map[uint16]struct {
 		packet   common.Packet
 		callback func(clientID ClientID, conn net.Conn, p common.Packet)
 	}
 	maxPacketSize int
 
+	// namedPackets holds the ID assigned to each packet type registered by name,
+	// and namedTypes the same IDs by the type of the packet
+	namedPackets map[string]uint16
+	namedTypes   map[reflect.Type]uint16
+
+	requestHandlers map[reflect.Type]func(ctx context.Context, clientID ClientID, req common.Packet) (common.Packet, error)
+	maxHandlers     int
+
+	listen      func(port string) (net.Listener, error)
 	listener    net.Listener
-	connections map[ClientID]net.Conn
+	listeners   map[net.Listener]struct{}
+	connections map[ClientID]*connection
+	rooms       map[string]map[ClientID]struct{}
 	connMutex   sync.RWMutex
 
+	// closing is set once a graceful shutdown begins. It is guarded by connMutex
+	// so that no new connections or sends are started once the server is waiting on them.
+	closing bool
+	connWG  sync.WaitGroup
+	sendWG  sync.WaitGroup
+
 	onClientConnected    func(clientID ClientID)
 	onClientDisconnected func(clientID ClientID, err error)
 
+	heartbeatInterval time.Duration
+	idleTimeout       time.Duration
+
+	writeQueueSize int
+	overflowPolicy OverflowPolicy
+	writeTimeout   time.Duration
+
+	// udpIdleTimeout is how long a UDP server keeps the session of a client it has not heard from
+	udpIdleTimeout time.Duration
+
+	// connCount and connsPerIP count the connections accepted by serve that have not ended yet, in total
+	// and by IP address, toward the connection limits. They and rejections are guarded by connMutex.
+	maxClients          int
+	maxConnectionsPerIP int
+	acceptLimiter       *rateLimiter
+	connCount           int
+	connsPerIP          map[string]int
+	rejections          RejectionCounts
+	onReject            func(addr net.Addr, rejection Rejection)
+
+	onJoin  func(clientID ClientID, room string)
+	onLeave func(clientID ClientID, room string)
+
+	sessions      *Sessions
+	bans          *Bans
+	authenticator Authenticator
+	serializers   []common.Serializer
+	appVersion    string
+	versionCheck  common.VersionCheck
+
 	clientCounter ClientID
+
+	// optionErr is the error an option was given invalid settings with, which creating the server fails with
+	optionErr error
 }
 
-// NewTCPServer returns an initialized TCP server ready to start
-// listening for incoming client connections
-func NewTCPServer(maxPacketSize int, onClientConnected func(clientID ClientID), onClientDisconnected func(clientID ClientID, err error)) (*TCPServer, error) {
+// connection is a single client connection along with the
+// buffered frame reader used to receive packets from it
+type connection struct {
+	id       ClientID
+	conn     net.Conn
+	reader   *common.FrameReader
+	identity Identity
+	hello    common.Hello
+
+	// serializer encodes the packets the client did not ask to encode themselves
+	serializer common.Serializer
+
+	// calls tracks the calls made to the client and the requests from the client being handled
+	calls *common.Calls
+
+	// queue holds the frames waiting to be written to the client if the server has a write queue
+	queue *writeQueue
+
+	// writeMutex is held while writing to the client, so each write's deadline only applies to it.
+	// closeDeadline and saidGoodbye are guarded by it: writes made while the client is being disconnected
+	// must be done by closeDeadline, and nothing is written once the goodbye has been.
+	writeMutex    sync.Mutex
+	closeDeadline time.Time
+	saidGoodbye   bool
+
+	// rooms is the set of rooms the client is in. It is guarded by connMutex.
+	rooms map[string]struct{}
+
+	// closeErr is set once the server decides to close the connection, and is reported to
+	// onClientDisconnected in place of the error that ends the connection. It is guarded by connMutex.
+	closeErr error
+}
+
+// NewTCPServer returns an initialized TCP server ready to start listening for incoming client connections.
+// onClientDisconnected is called with the reason a client left: a *common.DisconnectErr whose Reason tells a
+// graceful close from a server shutdown or a connection that simply dropped, a *common.TimeoutErr,
+// a *common.ProtocolErr if the client sent something the server could not handle, a *KickedErr if
+// the server kicked the client, or a *common.ReceiveErr.
+func NewTCPServer(maxPacketSize int, onClientConnected func(clientID ClientID), onClientDisconnected func(clientID ClientID, err error), opts ...Option) (*TCPServer, error) {
 	if maxPacketSize < 1 {
 		return nil, &common.InvalidMaxPacketSizeErr{Size: maxPacketSize}
 	}
 
-	return &TCPServer{
-		registeredPackets: make(map[uint8]struct {
+	s := &TCPServer{
+		registeredPackets: make(map[uint16]struct {
 			packet   common.Packet
 			callback func(clientID ClientID, conn net.Conn, p common.Packet)
 		}),
 		maxPacketSize:        maxPacketSize,
-		connections:          make(map[ClientID]net.Conn),
+		namedPackets:         make(map[string]uint16),
+		namedTypes:           make(map[reflect.Type]uint16),
+		requestHandlers:      make(map[reflect.Type]func(ctx context.Context, clientID ClientID, req common.Packet) (common.Packet, error)),
+		listen:               listenTCP,
+		listeners:            make(map[net.Listener]struct{}),
+		connections:          make(map[ClientID]*connection),
+		rooms:                make(map[string]map[ClientID]struct{}),
+		connsPerIP:           make(map[string]int),
+		bans:                 NewBans(),
+		writeTimeout:         defaultWriteTimeout,
+		maxHandlers:          common.DefaultMaxHandlers,
+		udpIdleTimeout:       defaultUDPIdleTimeout,
 		onClientConnected:    onClientConnected,
 		onClientDisconnected: onClientDisconnected,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.optionErr != nil {
+		return nil, s.optionErr
+	}
+
+	s.sessions = NewSessions()
+	s.sessions.isConnected = s.isConnected
+
+	return s, nil
+}
+
+func listenTCP(port string) (net.Listener, error) {
+	return net.Listen("tcp", ":"+port)
 }
 
 func (s *TCPServer) Start(port string) error {
-	var err error
-	s.listener, err = net.Listen("tcp", ":"+port)
+	return s.StartContext(context.Background(), port)
+}
+
+// StartContext is like Start, but gracefully shuts the server down once the context is cancelled.
+// A server that is shut down through its context or through Shutdown returns nil.
+func (s *TCPServer) StartContext(ctx context.Context, port string) error {
+	listener, err := s.listen(port)
 	if err != nil {
 		return &ListenErr{Port: port, Err: err}
 	}
 
+	s.connMutex.Lock()
+	s.listener = listener
+	s.connMutex.Unlock()
+
+	return s.serve(ctx, listener)
+}
+
+// serve accepts connections from the listener until it is closed. Every transport that
+// shares this server's connections, such as a WebSocketServer, accepts its clients through serve.
+func (s *TCPServer) serve(ctx context.Context, listener net.Listener) error {
+	s.connMutex.Lock()
+	if s.closing {
+		s.connMutex.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listeners[listener] = struct{}{}
+	s.connMutex.Unlock()
+
+	defer func() {
+		s.connMutex.Lock()
+		delete(s.listeners, listener)
+		s.connMutex.Unlock()
+	}()
+
+	acceptDone := make(chan struct{})
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+
+		select {
+		case <-ctx.Done():
+			s.Shutdown(context.Background())
+		case <-acceptDone:
+		}
+	}()
+
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			close(acceptDone)
+			<-shutdownDone
+
+			if s.isClosing() {
+				return nil
+			}
+
 			return &AcceptErr{Err: err}
 		}
 
-		clientID := s.AddNewConnection(conn)
-
-		go func(clientID ClientID, conn net.Conn) {
-			defer func() {
-				conn.Close()
-				s.connMutex.Lock()
-				delete(s.connections, clientID)
-				s.connMutex.Unlock()
+		s.connMutex.Lock()
+		if s.closing {
+			s.connMutex.Unlock()
+			conn.Close()
+			continue
+		}
+		rejection, reason, message, ok := s.admit(conn)
+		s.connWG.Add(1)
+		s.connMutex.Unlock()
+
+		if !ok {
+			go func() {
+				defer s.connWG.Done()
+				s.refuse(conn, rejection, reason, message)
 			}()
+			continue
+		}
+
+		go s.handleConnection(conn)
+	}
+}
 
-			s.onClientConnected(clientID)
+// handleConnection admits the client of a connection accepted by serve and receives packets from it until it ends
+func (s *TCPServer) handleConnection(conn net.Conn) {
+	defer s.connWG.Done()
+	defer s.release(conn)
 
-			var err error
-			for {
-				if err = s.ReceivePacket(clientID); err != nil {
-					switch err.(type) {
-					case *common.DisconnectErr:
-						err = nil
-					}
+	identity, err := s.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
 
-					break
-				}
-			}
+	reader := common.NewFrameReader(conn, s.maxPacketSize)
+
+	hello, err := s.hello(conn, reader)
+	if err != nil {
+		rejectHandshake(conn, err)
+		return
+	}
+
+	serializer, err := s.chooseSerializer(conn, reader, hello)
+	if err != nil {
+		rejectHandshake(conn, err)
+		return
+	}
+
+	if s.authenticator != nil {
+		if identity, err = s.authenticate(conn, reader, serializer); err != nil {
+			reject(conn, common.CloseUnauthorized, err.Error())
+			return
+		}
+	}
+
+	if ban, ok := s.bans.Identity(identity.Name); ok {
+		s.refuse(conn, RejectBanned, common.CloseBanned, ban.Reason)
+		return
+	}
+
+	if s.authenticator != nil {
+		// Tell the client it was admitted before any broadcast can reach it
+		if err := common.WriteControlFrame(conn, common.ControlAccept, nil); err != nil {
+			conn.Close()
+			return
+		}
+	}
+
+	c := &connection{
+		conn:       conn,
+		reader:     reader,
+		identity:   identity,
+		hello:      hello,
+		serializer: serializer,
+	}
+	if !s.addConnection(c) {
+		conn.Close()
+		return
+	}
+
+	defer func() {
+		if c.queue != nil {
+			c.queue.close(true)
+		}
+		conn.Close()
+	}()
+
+	s.onClientConnected(c.id)
 
-			s.onClientDisconnected(clientID, err)
-		}(clientID, conn)
+	if s.heartbeatInterval > 0 {
+		pingDone := make(chan struct{})
+		defer close(pingDone)
+
+		go s.ping(c, pingDone)
+	}
+
+	for {
+		if err = s.ReceivePacket(c.id); err != nil {
+			break
+		}
+	}
+
+	switch err.(type) {
+	case *common.DisconnectErr, *common.ReceiveErr, *InvalidClientID:
+	case *common.TimeoutErr:
+		s.disconnect(c, common.CloseTimeout, "", err)
+	case *common.ProtocolErr:
+		s.disconnect(c, common.CloseProtocolError, err.Error(), err)
+	default:
+		err = &common.ProtocolErr{Err: err}
+		s.disconnect(c, common.CloseProtocolError, err.Error(), err)
+	}
+
+	s.connMutex.RLock()
+	closeErr := c.closeErr
+	s.connMutex.RUnlock()
+
+	if closeErr != nil {
+		// The server closed the connection, so wait for the client to close its side
+		// after reading the goodbye, and report why the server closed it
+		io.Copy(ioutil.Discard, conn)
+		err = closeErr
+	}
+
+	c.calls.Close(err)
+
+	// The client leaves the server's connections before its rooms, so it cannot join
+	// a room again while it is leaving them, such as from onClientDisconnected
+	s.connMutex.Lock()
+	delete(s.connections, c.id)
+	s.connMutex.Unlock()
+
+	s.leaveAll(c)
+	s.onClientDisconnected(c.id, err)
+	s.sessions.Remove(c.id)
+}
+
+// disconnect sends the client a goodbye with the given reason and closes the write side of its connection.
+// The connection's goroutine closes the connection once the client has closed its side or
+// common.GoodbyeTimeout has passed, and reports err to onClientDisconnected.
+func (s *TCPServer) disconnect(c *connection, reason common.CloseReason, message string, err error) {
+	s.connMutex.Lock()
+	if c.closeErr != nil {
+		s.connMutex.Unlock()
+		return
+	}
+	c.closeErr = err
+	s.connMutex.Unlock()
+
+	// The deadline also cuts short a write that is already under way
+	deadline := time.Now().Add(common.GoodbyeTimeout)
+	c.writeMutex.Lock()
+	c.closeDeadline = deadline
+	c.writeMutex.Unlock()
+	c.conn.SetWriteDeadline(deadline)
+
+	// Packets sent before the goodbye are written first
+	if c.queue != nil {
+		c.queue.close(false)
+		<-c.queue.done
+	}
+
+	c.writeMutex.Lock()
+	c.conn.SetWriteDeadline(deadline)
+	common.WriteControlFrame(c.conn, common.ControlGoodbye, common.EncodeGoodbye(reason, message))
+	common.CloseWrite(c.conn)
+	c.saidGoodbye = true
+	c.writeMutex.Unlock()
+
+	c.conn.SetReadDeadline(time.Now().Add(common.GoodbyeTimeout))
+}
+
+// ping sends the client a ping every heartbeat interval until done is closed. Pings are written like packets,
+// behind the ones already queued and within the write timeout, so a ping that cannot be written disconnects the client.
+func (s *TCPServer) ping(c *connection, done <-chan struct{}) {
+	ticker := time.NewTicker(s.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			common.WriteControlFrame(frameWriter{s: s, c: c}, common.ControlPing, nil)
+		case <-done:
+			return
+		}
+	}
+}
+
+// isConnected reports whether the client is one of the server's connections
+func (s *TCPServer) isConnected(clientID ClientID) bool {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	_, ok := s.connections[clientID]
+	return ok
+}
+
+func (s *TCPServer) isClosing() bool {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	return s.closing
+}
+
+// authenticate reads the first packet the client sends and passes it to the server's authenticator
+func (s *TCPServer) authenticate(conn net.Conn, reader *common.FrameReader, serializer common.Serializer) (Identity, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	frame, err := reader.ReadFrame()
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var firstPacket common.Packet
+	if frame.IsControl() {
+		if common.ControlType(frame.PacketID) != common.ControlAuth {
+			return Identity{}, &common.ProtocolErr{Err: fmt.Errorf("unexpected control type %d while authenticating", frame.PacketID)}
+		}
+
+		firstPacket = &common.Credentials{}
+	} else {
+		p, ok := s.registeredPackets[frame.PacketID]
+		if !ok {
+			return Identity{}, &common.PacketNotRegisteredErr{PacketID: frame.PacketID}
+		}
+
+		firstPacket = common.NewPacket(p.packet)
+	}
+
+	if err := common.DecodePacket(firstPacket, frame.Payload, serializer); err != nil {
+		return Identity{}, &common.ProtocolErr{Err: err}
 	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancelFunc()
+
+	return s.authenticator.Authenticate(ctx, conn, firstPacket)
+}
+
+// reject turns away a client that has not been admitted, telling it why before closing its connection
+func reject(conn net.Conn, reason common.CloseReason, message string) {
+	conn.SetWriteDeadline(time.Now().Add(common.GoodbyeTimeout))
+	common.WriteControlFrame(conn, common.ControlGoodbye, common.EncodeGoodbye(reason, message))
+	common.CloseWrite(conn)
+
+	conn.SetReadDeadline(time.Now().Add(common.GoodbyeTimeout))
+	io.Copy(ioutil.Discard, conn)
+	conn.Close()
+}
+
+// handshake performs any handshake the connection's transport needs before the
+// client is admitted, and returns the identity the client proved during it
+func (s *TCPServer) handshake(conn net.Conn) (Identity, error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return handshakeTLS(tlsConn)
+	}
+
+	return Identity{}, nil
 }
 
 func (s *TCPServer) AddNewConnection(conn net.Conn) ClientID {
+	c := &connection{
+		conn:       conn,
+		reader:     common.NewFrameReader(conn, s.maxPacketSize),
+		serializer: common.DefaultCodec,
+	}
+	if s.addConnection(c) {
+		return c.id
+	}
+
+	return 0
+}
+
+// addConnection assigns a client ID to the connection and makes it visible to the rest of the server.
+// It returns false if the server has started shutting down.
+func (s *TCPServer) addConnection(c *connection) bool {
 	s.connMutex.Lock()
-	clientID := s.clientCounter
+	defer s.connMutex.Unlock()
+
+	if s.closing {
+		return false
+	}
+
+	c.id = s.clientCounter
+	c.rooms = make(map[string]struct{})
+	c.calls = common.NewCalls(s.maxHandlers)
 	s.clientCounter++
-	s.connections[clientID] = conn
-	s.connMutex.Unlock()
+	s.connections[c.id] = c
+
+	if s.writeQueueSize > 0 {
+		c.queue = newWriteQueue(s.writeQueueSize)
+		go s.write(c)
+	}
+
+	return true
+}
+
+// Sessions returns the server's session registry. A client's session is cleared once the
+// server's onClientDisconnected callback returns. Clients that have started disconnecting,
+// such as while the callback runs, cannot be given a name or attributes anymore.
+func (s *TCPServer) Sessions() *Sessions {
+	return s.sessions
+}
+
+// Bans returns the server's ban list, which is checked before each client is admitted
+func (s *TCPServer) Bans() *Bans {
+	return s.bans
+}
+
+// Kick disconnects a client, telling it that it was kicked with common.CloseKicked and the given reason.
+// Packets already queued for the client are written first. Once the client has closed its side of the
+// connection, or common.GoodbyeTimeout has passed, a *KickedErr is reported to onClientDisconnected.
+func (s *TCPServer) Kick(clientID ClientID, reason string) error {
+	s.connMutex.RLock()
+	c, ok := s.connections[clientID]
+	s.connMutex.RUnlock()
+
+	if !ok {
+		return &InvalidClientID{ClientID: clientID}
+	}
+
+	s.disconnect(c, common.CloseKicked, reason, &KickedErr{Reason: reason})
+	return nil
+}
 
-	return clientID
+// Identity returns the identity the given client proved when connecting,
+// such as the subject of its TLS client certificate
+func (s *TCPServer) Identity(clientID ClientID) (Identity, error) {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	c, ok := s.connections[clientID]
+	if !ok {
+		return Identity{}, &InvalidClientID{ClientID: clientID}
+	}
+
+	return c.identity, nil
 }
 
 func (s *TCPServer) Stop() {
 	s.connMutex.Lock()
 	defer s.connMutex.Unlock()
 
-	for _, conn := range s.connections {
-		conn.Close()
+	for _, c := range s.connections {
+		if c.closeErr == nil {
+			c.closeErr = &common.DisconnectErr{Reason: common.CloseGoingAway}
+		}
+		if c.queue != nil {
+			c.queue.close(true)
+		}
+		c.conn.Close()
+	}
+
+	s.connections = make(map[ClientID]*connection)
+	for listener := range s.listeners {
+		listener.Close()
+	}
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting new connections, waits for packets
+// that are already being sent, says goodbye to every client and then waits for all connection
+// goroutines to exit. If the context ends first, its error is returned.
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+	s.connMutex.Lock()
+	s.closing = true
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	s.connMutex.Unlock()
+
+	if err := waitContext(ctx, &s.sendWG); err != nil {
+		return err
+	}
+
+	s.connMutex.RLock()
+	connections := make([]*connection, 0, len(s.connections))
+	for _, c := range s.connections {
+		connections = append(connections, c)
 	}
+	s.connMutex.RUnlock()
+
+	for _, c := range connections {
+		go s.disconnect(c, common.CloseGoingAway, "", &common.DisconnectErr{Reason: common.CloseGoingAway})
+	}
+
+	return waitContext(ctx, &s.connWG)
+}
 
-	s.connections = nil
-	s.listener.Close()
+// waitContext waits for the wait group to finish or the context to end, whichever happens first
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (s *TCPServer) Addr() net.Addr {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
 	if s.listener != nil {
 		return s.listener.Addr()
 	}
@@ -119,89 +655,314 @@ func (s *TCPServer) Addr() net.Addr {
 }
 
 func (s *TCPServer) SendPacket(clientID ClientID, p common.Packet) error {
-	packetBuffer := make([]byte, s.maxPacketSize)
-	packetBuffer[0] = p.ID()
+	return s.sendPacket(clientID, p, common.FrameHeader{})
+}
 
-	n, err := p.Read(packetBuffer[1:])
-	if err != nil {
-		return err
+// sendPacket sends the given packet to a given connection in a frame with the given flags and call ID
+func (s *TCPServer) sendPacket(clientID ClientID, p common.Packet, header common.FrameHeader) error {
+	s.connMutex.RLock()
+	if s.closing {
+		s.connMutex.RUnlock()
+		return &ServerClosedErr{}
 	}
 
-	s.connMutex.RLock()
-	conn, ok := s.connections[clientID]
+	c, ok := s.connections[clientID]
 	if !ok {
 		s.connMutex.RUnlock()
 		return &InvalidClientID{ClientID: clientID}
 	}
+	s.sendWG.Add(1)
 	s.connMutex.RUnlock()
+	defer s.sendWG.Done()
+
+	return s.send(c, p, header)
+}
+
+// send sends the given packet to a connection in a frame with the given flags and call ID.
+// The send must be counted in sendWG, so the server waits for it when shutting down.
+func (s *TCPServer) send(c *connection, p common.Packet, header common.FrameHeader) error {
+	if prepared, ok := p.(*PreparedPacket); ok {
+		// Prepared frames carry no flags or call ID, so requests and responses are encoded as usual
+		if header == (common.FrameHeader{}) {
+			frame, err := prepared.frame(c.serializer)
+			if err != nil {
+				return err
+			}
+
+			return s.writeFrame(c, queuedFrame{data: frame})
+		}
+
+		p = prepared.Packet()
+	}
+
+	packetID, err := s.packetID(p)
+	if err != nil {
+		return err
+	}
+
+	header.PacketID = packetID
+	frame, err := common.EncodeFrame(header, p, c.serializer, s.maxPacketSize)
+	if err != nil {
+		return err
+	}
 
-	if _, err := conn.Write(packetBuffer[:n+1]); err != nil {
-		return &common.SendErr{
-			PacketID: p.ID(),
-			Err:      err,
+	return s.writeFrame(c, queuedFrame{data: *frame, buffer: frame})
+}
+
+// writeFrame writes a frame to the client, or queues it to be written if the client has a write queue
+func (s *TCPServer) writeFrame(c *connection, frame queuedFrame) error {
+	if c.queue != nil {
+		return s.enqueue(c, frame)
+	}
+
+	return s.writeNow(c, frame)
+}
+
+// writeNow writes a frame to the client, giving up once the server's write timeout passes. A failed write may
+// have written part of the frame, which would leave the client unable to read anything after it, so the client
+// is disconnected and the failure is reported to onClientDisconnected.
+func (s *TCPServer) writeNow(c *connection, frame queuedFrame) error {
+	defer frame.release()
+	packetID := common.ParseFrameHeader(frame.data).PacketID
+
+	c.writeMutex.Lock()
+	if c.saidGoodbye {
+		c.writeMutex.Unlock()
+		return &common.SendErr{PacketID: packetID, Err: errors.New("the client is being disconnected")}
+	}
+
+	var deadline time.Time
+	if s.writeTimeout > 0 {
+		deadline = time.Now().Add(s.writeTimeout)
+	}
+	if !c.closeDeadline.IsZero() && (deadline.IsZero() || c.closeDeadline.Before(deadline)) {
+		deadline = c.closeDeadline
+	}
+
+	c.conn.SetWriteDeadline(deadline)
+	_, err := c.conn.Write(frame.data)
+	c.writeMutex.Unlock()
+
+	if err != nil {
+		err = &common.SendErr{PacketID: packetID, Err: err}
+
+		s.connMutex.Lock()
+		if c.closeErr == nil {
+			c.closeErr = err
 		}
+		s.connMutex.Unlock()
+
+		c.conn.Close()
+		return err
 	}
 
 	return nil
 }
 
-func (s *TCPServer) BroadcastPacket(p common.Packet, clientIDToExclude ClientID) {
+// frameWriter writes frames to a client through writeFrame, so the frames the library writes on its own,
+// such as pongs and call errors, share the client's write queue and write timeout with its packets.
+// Every call to Write must contain exactly one whole frame, as written by the common package.
+type frameWriter struct {
+	s *TCPServer
+	c *connection
+}
+
+func (w frameWriter) Write(frame []byte) (int, error) {
+	buffer := common.GetBuffer(len(frame))
+	copy(*buffer, frame)
+
+	if err := w.s.writeFrame(w.c, queuedFrame{data: *buffer, buffer: buffer}); err != nil {
+		return 0, err
+	}
+
+	return len(frame), nil
+}
+
+func (s *TCPServer) BroadcastPacket(p common.Packet, clientIDToExclude ClientID) map[ClientID]error {
+	return s.BroadcastWhere(p, func(clientID ClientID) bool {
+		return clientID != clientIDToExclude
+	})
+}
+
+func (s *TCPServer) BroadcastWhere(p common.Packet, include func(clientID ClientID) bool) map[ClientID]error {
 	s.connMutex.RLock()
-	defer s.connMutex.RUnlock()
+	conns := make([]*connection, 0, len(s.connections))
+	for _, c := range s.connections {
+		conns = append(conns, c)
+	}
+	closing := s.hold(conns)
+	s.connMutex.RUnlock()
+
+	return s.sendToAll(conns, closing, p, include, nil)
+}
+
+func (s *TCPServer) Multicast(p common.Packet, clientIDs []ClientID) map[ClientID]error {
+	var errs map[ClientID]error
+
+	s.connMutex.RLock()
+	conns := make([]*connection, 0, len(clientIDs))
+	seen := make(map[ClientID]struct{}, len(clientIDs))
+	for _, clientID := range clientIDs {
+		if _, ok := seen[clientID]; ok {
+			continue
+		}
+		seen[clientID] = struct{}{}
+
+		c, ok := s.connections[clientID]
+		if !ok {
+			errs = addSendErr(errs, clientID, &InvalidClientID{ClientID: clientID})
+			continue
+		}
+		conns = append(conns, c)
+	}
+	closing := s.hold(conns)
+	s.connMutex.RUnlock()
+
+	return s.sendToAll(conns, closing, p, nil, errs)
+}
+
+// hold counts a send to each of the given connections in sendWG, unless the server is closing, in which case
+// nothing may be sent and true is returned. connMutex must be held, so the server cannot start closing in between.
+func (s *TCPServer) hold(conns []*connection) bool {
+	if s.closing {
+		return true
+	}
+
+	s.sendWG.Add(len(conns))
+	return false
+}
+
+// sendToAll sends a packet to each of the given connections held by hold that include accepts, or to all of them if
+// include is nil. It adds the errors sending to them failed with to errs by client and returns it. The packet is
+// prepared first, so it is only encoded once no matter how many clients it is sent to.
+func (s *TCPServer) sendToAll(conns []*connection, closing bool, p common.Packet, include func(clientID ClientID) bool, errs map[ClientID]error) map[ClientID]error {
+	prepared, prepareErr := s.PreparePacket(p)
+	if closing {
+		prepareErr = &ServerClosedErr{}
+	}
+
+	for _, c := range conns {
+		if include == nil || include(c.id) {
+			err := prepareErr
+			if err == nil {
+				err = s.send(c, prepared, common.FrameHeader{})
+			}
 
-	for clientID := range s.connections {
-		if clientID != clientIDToExclude {
-			s.SendPacket(clientID, p)
+			if err != nil {
+				errs = addSendErr(errs, c.id, err)
+			}
+		}
+
+		if !closing {
+			s.sendWG.Done()
 		}
 	}
+
+	return errs
 }
 
-func (s *TCPServer) ReceivePacket(clientID ClientID) error {
-	packetBuffer := make([]byte, s.maxPacketSize)
+// addSendErr records the error sending to a client failed with, making the map of errors if this is the first one
+func addSendErr(errs map[ClientID]error, clientID ClientID, err error) map[ClientID]error {
+	if errs == nil {
+		errs = make(map[ClientID]error)
+	}
+	errs[clientID] = err
 
+	return errs
+}
+
+func (s *TCPServer) ReceivePacket(clientID ClientID) error {
 	s.connMutex.RLock()
-	conn, ok := s.connections[clientID]
+	c, ok := s.connections[clientID]
 	if !ok {
 		s.connMutex.RUnlock()
 		return &InvalidClientID{ClientID: clientID}
 	}
+	closing := c.closeErr != nil
 	s.connMutex.RUnlock()
 
-	n, err := conn.Read(packetBuffer)
+	// Once the server is closing the connection, its read deadline bounds how long to wait for the client
+	if s.idleTimeout > 0 && !closing {
+		c.conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+	}
+
+	frame, err := c.reader.ReadFrame()
 	if err != nil {
 		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 			return &common.TimeoutErr{}
 		}
 
 		if err == io.EOF {
-			return &common.DisconnectErr{}
+			return &common.DisconnectErr{Reason: common.CloseAbnormal}
+		}
+
+		if _, ok := err.(*common.FrameTooLargeErr); ok {
+			return err
 		}
 
 		return &common.ReceiveErr{Err: err}
 	}
 
-	if n < 1 {
-		return &common.ReceiveErr{Err: errors.New("received empty packet")}
+	if frame.IsControl() {
+		return s.handleControlFrame(c, frame)
 	}
 
-	packetID := packetBuffer[0]
-	packetBuffer = packetBuffer[1:n]
+	if frame.IsResponse() && frame.Flags&common.FlagError != 0 {
+		return s.handleCallErr(c, frame)
+	}
 
-	p, ok := s.registeredPackets[packetID]
+	p, ok := s.registeredPackets[frame.PacketID]
 	if !ok {
-		return &common.PacketNotRegisteredErr{PacketID: packetID}
+		return &common.PacketNotRegisteredErr{PacketID: frame.PacketID}
 	}
 
-	if _, err := p.packet.Write(packetBuffer); err != nil {
+	packet := common.NewPacket(p.packet)
+	if err := common.DecodePacket(packet, frame.Payload, c.serializer); err != nil {
 		return err
 	}
 
-	p.callback(clientID, conn, p.packet)
+	switch {
+	case frame.IsResponse():
+		c.calls.Resolve(frame.CallID, common.CallResult{Packet: packet})
+	case frame.IsRequest():
+		return s.handleRequest(c, frame, packet)
+	case p.callback != nil:
+		p.callback(clientID, c.conn, packet)
+	}
+
 	return nil
 }
 
+// handleControlFrame handles a control message sent by a client
+func (s *TCPServer) handleControlFrame(c *connection, frame common.Frame) error {
+	switch common.ControlType(frame.PacketID) {
+	case common.ControlGoodbye:
+		reason, message, err := common.ParseGoodbye(frame.Payload)
+		if err != nil {
+			return &common.ProtocolErr{Err: err}
+		}
+
+		return &common.DisconnectErr{Reason: reason, Message: message}
+	case common.ControlPing:
+		common.WriteControlFrame(frameWriter{s: s, c: c}, common.ControlPong, frame.Payload)
+		return nil
+	case common.ControlPong:
+		// Receiving the pong already reset the idle timeout
+		return nil
+	case common.ControlCancel:
+		c.calls.Cancel(frame.CallID)
+		return nil
+	default:
+		return &common.ProtocolErr{Err: fmt.Errorf("unknown control type %d", frame.PacketID)}
+	}
+}
+
 func (s *TCPServer) RegisterPacketType(p common.Packet, receiveCallback func(clientID ClientID, conn net.Conn, p common.Packet)) error {
 	packetID := p.ID()
+	if packetID >= common.FirstNamedPacketID {
+		return &common.InvalidPacketIDErr{PacketID: packetID}
+	}
+
 	if _, ok := s.registeredPackets[packetID]; ok {
 		return &common.PacketRegisteredErr{PacketID: packetID}
 	}
@@ -1,13 +1,18 @@
 package server_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/rpj5582/gochat/modules/client"
 	"github.com/rpj5582/gochat/modules/common"
 	"github.com/rpj5582/gochat/modules/server"
 	"github.com/stretchr/testify/assert"
@@ -15,7 +20,7 @@ import (
 
 type TestPacket struct{}
 
-func (p TestPacket) ID() uint8 {
+func (p TestPacket) ID() uint16 {
 	return 0
 }
 
@@ -30,6 +35,28 @@ func (p TestPacket) Read(buffer []byte) (int, error) {
 	return copy(buffer, []byte("test data")), nil
 }
 
+// dial connects to the server and exchanges hellos with it the way a client does,
+// so tests can then speak to the server frame by frame
+func dial(addr string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := common.WriteHello(conn, common.Hello{Version: common.ProtocolVersion}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Read the server's hello without buffering, so the frames after it are left for the test
+	if _, err := common.ReadHello(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
 func TestNewTCPServerInvalidMaxPacketSize(t *testing.T) {
 	s, err := server.NewTCPServer(0, nil, nil)
 	assert.Nil(t, s)
@@ -54,15 +81,16 @@ func TestTCPServerStartListenFailure(t *testing.T) {
 func TestTCPServerStart(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
-	var conn net.Conn
+	conns := make(chan net.Conn, 1)
 
 	onClientConnected := func(clientID server.ClientID) {
-		time.Sleep(time.Millisecond * 10)
+		conn := <-conns
+		common.WriteControlFrame(conn, common.ControlGoodbye, common.EncodeGoodbye(common.CloseNormal, "bye"))
 		conn.Close()
 	}
 
 	onClientDisconnected := func(clientID server.ClientID, err error) {
-		assert.NoError(t, err)
+		assert.Equal(t, &common.DisconnectErr{Reason: common.CloseNormal, Message: "bye"}, err)
 		cancelFunc()
 	}
 
@@ -71,14 +99,15 @@ func TestTCPServerStart(t *testing.T) {
 	assert.NoError(t, err)
 
 	go func() {
-		err = s.Start("0")
+		err := s.Start("0")
 		assert.IsType(t, &server.AcceptErr{}, err)
 	}()
 
 	time.Sleep(time.Millisecond * 10)
 
-	conn, err = net.Dial("tcp", s.Addr().String())
+	conn, err := dial(s.Addr().String())
 	assert.NoError(t, err)
+	conns <- conn
 
 	select {
 	case <-ctx.Done():
@@ -102,7 +131,7 @@ func TestTCPServerAddrSuccess(t *testing.T) {
 	assert.NoError(t, err)
 
 	go func() {
-		err = s.Start("0")
+		err := s.Start("0")
 		assert.IsType(t, &server.AcceptErr{}, err)
 	}()
 
@@ -137,7 +166,7 @@ func TestTCPServerSendPacketSuccess(t *testing.T) {
 	assert.NoError(t, err)
 
 	go func() {
-		err = s.Start("0")
+		err := s.Start("0")
 		assert.IsType(t, &server.AcceptErr{}, err)
 	}()
 
@@ -168,16 +197,16 @@ func TestTCPServerBroadcastPacketToAll(t *testing.T) {
 	assert.NoError(t, err)
 
 	go func() {
-		err = s.Start("0")
+		err := s.Start("0")
 		assert.IsType(t, &server.AcceptErr{}, err)
 	}()
 
 	time.Sleep(time.Millisecond * 10)
 
-	conn1, err := net.Dial("tcp", s.Addr().String())
+	conn1, err := dial(s.Addr().String())
 	assert.NoError(t, err)
 
-	conn2, err := net.Dial("tcp", s.Addr().String())
+	conn2, err := dial(s.Addr().String())
 	assert.NoError(t, err)
 
 	result1 := []byte{}
@@ -185,9 +214,7 @@ func TestTCPServerBroadcastPacketToAll(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		incoming := []byte{0}
-		incoming = append(incoming, []byte("test data")...)
-		_, err := conn1.Write(incoming)
+		err := common.WriteFrame(conn1, 0, []byte("test data"))
 		assert.NoError(t, err)
 
 		buffer := [5]byte{}
@@ -225,16 +252,16 @@ func TestTCPServerBroadcastPacketExcludeSender(t *testing.T) {
 	assert.NoError(t, err)
 
 	go func() {
-		err = s.Start("0")
+		err := s.Start("0")
 		assert.IsType(t, &server.AcceptErr{}, err)
 	}()
 
 	time.Sleep(time.Millisecond * 10)
 
-	conn1, err := net.Dial("tcp", s.Addr().String())
+	conn1, err := dial(s.Addr().String())
 	assert.NoError(t, err)
 
-	conn2, err := net.Dial("tcp", s.Addr().String())
+	conn2, err := dial(s.Addr().String())
 	assert.NoError(t, err)
 
 	result1 := []byte{}
@@ -242,9 +269,7 @@ func TestTCPServerBroadcastPacketExcludeSender(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		incoming := []byte{0}
-		incoming = append(incoming, []byte("test data")...)
-		_, err := conn1.Write(incoming)
+		err := common.WriteFrame(conn1, 0, []byte("test data"))
 		assert.NoError(t, err)
 
 		buffer := [5]byte{}
@@ -270,6 +295,89 @@ func TestTCPServerBroadcastPacketExcludeSender(t *testing.T) {
 	assert.NotEmpty(t, result2)
 }
 
+// connectTestClients starts a server and connects the given number of clients to it
+func connectTestClients(t *testing.T, clients int) (*server.TCPServer, map[server.ClientID]net.Conn) {
+	connected := make(chan server.ClientID, clients)
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+	t.Cleanup(s.Stop)
+
+	go s.Start("0")
+	time.Sleep(time.Millisecond * 10)
+
+	conns := make(map[server.ClientID]net.Conn)
+	for i := 0; i < clients; i++ {
+		conn, err := dial(s.Addr().String())
+		assert.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		conns[<-connected] = conn
+	}
+
+	return s, conns
+}
+
+// assertReceived checks that exactly the given clients were sent a TestPacket
+func assertReceived(t *testing.T, conns map[server.ClientID]net.Conn, clientIDs ...server.ClientID) {
+	received := make(map[server.ClientID]bool)
+	for _, clientID := range clientIDs {
+		received[clientID] = true
+	}
+
+	for clientID, conn := range conns {
+		if received[clientID] {
+			conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		} else {
+			conn.SetReadDeadline(time.Now().Add(time.Millisecond * 50))
+		}
+
+		frame, err := common.NewFrameReader(conn, 10).ReadFrame()
+		if received[clientID] {
+			assert.NoError(t, err, "client %d", clientID)
+			assert.Equal(t, []byte("test data"), frame.Payload)
+		} else {
+			assert.Error(t, err, "client %d should not have received the packet", clientID)
+		}
+	}
+}
+
+func TestTCPServerMulticast(t *testing.T) {
+	s, conns := connectTestClients(t, 3)
+
+	errs := s.Multicast(&TestPacket{}, []server.ClientID{0, 2, 2})
+	assert.Nil(t, errs)
+	assertReceived(t, conns, 0, 2)
+
+	errs = s.Multicast(&TestPacket{}, []server.ClientID{1, 10})
+	assert.Equal(t, map[server.ClientID]error{10: &server.InvalidClientID{ClientID: 10}}, errs)
+	assertReceived(t, conns, 1)
+
+	assert.Nil(t, s.Multicast(&TestPacket{}, nil))
+
+	s.Stop()
+	errs = s.Multicast(&TestPacket{}, []server.ClientID{0})
+	assert.Equal(t, map[server.ClientID]error{0: &server.InvalidClientID{ClientID: 0}}, errs)
+}
+
+func TestTCPServerBroadcastWhere(t *testing.T) {
+	s, conns := connectTestClients(t, 4)
+
+	errs := s.BroadcastWhere(&TestPacket{}, func(clientID server.ClientID) bool {
+		return clientID%2 == 1
+	})
+	assert.Nil(t, errs)
+	assertReceived(t, conns, 1, 3)
+
+	errs = s.BroadcastWhere(&TestPacket{}, func(clientID server.ClientID) bool {
+		return false
+	})
+	assert.Nil(t, errs)
+	assertReceived(t, conns)
+}
+
 func TestTCPServerReceivePacketInvalidClientID(t *testing.T) {
 	s, err := server.NewTCPServer(10, nil, nil)
 	assert.NotNil(t, s)
@@ -290,7 +398,7 @@ func TestTCPServerReceivePacketDisconnected(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err = s.ReceivePacket(clientID)
+		err := s.ReceivePacket(clientID)
 		assert.IsType(t, &common.DisconnectErr{}, err)
 		wg.Done()
 	}()
@@ -311,12 +419,12 @@ func TestTCPServerReceivePacketNotRegistered(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err = s.ReceivePacket(clientID)
+		err := s.ReceivePacket(clientID)
 		assert.IsType(t, &common.PacketNotRegisteredErr{}, err)
 		wg.Done()
 	}()
 
-	clientConn.Write([]byte("test data"))
+	common.WriteFrame(clientConn, 1, []byte("test data"))
 
 	wg.Wait()
 }
@@ -335,14 +443,12 @@ func TestTCPServerReceivePacketUnmarshalFailure(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err = s.ReceivePacket(clientID)
+		err := s.ReceivePacket(clientID)
 		assert.Error(t, err)
 		wg.Done()
 	}()
 
-	data := []byte{0}
-	data = append(data, []byte("unknown data")...)
-	clientConn.Write(data)
+	common.WriteFrame(clientConn, 0, []byte("unknown data"))
 
 	wg.Wait()
 }
@@ -361,14 +467,12 @@ func TestTCPServerReceivePacketSuccess(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		err = s.ReceivePacket(clientID)
+		err := s.ReceivePacket(clientID)
 		assert.NoError(t, err)
 		wg.Done()
 	}()
 
-	data := []byte{0}
-	data = append(data, []byte("test data")...)
-	clientConn.Write(data)
+	common.WriteFrame(clientConn, 0, []byte("test data"))
 
 	wg.Wait()
 }
@@ -384,3 +488,514 @@ func TestTCPServerRegisterPacketType(t *testing.T) {
 	err = s.RegisterPacketType(&TestPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {})
 	assert.IsType(t, &common.PacketRegisteredErr{}, err)
 }
+
+func TestTCPServerReceivePacketSplitWrites(t *testing.T) {
+	s, err := server.NewTCPServer(10, nil, nil)
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	received := 0
+	err = s.RegisterPacketType(&TestPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received++
+	})
+	assert.NoError(t, err)
+
+	serverConn, clientConn := net.Pipe()
+	clientID := s.AddNewConnection(serverConn)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		err := s.ReceivePacket(clientID)
+		assert.NoError(t, err)
+		wg.Done()
+	}()
+
+	var buffer bytes.Buffer
+	common.WriteFrame(&buffer, 0, []byte("test data"))
+	data := buffer.Bytes()
+
+	clientConn.Write(data[:3])
+	time.Sleep(time.Millisecond * 10)
+	clientConn.Write(data[3:8])
+	time.Sleep(time.Millisecond * 10)
+	clientConn.Write(data[8:])
+
+	wg.Wait()
+
+	assert.Equal(t, 1, received)
+}
+
+func TestTCPServerReceivePacketMergedWrites(t *testing.T) {
+	s, err := server.NewTCPServer(10, nil, nil)
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	received := 0
+	err = s.RegisterPacketType(&TestPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received++
+	})
+	assert.NoError(t, err)
+
+	serverConn, clientConn := net.Pipe()
+	clientID := s.AddNewConnection(serverConn)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			err := s.ReceivePacket(clientID)
+			assert.NoError(t, err)
+		}
+		wg.Done()
+	}()
+
+	var buffer bytes.Buffer
+	for i := 0; i < 3; i++ {
+		common.WriteFrame(&buffer, 0, []byte("test data"))
+	}
+
+	clientConn.Write(buffer.Bytes())
+
+	wg.Wait()
+
+	assert.Equal(t, 3, received)
+}
+
+type TestDataPacket struct {
+	Data []byte
+}
+
+func (p TestDataPacket) ID() uint16 {
+	return 1
+}
+
+func (p *TestDataPacket) Write(buffer []byte) (int, error) {
+	p.Data = append([]byte{}, buffer...)
+	return len(buffer), nil
+}
+
+func (p TestDataPacket) Read(buffer []byte) (int, error) {
+	return copy(buffer, p.Data), nil
+}
+
+func TestTCPServerReceivePacketConcurrentClients(t *testing.T) {
+	const clientCount = 20
+	const packetsPerClient = 10
+
+	var mutex sync.Mutex
+	var received []*TestDataPacket
+	var wg sync.WaitGroup
+	wg.Add(clientCount * packetsPerClient)
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&TestDataPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		mutex.Lock()
+		received = append(received, p.(*TestDataPacket))
+		mutex.Unlock()
+		wg.Done()
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+
+	time.Sleep(time.Millisecond * 10)
+	addr := s.Addr().String()
+
+	for i := 0; i < clientCount; i++ {
+		go func(i int) {
+			conn, err := dial(addr)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+
+			for j := 0; j < packetsPerClient; j++ {
+				common.WriteFrame(conn, 1, []byte{byte(i), byte(j)})
+			}
+
+			time.Sleep(time.Millisecond * 100)
+		}(i)
+	}
+
+	wg.Wait()
+
+	// Every callback must have been handed its own packet holding the data it was sent with
+	seen := make(map[[2]byte]bool)
+	for _, p := range received {
+		assert.Len(t, p.Data, 2)
+		seen[[2]byte{p.Data[0], p.Data[1]}] = true
+	}
+
+	assert.Len(t, seen, clientCount*packetsPerClient)
+}
+
+func TestTCPServerStartContextCancel(t *testing.T) {
+	disconnected := make(chan error, 1)
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	})
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- s.StartContext(ctx, "0")
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	time.Sleep(time.Millisecond * 10)
+	cancelFunc()
+
+	// The client is told the server is going away before its connection is closed
+	r := common.NewFrameReader(conn, 10)
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsControl())
+	assert.Equal(t, common.ControlGoodbye, common.ControlType(frame.PacketID))
+
+	reason, _, err := common.ParseGoodbye(frame.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, common.CloseGoingAway, reason)
+
+	_, err = r.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+	conn.Close()
+
+	assert.NoError(t, <-startErr)
+	assert.Equal(t, &common.DisconnectErr{Reason: common.CloseGoingAway}, <-disconnected)
+
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestTCPServerShutdownWaitsForConnections(t *testing.T) {
+	var disconnected int
+	var mutex sync.Mutex
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {
+		time.Sleep(time.Millisecond * 20)
+		mutex.Lock()
+		disconnected++
+		mutex.Unlock()
+	})
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- s.Start("0")
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+
+	for i := 0; i < 3; i++ {
+		conn, err := dial(s.Addr().String())
+		assert.NoError(t, err)
+
+		// Close each connection once the server has said goodbye
+		go func() {
+			io.Copy(ioutil.Discard, conn)
+			conn.Close()
+		}()
+	}
+
+	time.Sleep(time.Millisecond * 10)
+
+	err = s.Shutdown(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, <-startErr)
+
+	mutex.Lock()
+	assert.Equal(t, 3, disconnected)
+	mutex.Unlock()
+
+	err = s.SendPacket(0, &TestPacket{})
+	assert.IsType(t, &server.ServerClosedErr{}, err)
+}
+
+func TestTCPServerShutdownContextExpired(t *testing.T) {
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {
+		time.Sleep(time.Millisecond * 100)
+	})
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	time.Sleep(time.Millisecond * 10)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancelFunc()
+
+	err = s.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestTCPServerDisconnectsOnProtocolError(t *testing.T) {
+	disconnected := make(chan error, 1)
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	})
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	common.WriteFrame(conn, 5, []byte("unknown"))
+
+	r := common.NewFrameReader(conn, 100)
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsControl())
+
+	reason, _, err := common.ParseGoodbye(frame.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, common.CloseProtocolError, reason)
+
+	_, err = r.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+	conn.Close()
+
+	err = <-disconnected
+	if assert.IsType(t, &common.ProtocolErr{}, err) {
+		assert.IsType(t, &common.PacketNotRegisteredErr{}, err.(*common.ProtocolErr).Err)
+	}
+}
+
+func TestTCPServerHeartbeatEvictsIdleClient(t *testing.T) {
+	disconnected := make(chan error, 1)
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	}, server.WithHeartbeat(time.Millisecond*10, time.Millisecond*50))
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// The client is pinged but never answers
+	r := common.NewFrameReader(conn, 10)
+	frame, err := r.ReadFrame()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsControl())
+	assert.Equal(t, common.ControlPing, common.ControlType(frame.PacketID))
+
+	select {
+	case err := <-disconnected:
+		assert.IsType(t, &common.TimeoutErr{}, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("idle client was not evicted")
+	}
+}
+
+func TestTCPServerHeartbeatKeepsClientConnected(t *testing.T) {
+	disconnected := make(chan error, 1)
+
+	s, err := server.NewTCPServer(10, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	}, server.WithHeartbeat(time.Millisecond*10, time.Millisecond*50))
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTCPClient(10)
+	assert.NoError(t, err)
+
+	err = c.Connect(s.Addr().String())
+	assert.NoError(t, err)
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- c.Run(context.Background())
+	}()
+
+	// The client answers pings without registering anything, so it outlives the idle timeout
+	select {
+	case err := <-disconnected:
+		t.Fatalf("client was disconnected: %v", err)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+	assert.NoError(t, <-runErr)
+	assert.Equal(t, &common.DisconnectErr{Reason: common.CloseNormal}, <-disconnected)
+}
+
+type benchmarkPacket struct {
+	Message string
+}
+
+func (p benchmarkPacket) ID() uint16 {
+	return 20
+}
+
+// newBenchmarkServer returns a server with the max packet size of the example chat
+// and adds the given number of clients that read and discard everything sent to them
+func newBenchmarkServer(b *testing.B, clients int, opts ...server.Option) (*server.TCPServer, []server.ClientID) {
+	s, err := server.NewTCPServer(65535, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {}, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(s.Stop)
+
+	err = s.RegisterPacketType(&benchmarkPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	clientIDs := make([]server.ClientID, clients)
+	for i := range clientIDs {
+		serverConn, clientConn := net.Pipe()
+		go io.Copy(ioutil.Discard, clientConn)
+		clientIDs[i] = s.AddNewConnection(serverConn)
+	}
+
+	return s, clientIDs
+}
+
+func BenchmarkTCPServerSendPacket(b *testing.B) {
+	s, clientIDs := newBenchmarkServer(b, 1)
+	p := &benchmarkPacket{Message: "hello, this is a chat message"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.SendPacket(clientIDs[0], p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTCPServerBroadcastPacket(b *testing.B) {
+	s, _ := newBenchmarkServer(b, 10)
+	p := &benchmarkPacket{Message: "hello, this is a chat message"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if errs := s.BroadcastPacket(p, -1); errs != nil {
+			b.Fatal(errs)
+		}
+	}
+}
+
+func BenchmarkTCPServerBroadcastPacketWriteQueue(b *testing.B) {
+	s, _ := newBenchmarkServer(b, 10, server.WithWriteQueue(64, server.OverflowDropOldest))
+	p := &benchmarkPacket{Message: "hello, this is a chat message"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if errs := s.BroadcastPacket(p, -1); errs != nil {
+			b.Fatal(errs)
+		}
+	}
+}
+
+// benchmarkLargeRoom sends a chat message to 1000 clients with the given function. The clients have write
+// queues, so the benchmark measures the cost of preparing the frames rather than that of writing them.
+func benchmarkLargeRoom(b *testing.B, send func(s *server.TCPServer, clientIDs []server.ClientID, p common.Packet)) {
+	s, clientIDs := newBenchmarkServer(b, 1000, server.WithWriteQueue(64, server.OverflowDropOldest))
+	p := &benchmarkPacket{Message: strings.Repeat("hello, this is a chat message. ", 16)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		send(s, clientIDs, p)
+	}
+}
+
+func BenchmarkTCPServerSendPacket1000Clients(b *testing.B) {
+	benchmarkLargeRoom(b, func(s *server.TCPServer, clientIDs []server.ClientID, p common.Packet) {
+		for _, clientID := range clientIDs {
+			if err := s.SendPacket(clientID, p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkTCPServerBroadcastPacket1000Clients(b *testing.B) {
+	benchmarkLargeRoom(b, func(s *server.TCPServer, clientIDs []server.ClientID, p common.Packet) {
+		if errs := s.BroadcastPacket(p, -1); errs != nil {
+			b.Fatal(errs)
+		}
+	})
+}
+
+func BenchmarkTCPServerReceivePacket(b *testing.B) {
+	s, err := server.NewTCPServer(65535, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Stop()
+
+	err = s.RegisterPacketType(&benchmarkPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	clientID := s.AddNewConnection(serverConn)
+
+	buffer := make([]byte, 100)
+	n, err := common.DefaultCodec.Encode(buffer, &benchmarkPacket{Message: "hello, this is a chat message"})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var frames bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		common.WriteFrame(&frames, benchmarkPacket{}.ID(), buffer[:n])
+	}
+
+	go func() {
+		for {
+			if _, err := clientConn.Write(frames.Bytes()); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.ReceivePacket(clientID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+)
+
+var _ Server = (*TLSServer)(nil)
+
+// TLSServer is a server that communicates with clients via TCP secured with TLS.
+// Setting ClientAuth in its config to tls.RequireAndVerifyClientCert enables mutual TLS,
+// in which case the subject of each client's certificate is available through Identity.
+type TLSServer struct {
+	*TCPServer
+}
+
+// NewTLSServer returns an initialized TLS server ready to start
+// listening for incoming client connections
+func NewTLSServer(maxPacketSize int, config *tls.Config, onClientConnected func(clientID ClientID), onClientDisconnected func(clientID ClientID, err error), opts ...Option) (*TLSServer, error) {
+	s, err := NewTCPServer(maxPacketSize, onClientConnected, onClientDisconnected, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.listen = func(port string) (net.Listener, error) {
+		return tls.Listen("tcp", ":"+port, config)
+	}
+
+	return &TLSServer{TCPServer: s}, nil
+}
+
+// handshakeTLS completes the TLS handshake of a newly accepted connection,
+// so a client is only admitted once its certificate has been verified
+func handshakeTLS(conn *tls.Conn) (Identity, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return Identity{}, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return Identity{}, nil
+	}
+
+	return identityFromCertificate(state.PeerCertificates[0]), nil
+}
+
+func identityFromCertificate(cert *x509.Certificate) Identity {
+	return Identity{Name: cert.Subject.CommonName}
+}
@@ -0,0 +1,205 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCA is a certificate authority generated for a single test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gochat test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue creates a certificate signed by the CA that is valid for both servers on localhost and clients
+func (ca *testCA) issue(t *testing.T, commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func localAddr(s server.Server) string {
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func TestTLSServerAndClient(t *testing.T) {
+	ca := newTestCA(t)
+
+	received := make(chan struct{}, 1)
+	s, err := server.NewTLSServer(10, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server")},
+	}, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	err = s.RegisterPacketType(&TestPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received <- struct{}{}
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTLSClient(10, &tls.Config{RootCAs: ca.pool})
+	assert.NoError(t, err)
+
+	err = c.Connect(localAddr(s))
+	assert.NoError(t, err)
+
+	err = c.SendPacket(&TestPacket{})
+	assert.NoError(t, err)
+
+	<-received
+}
+
+func TestTLSClientUntrustedServer(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+
+	s, err := server.NewTLSServer(10, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server")},
+	}, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTLSClient(10, &tls.Config{RootCAs: otherCA.pool})
+	assert.NoError(t, err)
+
+	err = c.Connect(localAddr(s))
+	assert.IsType(t, &client.ConnectErr{}, err)
+}
+
+func TestTLSServerMutualTLSIdentity(t *testing.T) {
+	ca := newTestCA(t)
+
+	var s *server.TLSServer
+	identities := make(chan server.Identity, 1)
+
+	s, err := server.NewTLSServer(10, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, func(clientID server.ClientID) {
+		identity, err := s.Identity(clientID)
+		assert.NoError(t, err)
+		identities <- identity
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTLSClient(10, &tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{ca.issue(t, "alice")},
+	})
+	assert.NoError(t, err)
+
+	err = c.Connect(localAddr(s))
+	assert.NoError(t, err)
+
+	assert.Equal(t, server.Identity{Name: "alice"}, <-identities)
+}
+
+func TestTLSServerMutualTLSMissingCertificate(t *testing.T) {
+	ca := newTestCA(t)
+
+	connected := make(chan struct{}, 1)
+	s, err := server.NewTLSServer(10, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "server")},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, func(clientID server.ClientID) {
+		connected <- struct{}{}
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewTLSClient(10, &tls.Config{RootCAs: ca.pool})
+	assert.NoError(t, err)
+
+	// With TLS 1.3 the client only learns it was rejected once it tries to use the connection
+	if err := c.Connect(localAddr(s)); err == nil {
+		err = c.ReceivePacket()
+		assert.Error(t, err)
+	}
+
+	select {
+	case <-connected:
+		t.Fatal("client without a certificate was admitted")
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestTCPServerIdentityInvalidClientID(t *testing.T) {
+	s, err := server.NewTCPServer(10, nil, nil)
+	assert.NoError(t, err)
+
+	_, err = s.Identity(-1)
+	assert.IsType(t, &server.InvalidClientID{}, err)
+}
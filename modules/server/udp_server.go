@@ -0,0 +1,275 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+var _ Server = (*UDPServer)(nil)
+
+const (
+	// defaultUDPIdleTimeout is how long a UDP session may go without hearing from its client unless configured otherwise
+	defaultUDPIdleTimeout = 30 * time.Second
+
+	// maxPendingUDPSessions is how many UDP sessions may wait for the first datagram from their client at once.
+	// Connect requests can come from spoofed addresses that never answer, so they must not be able to use up memory.
+	maxPendingUDPSessions = 128
+)
+
+// UDPServer is a server that communicates with clients via UDP. Clients are identified by a session
+// token handed out when they connect along with the address they send from, so a client whose
+// address changes must connect again. A session is only handed to the server once its client sends
+// something with its token, and ends once the client has not been heard from for the idle timeout.
+// Packets are unreliable unless their type is made reliable with SetDeliveryMode.
+type UDPServer struct {
+	*TCPServer
+
+	deliveryModes *common.DeliveryModes
+}
+
+// NewUDPServer returns an initialized UDP server ready to start
+// listening for incoming client connections
+func NewUDPServer(maxPacketSize int, onClientConnected func(clientID ClientID), onClientDisconnected func(clientID ClientID, err error), opts ...Option) (*UDPServer, error) {
+	s, err := NewTCPServer(maxPacketSize, onClientConnected, onClientDisconnected, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveryModes := common.NewDeliveryModes()
+	s.listen = func(port string) (net.Listener, error) {
+		return listenUDP(port, deliveryModes, s.udpIdleTimeout)
+	}
+
+	return &UDPServer{
+		TCPServer:     s,
+		deliveryModes: deliveryModes,
+	}, nil
+}
+
+// WithUDPIdleTimeout sets how long a UDP server keeps the session of a client it has not heard from, which
+// defaults to 30 seconds. Clients keep their sessions alive while idle by sending something every 5 seconds,
+// so the timeout should be several times longer than that. A timeout of 0 keeps sessions until they are closed.
+func WithUDPIdleTimeout(timeout time.Duration) Option {
+	return func(s *TCPServer) {
+		s.udpIdleTimeout = timeout
+	}
+}
+
+// SetDeliveryMode sets how packets of the given type are sent to clients.
+// Clients must use the same delivery mode for packets they send.
+// Packet types registered by name must be registered before their delivery mode is set.
+func (s *UDPServer) SetDeliveryMode(p common.Packet, mode common.DeliveryMode) {
+	if packetID, err := s.packetID(p); err == nil {
+		s.deliveryModes.Set(packetID, mode)
+	}
+}
+
+// udpSessionKey identifies a session by the address of its client along with its token. Datagrams carrying
+// the token of a session from any other address are dropped, so a token seen on the network cannot be used
+// to take over the session. A client whose address changes must connect again.
+type udpSessionKey struct {
+	addr  string
+	token uint64
+}
+
+// udpConnectKey identifies a connect request that has already been
+// answered, so a retransmitted request does not open a second session
+type udpConnectKey struct {
+	addr  string
+	nonce uint64
+}
+
+// udpListener is a net.Listener that demultiplexes the datagrams
+// received on a single UDP socket into one connection per client session
+type udpListener struct {
+	packetConn    net.PacketConn
+	deliveryModes *common.DeliveryModes
+	idleTimeout   time.Duration
+
+	sessions map[udpSessionKey]*common.DatagramConn
+	accepted map[udpConnectKey]uint64
+	// pending holds the sessions whose clients have not sent anything since connecting, which are
+	// closed by their timers unless the client is heard from before handshakeTimeout
+	pending map[udpSessionKey]*time.Timer
+	mutex   sync.Mutex
+
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func listenUDP(port string, deliveryModes *common.DeliveryModes, idleTimeout time.Duration) (*udpListener, error) {
+	packetConn, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &udpListener{
+		packetConn:    packetConn,
+		deliveryModes: deliveryModes,
+		idleTimeout:   idleTimeout,
+		sessions:      make(map[udpSessionKey]*common.DatagramConn),
+		accepted:      make(map[udpConnectKey]uint64),
+		pending:       make(map[udpSessionKey]*time.Timer),
+		conns:         make(chan net.Conn),
+		closed:        make(chan struct{}),
+	}
+
+	go l.receive()
+
+	return l, nil
+}
+
+// receive reads datagrams from the socket and hands them to the session they belong to
+func (l *udpListener) receive() {
+	buffer := make([]byte, common.MaxDatagramSize)
+
+	for {
+		n, addr, err := l.packetConn.ReadFrom(buffer)
+		if err != nil {
+			l.Close()
+			return
+		}
+
+		d, err := common.ParseDatagram(buffer[:n])
+		if err != nil {
+			continue
+		}
+
+		if d.Type == common.DatagramConnect {
+			l.connect(addr, d.Token)
+			continue
+		}
+
+		session := udpSessionKey{addr: addr.String(), token: d.Token}
+
+		l.mutex.Lock()
+		conn, ok := l.sessions[session]
+		timer, pending := l.pending[session]
+		if pending {
+			timer.Stop()
+			delete(l.pending, session)
+		}
+		l.mutex.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		if pending {
+			l.hand(conn)
+		}
+
+		conn.Deliver(d)
+	}
+}
+
+// connect opens a new session for a client, or answers again if the session was already opened
+func (l *udpListener) connect(addr net.Addr, nonce uint64) {
+	key := udpConnectKey{addr: addr.String(), nonce: nonce}
+
+	l.mutex.Lock()
+	token, ok := l.accepted[key]
+	if ok {
+		l.mutex.Unlock()
+		l.accept(addr, token, nonce)
+		return
+	}
+
+	if len(l.pending) >= maxPendingUDPSessions {
+		l.mutex.Unlock()
+		return
+	}
+
+	token, err := newSessionToken()
+	if err != nil {
+		l.mutex.Unlock()
+		return
+	}
+
+	session := udpSessionKey{addr: addr.String(), token: token}
+	conn := common.NewDatagramConn(token, l.packetConn.LocalAddr(), addr, func(datagram []byte) error {
+		_, err := l.packetConn.WriteTo(datagram, addr)
+		return err
+	}, l.deliveryModes, func() {
+		l.mutex.Lock()
+		if timer, ok := l.pending[session]; ok {
+			timer.Stop()
+			delete(l.pending, session)
+		}
+		delete(l.sessions, session)
+		delete(l.accepted, key)
+		l.mutex.Unlock()
+	})
+	conn.SetIdleTimeout(l.idleTimeout)
+
+	l.sessions[session] = conn
+	l.accepted[key] = token
+	l.pending[session] = time.AfterFunc(handshakeTimeout, func() {
+		conn.Close()
+	})
+	l.mutex.Unlock()
+
+	l.accept(addr, token, nonce)
+}
+
+// hand hands a session whose client has been heard from to Accept
+func (l *udpListener) hand(conn *common.DatagramConn) {
+	go func() {
+		select {
+		case l.conns <- conn:
+		case <-l.closed:
+			conn.Close()
+		}
+	}()
+}
+
+// accept tells a client which session token it has been given
+func (l *udpListener) accept(addr net.Addr, token uint64, nonce uint64) {
+	var payload [8]byte
+	binary.LittleEndian.PutUint64(payload[:], nonce)
+
+	l.packetConn.WriteTo(common.AppendDatagram(nil, common.Datagram{
+		Type:    common.DatagramAccept,
+		Token:   token,
+		Payload: payload[:],
+	}), addr)
+}
+
+func newSessionToken() (uint64, error) {
+	var token [8]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(token[:]), nil
+}
+
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("udp listener closed")
+	}
+}
+
+func (l *udpListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		err = l.packetConn.Close()
+	})
+
+	return err
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.packetConn.LocalAddr()
+}
@@ -0,0 +1,236 @@
+package server_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPServerStartListenFailure(t *testing.T) {
+	s, err := server.NewUDPServer(10, nil, nil)
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	err = s.Start("-1")
+	assert.IsType(t, &server.ListenErr{}, err)
+}
+
+func TestUDPServerAndClient(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	disconnected := make(chan error, 1)
+	received := make(chan *TestDataPacket, 10)
+
+	s, err := server.NewUDPServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	})
+	assert.NoError(t, err)
+
+	s.SetDeliveryMode(&TestDataPacket{}, common.ReliableOrdered)
+	err = s.RegisterPacketType(&TestDataPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		received <- p.(*TestDataPacket)
+		s.SendPacket(clientID, p)
+	})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	c, err := client.NewUDPClient(10)
+	assert.NoError(t, err)
+
+	c.SetDeliveryMode(&TestDataPacket{}, common.ReliableOrdered)
+	echoed := make(chan *TestDataPacket, 10)
+	err = c.RegisterPacketType(&TestDataPacket{}, func(conn net.Conn, p common.Packet) {
+		echoed <- p.(*TestDataPacket)
+	})
+	assert.NoError(t, err)
+
+	err = c.Connect(localAddr(s))
+	assert.NoError(t, err)
+
+	<-connected
+
+	for i := 0; i < 5; i++ {
+		err = c.SendPacket(&TestDataPacket{Data: []byte{byte(i)}})
+		assert.NoError(t, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, []byte{byte(i)}, (<-received).Data)
+
+		err = c.ReceivePacket()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{byte(i)}, (<-echoed).Data)
+	}
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+	assert.Equal(t, &common.DisconnectErr{Reason: common.CloseNormal}, <-disconnected)
+}
+
+func TestUDPClientConnectTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	c, err := client.NewUDPClient(10)
+	assert.NoError(t, err)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancelFunc()
+
+	err = c.ConnectContext(ctx, conn.LocalAddr().String())
+	assert.IsType(t, &client.ConnectErr{}, err)
+}
+
+func TestUDPServerDropsDatagramsFromOtherAddresses(t *testing.T) {
+	s, err := server.NewUDPServer(100, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	owner, err := net.Dial("udp", localAddr(s))
+	assert.NoError(t, err)
+	defer owner.Close()
+
+	_, err = owner.Write(common.AppendDatagram(nil, common.Datagram{Type: common.DatagramConnect, Token: 42}))
+	assert.NoError(t, err)
+
+	buffer := make([]byte, common.MaxDatagramSize)
+	owner.SetReadDeadline(time.Now().Add(time.Second * 5))
+	n, err := owner.Read(buffer)
+	assert.NoError(t, err)
+
+	accept, err := common.ParseDatagram(buffer[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, common.DatagramAccept, accept.Type)
+	assert.Equal(t, uint64(42), binary.LittleEndian.Uint64(accept.Payload))
+
+	hello := common.AppendDatagram(nil, common.Datagram{
+		Type:    common.DatagramReliable,
+		Token:   accept.Token,
+		Payload: common.EncodeHello(common.Hello{Version: common.ProtocolVersion}),
+	})
+
+	// Another address that learned the token cannot use the session, so its datagram is never acknowledged
+	other, err := net.Dial("udp", localAddr(s))
+	assert.NoError(t, err)
+	defer other.Close()
+
+	_, err = other.Write(hello)
+	assert.NoError(t, err)
+
+	other.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	_, err = other.Read(buffer)
+	assert.Error(t, err)
+
+	_, err = owner.Write(hello)
+	assert.NoError(t, err)
+
+	owner.SetReadDeadline(time.Now().Add(time.Second * 5))
+	n, err = owner.Read(buffer)
+	assert.NoError(t, err)
+
+	ack, err := common.ParseDatagram(buffer[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, common.Datagram{Type: common.DatagramAck, Token: accept.Token, Seq: 0, Payload: []byte{}}, ack)
+}
+
+func TestUDPServerPendingSessionsLimit(t *testing.T) {
+	s, err := server.NewUDPServer(100, func(clientID server.ClientID) {}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := net.Dial("udp", localAddr(s))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// None of the sessions hear from their client again, so only so many of them are opened
+	for nonce := uint64(1); nonce <= 200; nonce++ {
+		_, err = conn.Write(common.AppendDatagram(nil, common.Datagram{Type: common.DatagramConnect, Token: nonce}))
+		assert.NoError(t, err)
+	}
+
+	accepted := 0
+	buffer := make([]byte, common.MaxDatagramSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+		n, err := conn.Read(buffer)
+		if err != nil {
+			break
+		}
+
+		if d, err := common.ParseDatagram(buffer[:n]); err == nil && d.Type == common.DatagramAccept {
+			accepted++
+		}
+	}
+
+	assert.Equal(t, 128, accepted)
+}
+
+func TestUDPServerIdleTimeout(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	disconnected := make(chan error, 1)
+
+	s, err := server.NewUDPServer(100, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	}, server.WithUDPIdleTimeout(time.Millisecond*200))
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := net.Dial("udp", localAddr(s))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(common.AppendDatagram(nil, common.Datagram{Type: common.DatagramConnect, Token: 42}))
+	assert.NoError(t, err)
+
+	buffer := make([]byte, common.MaxDatagramSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	n, err := conn.Read(buffer)
+	assert.NoError(t, err)
+
+	accept, err := common.ParseDatagram(buffer[:n])
+	assert.NoError(t, err)
+
+	_, err = conn.Write(common.AppendDatagram(nil, common.Datagram{
+		Type:    common.DatagramReliable,
+		Token:   accept.Token,
+		Payload: common.EncodeHello(common.Hello{Version: common.ProtocolVersion}),
+	}))
+	assert.NoError(t, err)
+
+	// The client goes quiet once it is admitted, so the server ends its session
+	select {
+	case <-connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("client was not admitted")
+	}
+
+	select {
+	case err := <-disconnected:
+		assert.Error(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("idle client was not disconnected")
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+var _ Server = (*WebSocketServer)(nil)
+
+// WebSocketServer is a server that communicates with clients via WebSockets,
+// carrying each packet's frame in a single binary WebSocket message
+type WebSocketServer struct {
+	*TCPServer
+
+	path     string
+	listener net.Listener
+	mutex    sync.RWMutex
+}
+
+// NewWebSocketServer returns an initialized WebSocket server ready to start
+// listening for incoming client connections on the given HTTP path
+func NewWebSocketServer(maxPacketSize int, path string, onClientConnected func(clientID ClientID), onClientDisconnected func(clientID ClientID, err error), opts ...Option) (*WebSocketServer, error) {
+	s, err := NewTCPServer(maxPacketSize, onClientConnected, onClientDisconnected, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.WebSocket(path), nil
+}
+
+// WebSocket returns a WebSocket server that shares this server's connections, registered
+// packet types and callbacks. Clients connecting over either transport join the same chat,
+// and stopping or shutting down either server disconnects the clients of both.
+func (s *TCPServer) WebSocket(path string) *WebSocketServer {
+	return &WebSocketServer{
+		TCPServer: s,
+		path:      path,
+	}
+}
+
+func (s *WebSocketServer) Start(port string) error {
+	return s.StartContext(context.Background(), port)
+}
+
+func (s *WebSocketServer) StartContext(ctx context.Context, port string) error {
+	listener, err := listenWebSocket(port, s.path)
+	if err != nil {
+		return &ListenErr{Port: port, Err: err}
+	}
+
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+
+	return s.serve(ctx, listener)
+}
+
+func (s *WebSocketServer) Addr() net.Addr {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+
+	return nil
+}
+
+// webSocketListener is a net.Listener that accepts WebSocket connections
+// from an HTTP server serving the WebSocket handshake on a single path
+type webSocketListener struct {
+	tcpListener net.Listener
+	httpServer  *http.Server
+	conns       chan net.Conn
+	closed      chan struct{}
+	once        sync.Once
+}
+
+func listenWebSocket(port string, path string) (*webSocketListener, error) {
+	tcpListener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &webSocketListener{
+		tcpListener: tcpListener,
+		conns:       make(chan net.Conn),
+		closed:      make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, websocket.Server{Handler: l.handle})
+	// Clients that never finish their upgrade request are not counted by any of the server's limits yet,
+	// so they are given as long to send it as any other step of being admitted
+	l.httpServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: handshakeTimeout,
+		IdleTimeout:       handshakeTimeout,
+	}
+
+	go l.httpServer.Serve(tcpListener)
+
+	return l, nil
+}
+
+// handle hands a new WebSocket connection to Accept. The connection is closed
+// by the WebSocket package once handle returns, so it blocks until the server is done with it.
+func (l *webSocketListener) handle(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+
+	conn := &webSocketConn{
+		Conn:   ws,
+		closed: make(chan struct{}),
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		return
+	}
+
+	<-conn.closed
+}
+
+func (l *webSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("websocket listener closed")
+	}
+}
+
+func (l *webSocketListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		err = l.httpServer.Close()
+	})
+
+	return err
+}
+
+func (l *webSocketListener) Addr() net.Addr {
+	return l.tcpListener.Addr()
+}
+
+// webSocketConn is a WebSocket connection that signals when it has been closed
+type webSocketConn struct {
+	*websocket.Conn
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+// RemoteAddr returns the address the client connected from. The embedded websocket.Conn reports the origin of
+// the WebSocket handshake instead, which is not an address at all and may be missing.
+func (c *webSocketConn) RemoteAddr() net.Addr {
+	if addr, err := net.ResolveTCPAddr("tcp", c.Request().RemoteAddr); err == nil {
+		return addr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *webSocketConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		close(c.closed)
+	})
+
+	return err
+}
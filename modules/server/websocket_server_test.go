@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/client"
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+)
+
+func webSocketURL(s server.Server) string {
+	_, port, _ := net.SplitHostPort(s.Addr().String())
+	return "ws://127.0.0.1:" + port + "/chat"
+}
+
+func TestWebSocketServerStartListenFailure(t *testing.T) {
+	s, err := server.NewWebSocketServer(10, "/chat", nil, nil)
+	assert.NotNil(t, s)
+	assert.NoError(t, err)
+
+	err = s.Start("-1")
+	assert.IsType(t, &server.ListenErr{}, err)
+}
+
+func TestWebSocketServerOneFramePerMessage(t *testing.T) {
+	connected := make(chan server.ClientID, 1)
+	s, err := server.NewWebSocketServer(10, "/chat", func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	ws, err := websocket.Dial(webSocketURL(s), "", "http://localhost/")
+	assert.NoError(t, err)
+	defer ws.Close()
+
+	err = websocket.Message.Send(ws, common.EncodeHello(common.Hello{Version: common.ProtocolVersion}))
+	assert.NoError(t, err)
+
+	var serverHello []byte
+	err = websocket.Message.Receive(ws, &serverHello)
+	assert.NoError(t, err)
+
+	clientID := <-connected
+	for i := 0; i < 2; i++ {
+		err = s.SendPacket(clientID, &TestPacket{})
+		assert.NoError(t, err)
+	}
+
+	var expected bytes.Buffer
+	common.WriteFrame(&expected, 0, []byte("test data"))
+
+	for i := 0; i < 2; i++ {
+		var message []byte
+		err = websocket.Message.Receive(ws, &message)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.Bytes(), message)
+	}
+}
+
+func TestWebSocketServerSharedWithTCPServer(t *testing.T) {
+	connected := make(chan server.ClientID, 2)
+
+	tcpServer, err := server.NewTCPServer(10, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {})
+	assert.NoError(t, err)
+
+	err = tcpServer.RegisterPacketType(&TestPacket{}, func(clientID server.ClientID, conn net.Conn, p common.Packet) {
+		tcpServer.BroadcastPacket(p, clientID)
+	})
+	assert.NoError(t, err)
+
+	webSocketServer := tcpServer.WebSocket("/chat")
+
+	go tcpServer.Start("0")
+	go webSocketServer.Start("0")
+	defer tcpServer.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	received := make(chan struct{}, 1)
+	wsClient, err := client.NewWebSocketClient(10, "http://localhost/")
+	assert.NoError(t, err)
+
+	err = wsClient.RegisterPacketType(&TestPacket{}, func(conn net.Conn, p common.Packet) {
+		received <- struct{}{}
+	})
+	assert.NoError(t, err)
+
+	err = wsClient.Connect(webSocketURL(webSocketServer))
+	assert.NoError(t, err)
+	defer wsClient.Disconnect()
+
+	tcpClient, err := client.NewTCPClient(10)
+	assert.NoError(t, err)
+
+	err = tcpClient.Connect(tcpServer.Addr().String())
+	assert.NoError(t, err)
+	defer tcpClient.Disconnect()
+
+	<-connected
+	<-connected
+
+	go wsClient.ReceivePacket()
+
+	// A packet from the TCP client is broadcast to the WebSocket client
+	err = tcpClient.SendPacket(&TestPacket{})
+	assert.NoError(t, err)
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("WebSocket client did not receive the broadcast")
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+)
+
+// OverflowPolicy decides what happens to a packet sent to a client whose write queue is full
+type OverflowPolicy uint8
+
+const (
+	// OverflowDropOldest drops the oldest packet waiting in the queue to make room for the new one.
+	// Control frames, such as pings and pongs, and responses to calls are never dropped to make room, so if
+	// nothing else is queued, the new packet is dropped and sending it fails with a *WriteQueueFullErr.
+	OverflowDropOldest OverflowPolicy = iota
+	// OverflowDropNewest drops the new packet, and sending it fails with a *WriteQueueFullErr
+	OverflowDropNewest
+	// OverflowDisconnect disconnects the client with common.CloseSlowConsumer, and sending the
+	// packet fails with a *WriteQueueFullErr, which is also reported to onClientDisconnected
+	OverflowDisconnect
+)
+
+// defaultWriteTimeout is how long a single write to a client may take unless WithWriteTimeout says otherwise
+const defaultWriteTimeout = 10 * time.Second
+
+// WithWriteTimeout sets how long a single write to a client may take before the client is disconnected with a
+// *common.SendErr, so a client that stops reading cannot hold up whoever is writing to it forever. It defaults
+// to 10 seconds, and a timeout of 0 lets writes take as long as they need.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *TCPServer) {
+		s.writeTimeout = timeout
+	}
+}
+
+// WithWriteQueue gives every client a queue of up to size packets that is written to the client by its own
+// goroutine, so sending to a client that is slow to read never holds up sending to the others. SendPacket returns
+// once the packet is queued, and a client whose connection fails to write is disconnected with a *common.SendErr.
+// The policy decides what happens once a client's queue is full. Without a write queue, SendPacket writes to the
+// client itself and returns once the write is done or the write timeout set by WithWriteTimeout passes.
+func WithWriteQueue(size int, policy OverflowPolicy) Option {
+	return func(s *TCPServer) {
+		s.writeQueueSize = size
+		s.overflowPolicy = policy
+	}
+}
+
+// WriteQueueFullErr is returned when a packet is sent to a client whose write queue is full
+type WriteQueueFullErr struct {
+	ClientID ClientID
+}
+
+func (e *WriteQueueFullErr) Error() string {
+	return fmt.Sprintf("write queue of client %d is full", e.ClientID)
+}
+
+// queuedFrame is a frame waiting to be written to a client
+type queuedFrame struct {
+	data []byte
+	// buffer is the pooled buffer holding data, or nil if data is shared with other clients by a PreparedPacket
+	buffer *[]byte
+}
+
+// evictable reports whether the frame may be dropped to make room for a newer one. Control frames and
+// responses are kept, as dropping them would time out the peer's pings and calls rather than lose a packet.
+func (f queuedFrame) evictable() bool {
+	return common.ParseFrameHeader(f.data).Flags&(common.FlagControl|common.FlagResponse) == 0
+}
+
+// release returns the buffer holding the frame to its pool once the frame has been written or dropped
+func (f queuedFrame) release() {
+	if f.buffer != nil {
+		common.PutBuffer(f.buffer)
+	}
+}
+
+// writeQueue holds the frames waiting to be written to a single client
+type writeQueue struct {
+	frames []queuedFrame
+	size   int
+	closed bool
+	cond   *sync.Cond
+	mutex  sync.Mutex
+
+	// done is closed once the writer has stopped
+	done chan struct{}
+}
+
+func newWriteQueue(size int) *writeQueue {
+	q := &writeQueue{
+		frames: make([]queuedFrame, 0, size),
+		size:   size,
+		done:   make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+
+	return q
+}
+
+var (
+	errQueueClosed = errors.New("the client is being disconnected")
+	errQueueFull   = errors.New("write queue is full")
+)
+
+// push adds a frame to the queue. If the queue is full, the oldest evictable frame is dropped to make room for it
+// if dropOldest is set, and otherwise the frame is not queued and errQueueFull is returned. Frames pushed after the
+// queue is closed are not queued either, and errQueueClosed is returned.
+func (q *writeQueue) push(frame queuedFrame, dropOldest bool) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		frame.release()
+		return errQueueClosed
+	}
+
+	if len(q.frames) >= q.size {
+		evict := -1
+		if dropOldest {
+			for i, queued := range q.frames {
+				if queued.evictable() {
+					evict = i
+					break
+				}
+			}
+		}
+
+		if evict < 0 {
+			frame.release()
+			return errQueueFull
+		}
+
+		q.frames[evict].release()
+		copy(q.frames[evict:], q.frames[evict+1:])
+		q.frames[len(q.frames)-1] = queuedFrame{}
+		q.frames = q.frames[:len(q.frames)-1]
+	}
+
+	q.frames = append(q.frames, frame)
+	q.cond.Signal()
+	return nil
+}
+
+// pop waits for the next frame to write. It returns false once the queue is closed and every frame has been taken.
+func (q *writeQueue) pop() (queuedFrame, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.frames) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+
+	if len(q.frames) == 0 {
+		return queuedFrame{}, false
+	}
+
+	frame := q.frames[0]
+	q.frames[0] = queuedFrame{}
+	q.frames = q.frames[1:]
+	return frame, true
+}
+
+// close stops the writer once it has written the frames already queued, or right away if discard is set.
+// Frames pushed after the queue is closed are dropped, and sending them fails.
+func (q *writeQueue) close(discard bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	if discard {
+		for _, frame := range q.frames {
+			frame.release()
+		}
+		q.frames = nil
+	}
+	q.cond.Broadcast()
+}
+
+// write writes the frames queued for the client until the queue is closed. If a write fails,
+// the client is disconnected and the failure is reported to onClientDisconnected.
+func (s *TCPServer) write(c *connection) {
+	defer close(c.queue.done)
+
+	for {
+		frame, ok := c.queue.pop()
+		if !ok {
+			return
+		}
+
+		if err := s.writeNow(c, frame); err != nil {
+			c.queue.close(true)
+			return
+		}
+	}
+}
+
+// enqueue queues a frame for the client, applying the server's overflow policy if its queue is full
+func (s *TCPServer) enqueue(c *connection, frame queuedFrame) error {
+	packetID := common.ParseFrameHeader(frame.data).PacketID
+
+	switch c.queue.push(frame, s.overflowPolicy == OverflowDropOldest) {
+	case nil:
+		return nil
+	case errQueueClosed:
+		return &common.SendErr{PacketID: packetID, Err: errQueueClosed}
+	}
+
+	err := &WriteQueueFullErr{ClientID: c.id}
+	if s.overflowPolicy == OverflowDisconnect {
+		c.queue.close(true)
+		go s.disconnect(c, common.CloseSlowConsumer, "", err)
+	}
+
+	return err
+}
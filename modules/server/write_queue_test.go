@@ -0,0 +1,301 @@
+package server_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rpj5582/gochat/modules/common"
+	"github.com/rpj5582/gochat/modules/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type sequencePacket struct {
+	N    int
+	Data []byte
+}
+
+func (p sequencePacket) ID() uint16 {
+	return 12
+}
+
+// readSequence decodes the sequence packets read from the connection and sends their numbers on received
+func readSequence(conn net.Conn, received chan<- int) {
+	r := common.NewFrameReader(conn, 1000)
+	for {
+		frame, err := r.ReadFrame()
+		if err != nil {
+			close(received)
+			return
+		}
+
+		var p sequencePacket
+		common.DecodePacket(&p, frame.Payload, nil)
+		received <- p.N
+	}
+}
+
+func TestTCPServerWriteQueueDoesNotBlockBroadcast(t *testing.T) {
+	s, err := server.NewTCPServer(1000, nil, nil, server.WithWriteQueue(4, server.OverflowDropNewest))
+	assert.NoError(t, err)
+	defer s.Stop()
+
+	// The pipe of the slow client is never read, so its first write never finishes
+	slowConn, _ := net.Pipe()
+	slowID := s.AddNewConnection(slowConn)
+
+	fastConn, fastClientConn := net.Pipe()
+	fastID := s.AddNewConnection(fastConn)
+
+	received := make(chan int, 10)
+	go readSequence(fastClientConn, received)
+
+	slowFailed := false
+	for i := 0; i < 10; i++ {
+		errs := s.BroadcastPacket(&sequencePacket{N: i}, -1)
+		assert.NotContains(t, errs, fastID)
+
+		if err, ok := errs[slowID]; ok {
+			assert.IsType(t, &server.WriteQueueFullErr{}, err)
+			slowFailed = true
+		}
+
+		// Give the fast client time to read, as the test only needs the slow client to fall behind
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, slowFailed)
+
+	for i := 0; i < 10; i++ {
+		select {
+		case n := <-received:
+			assert.Equal(t, i, n)
+		case <-time.After(time.Second):
+			t.Fatal("fast client did not receive every packet")
+		}
+	}
+}
+
+func TestTCPServerWriteQueueDropOldest(t *testing.T) {
+	s, err := server.NewTCPServer(1000, nil, nil, server.WithWriteQueue(2, server.OverflowDropOldest))
+	assert.NoError(t, err)
+	defer s.Stop()
+
+	serverConn, clientConn := net.Pipe()
+	clientID := s.AddNewConnection(serverConn)
+
+	for i := 0; i < 10; i++ {
+		err = s.SendPacket(clientID, &sequencePacket{N: i})
+		assert.NoError(t, err)
+	}
+
+	received := make(chan int, 10)
+	go readSequence(clientConn, received)
+
+	// The packet being written when the queue filled up may come first,
+	// but only the newest packets are left in the queue after it
+	var numbers []int
+	for len(numbers) == 0 || numbers[len(numbers)-1] != 9 {
+		select {
+		case n := <-received:
+			numbers = append(numbers, n)
+		case <-time.After(time.Second):
+			t.Fatalf("newest packet was not received, got %v", numbers)
+		}
+	}
+
+	assert.True(t, len(numbers) <= 3, "received %v", numbers)
+	assert.Equal(t, 8, numbers[len(numbers)-2])
+}
+
+func TestTCPServerWriteQueueDropOldestKeepsControlFrames(t *testing.T) {
+	s, err := server.NewTCPServer(1000, nil, nil, server.WithWriteQueue(2, server.OverflowDropOldest))
+	assert.NoError(t, err)
+	defer s.Stop()
+
+	serverConn, clientConn := net.Pipe()
+	clientID := s.AddNewConnection(serverConn)
+
+	// The client pings before reading anything, so the pong waits in the queue behind the first packet
+	err = s.SendPacket(clientID, &sequencePacket{N: 0})
+	assert.NoError(t, err)
+
+	go common.WriteControlFrame(clientConn, common.ControlPing, nil)
+	err = s.ReceivePacket(clientID)
+	assert.NoError(t, err)
+
+	for i := 1; i < 10; i++ {
+		err = s.SendPacket(clientID, &sequencePacket{N: i})
+		assert.NoError(t, err)
+	}
+
+	r := common.NewFrameReader(clientConn, 1000)
+	ponged := false
+	for {
+		clientConn.SetReadDeadline(time.Now().Add(time.Second))
+		frame, err := r.ReadFrame()
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if frame.IsControl() {
+			assert.Equal(t, uint16(common.ControlPong), frame.PacketID)
+			ponged = true
+			continue
+		}
+
+		var p sequencePacket
+		common.DecodePacket(&p, frame.Payload, nil)
+		if p.N == 9 {
+			break
+		}
+	}
+	assert.True(t, ponged, "pong was dropped to make room for packets")
+}
+
+func TestTCPServerWriteQueueDropNewest(t *testing.T) {
+	s, err := server.NewTCPServer(1000, nil, nil, server.WithWriteQueue(2, server.OverflowDropNewest))
+	assert.NoError(t, err)
+	defer s.Stop()
+
+	serverConn, clientConn := net.Pipe()
+	clientID := s.AddNewConnection(serverConn)
+
+	// Nothing is read yet, so the packets that do not fit in the queue are dropped
+	var sent []int
+	for i := 0; i < 10; i++ {
+		err = s.SendPacket(clientID, &sequencePacket{N: i})
+		if err != nil {
+			assert.IsType(t, &server.WriteQueueFullErr{}, err)
+			continue
+		}
+
+		sent = append(sent, i)
+	}
+
+	// Besides the packets in the queue, the writer may have taken the first one before the queue filled up
+	assert.True(t, len(sent) == 2 || len(sent) == 3, "sent %v", sent)
+
+	received := make(chan int, 10)
+	go readSequence(clientConn, received)
+
+	var numbers []int
+	for len(numbers) < len(sent) {
+		select {
+		case n := <-received:
+			numbers = append(numbers, n)
+		case <-time.After(time.Second):
+			t.Fatalf("queued packets were not received, got %v", numbers)
+		}
+	}
+	assert.Equal(t, sent, numbers)
+
+	select {
+	case n := <-received:
+		t.Fatalf("dropped packet %d was received", n)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestTCPServerWriteTimeout(t *testing.T) {
+	disconnected := make(chan error, 1)
+	connected := make(chan server.ClientID, 1)
+	s, err := server.NewTCPServer(60000, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	}, server.WithWriteTimeout(time.Millisecond*50))
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	clientID := <-connected
+
+	// Without a write queue, sending blocks once the client's socket buffers are full, until the write times out
+	data := make([]byte, 50000)
+	for i := 0; ; i++ {
+		if i == 10000 {
+			t.Fatal("write never timed out")
+		}
+
+		if err = s.SendPacket(clientID, &sequencePacket{N: i, Data: data}); err != nil {
+			break
+		}
+	}
+
+	if assert.IsType(t, &common.SendErr{}, err) {
+		netErr, ok := err.(*common.SendErr).Err.(net.Error)
+		assert.True(t, ok && netErr.Timeout())
+	}
+
+	select {
+	case err := <-disconnected:
+		assert.IsType(t, &common.SendErr{}, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("client was not disconnected")
+	}
+}
+
+func TestTCPServerWriteQueueDisconnectsSlowClient(t *testing.T) {
+	disconnected := make(chan error, 1)
+	connected := make(chan server.ClientID, 1)
+	s, err := server.NewTCPServer(60000, func(clientID server.ClientID) {
+		connected <- clientID
+	}, func(clientID server.ClientID, err error) {
+		disconnected <- err
+	}, server.WithWriteQueue(1, server.OverflowDisconnect))
+	assert.NoError(t, err)
+
+	go s.Start("0")
+	defer s.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	conn, err := dial(s.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	clientID := <-connected
+
+	// Send until the client's socket buffers and write queue are full, since it never reads
+	data := make([]byte, 50000)
+	for i := 0; ; i++ {
+		if i == 10000 {
+			t.Fatal("write queue never filled up")
+		}
+
+		if err = s.SendPacket(clientID, &sequencePacket{N: i, Data: data}); err != nil {
+			break
+		}
+	}
+	assert.IsType(t, &server.WriteQueueFullErr{}, err)
+
+	// The client's queue is closed, so packets sent while it is being disconnected fail rather than vanish
+	err = s.SendPacket(clientID, &sequencePacket{N: -1})
+	assert.IsType(t, &common.SendErr{}, err)
+
+	select {
+	case err := <-disconnected:
+		assert.IsType(t, &server.WriteQueueFullErr{}, err)
+	case <-time.After(common.GoodbyeTimeout * 3):
+		t.Fatal("slow client was not disconnected")
+	}
+}
+
+func TestTCPServerBroadcastPacketFailures(t *testing.T) {
+	s, err := server.NewTCPServer(10, nil, nil)
+	assert.NoError(t, err)
+
+	clientID := s.AddNewConnection(&net.TCPConn{})
+
+	errs := s.BroadcastPacket(&TestPacket{}, -1)
+	assert.Len(t, errs, 1)
+	assert.IsType(t, &common.SendErr{}, errs[clientID])
+
+	errs = s.BroadcastPacket(&TestPacket{}, clientID)
+	assert.Nil(t, errs)
+}